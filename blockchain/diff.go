@@ -0,0 +1,151 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/eris-ltd/decerver-interfaces/modules"
+)
+
+// Returned by StateDiff for chains that do not implement modules.StateHistory. None
+// of the chains in glue do yet.
+var ErrNoStateHistory = errors.New("The blockchain does not keep state history")
+
+// StateDiff returns the accounts that were created, deleted and modified between
+// two blocks. The blockchain must keep state history (see modules.StateHistory);
+// if it does not, ErrNoStateHistory is returned. Block updates do not need it, they
+// fall back to the accounts the transactions touched.
+func StateDiff(bc modules.Blockchain, fromBlock, toBlock string) (*modules.StateDiff, error) {
+	from, err := worldStateAt(bc, fromBlock)
+	if err != nil {
+		return nil, err
+	}
+	to, err := worldStateAt(bc, toBlock)
+	if err != nil {
+		return nil, err
+	}
+	diff := DiffWorldStates(from, to)
+	diff.FromBlock = fromBlock
+	diff.ToBlock = toBlock
+	return diff, nil
+}
+
+func worldStateAt(bc modules.Blockchain, blockHash string) (*modules.WorldState, error) {
+	sh, ok := bc.(modules.StateHistory)
+	if !ok {
+		return nil, ErrNoStateHistory
+	}
+	ws := &modules.WorldState{}
+	if err := decodeData(sh.WorldStateAt(blockHash), ws); err != nil {
+		return nil, fmt.Errorf("No state for block %s: %s", blockHash, err)
+	}
+	return ws, nil
+}
+
+// DiffWorldStates compares two world states. Created and modified accounts are
+// in the order of 'to', deleted ones in the order of 'from'. Unchanged accounts
+// are left out.
+func DiffWorldStates(from, to *modules.WorldState) *modules.StateDiff {
+	diff := &modules.StateDiff{
+		Created:  make([]*modules.AccountDiff, 0),
+		Deleted:  make([]*modules.AccountDiff, 0),
+		Modified: make([]*modules.AccountDiff, 0),
+	}
+	for _, addr := range to.Order {
+		acc := to.Accounts[addr]
+		if acc == nil {
+			continue
+		}
+		old := from.Accounts[addr]
+		if old == nil {
+			diff.Created = append(diff.Created, diffAccount(addr, nil, acc))
+		} else if ad := diffAccount(addr, old, acc); accountChanged(ad) {
+			diff.Modified = append(diff.Modified, ad)
+		}
+	}
+	for _, addr := range from.Order {
+		old := from.Accounts[addr]
+		if old != nil && to.Accounts[addr] == nil {
+			diff.Deleted = append(diff.Deleted, diffAccount(addr, old, nil))
+		}
+	}
+	return diff
+}
+
+// Either account may be nil.
+func diffAccount(addr string, old, acc *modules.Account) *modules.AccountDiff {
+	ad := &modules.AccountDiff{Address: addr}
+	var oldStorage, storage *modules.Storage
+	if old != nil {
+		ad.OldBalance = old.Balance
+		ad.OldNonce = old.Nonce
+		ad.Contract = old.IsScript || old.Script != ""
+		oldStorage = old.Storage
+	}
+	if acc != nil {
+		ad.Balance = acc.Balance
+		ad.Nonce = acc.Nonce
+		ad.Contract = acc.IsScript || acc.Script != ""
+		storage = acc.Storage
+	}
+	ad.BalanceDelta = delta(ad.OldBalance, ad.Balance)
+	ad.NonceDelta = delta(ad.OldNonce, ad.Nonce)
+	ad.Storage = diffStorage(oldStorage, storage)
+	return ad
+}
+
+func accountChanged(ad *modules.AccountDiff) bool {
+	return ad.OldBalance != ad.Balance || ad.OldNonce != ad.Nonce || len(ad.Storage) > 0
+}
+
+// Changed and added slots in the order of the new storage, then removed ones in
+// the order of the old storage. Empty values are the same as no value.
+func diffStorage(old, st *modules.Storage) []*modules.StorageDelta {
+	deltas := make([]*modules.StorageDelta, 0)
+	if old == nil {
+		old = &modules.Storage{}
+	}
+	if st == nil {
+		st = &modules.Storage{}
+	}
+	for _, key := range st.Order {
+		v, ov := st.Storage[key], old.Storage[key]
+		if v != ov {
+			deltas = append(deltas, &modules.StorageDelta{Key: key, OldValue: ov, Value: v})
+		}
+	}
+	for _, key := range old.Order {
+		ov := old.Storage[key]
+		if _, ok := st.Storage[key]; !ok && ov != "" {
+			deltas = append(deltas, &modules.StorageDelta{Key: key, OldValue: ov})
+		}
+	}
+	return deltas
+}
+
+// New minus old, in decimal. Empty values are 0.
+func delta(old, val string) string {
+	o, ok1 := parseNumber(old)
+	v, ok2 := parseNumber(val)
+	if !ok1 || !ok2 {
+		return ""
+	}
+	return new(big.Int).Sub(v, o).String()
+}
+
+// Decimal, or hex with a 0x prefix.
+func parseNumber(s string) (*big.Int, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return new(big.Int), true
+	}
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		if len(s) == 2 {
+			return new(big.Int), true
+		}
+		return new(big.Int).SetString(s[2:], 16)
+	}
+	return new(big.Int).SetString(s, 10)
+}
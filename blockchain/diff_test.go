@@ -0,0 +1,203 @@
+package blockchain
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/eris-ltd/decerver-interfaces/modules"
+)
+
+// A fake chain that keeps state history.
+type historyChain struct {
+	*fakeChain
+}
+
+func (hc historyChain) WorldStateAt(blockHash string) modules.JsObject {
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+	ws, ok := hc.states[blockHash]
+	if !ok {
+		return modules.JsReturnValErr(fmt.Errorf("No such block: %s", blockHash))
+	}
+	return modules.JsReturnVal(modules.ToMap(ws), nil)
+}
+
+// A deep copy of the accounts, in address order.
+func (fc *fakeChain) snapshot() *modules.WorldState {
+	ws := &modules.WorldState{Accounts: make(map[string]*modules.Account)}
+	for _, addr := range fc.addresses() {
+		acc := fc.accounts[addr]
+		cp := *acc
+		cp.Storage = &modules.Storage{Storage: make(map[string]string), Order: append([]string{}, acc.Storage.Order...)}
+		for k, v := range acc.Storage.Storage {
+			cp.Storage.Storage[k] = v
+		}
+		ws.Accounts[addr] = &cp
+		ws.Order = append(ws.Order, addr)
+	}
+	return ws
+}
+
+func worldState(accs ...*modules.Account) *modules.WorldState {
+	ws := &modules.WorldState{Accounts: make(map[string]*modules.Account)}
+	for _, acc := range accs {
+		ws.Accounts[acc.Address] = acc
+		ws.Order = append(ws.Order, acc.Address)
+	}
+	return ws
+}
+
+func storage(kvs ...string) *modules.Storage {
+	st := &modules.Storage{Storage: make(map[string]string)}
+	for i := 0; i+1 < len(kvs); i += 2 {
+		st.Storage[kvs[i]] = kvs[i+1]
+		st.Order = append(st.Order, kvs[i])
+	}
+	return st
+}
+
+func TestDiffWorldStates(t *testing.T) {
+	from := worldState(
+		&modules.Account{Address: "a", Balance: "100", Nonce: "1", Storage: storage()},
+		&modules.Account{Address: "b", Balance: "5", Nonce: "0", Storage: storage()},
+		&modules.Account{Address: "c", Balance: "0", Nonce: "0", Script: "60", Storage: storage("0x1", "0x1", "0x2", "0x2", "0x3", "0x3")},
+		&modules.Account{Address: "d", Balance: "0x10", Nonce: "0", Storage: storage("0x1", "0xff")},
+	)
+	to := worldState(
+		&modules.Account{Address: "e", Balance: "1", Nonce: "0", Script: "60", Storage: storage("0x1", "0x9")},
+		&modules.Account{Address: "c", Balance: "0", Nonce: "0", Script: "60", Storage: storage("0x1", "0x1", "0x2", "0x7", "0x4", "0x4")},
+		&modules.Account{Address: "b", Balance: "5", Nonce: "0", Storage: storage()},
+		&modules.Account{Address: "a", Balance: "70", Nonce: "2", Storage: storage()},
+	)
+	diff := DiffWorldStates(from, to)
+
+	if len(diff.Created) != 1 || diff.Created[0].Address != "e" || !diff.Created[0].Contract || diff.Created[0].BalanceDelta != "1" {
+		t.Fatalf("Bad created accounts: %+v", diff.Created)
+	}
+	if !reflect.DeepEqual(diff.Created[0].Storage, []*modules.StorageDelta{{Key: "0x1", Value: "0x9"}}) {
+		t.Fatalf("Bad storage of created account: %+v", diff.Created[0].Storage)
+	}
+	if len(diff.Deleted) != 1 || diff.Deleted[0].Address != "d" || diff.Deleted[0].Balance != "" || diff.Deleted[0].BalanceDelta != "-16" {
+		t.Fatalf("Bad deleted accounts: %+v", diff.Deleted)
+	}
+	if len(diff.Modified) != 2 || diff.Modified[0].Address != "c" || diff.Modified[1].Address != "a" {
+		t.Fatalf("Bad modified accounts: %+v", diff.Modified)
+	}
+	a := diff.Modified[1]
+	if a.OldBalance != "100" || a.Balance != "70" || a.BalanceDelta != "-30" || a.NonceDelta != "1" || len(a.Storage) != 0 {
+		t.Fatalf("Bad diff of a: %+v", a)
+	}
+	expected := []*modules.StorageDelta{
+		{Key: "0x2", OldValue: "0x2", Value: "0x7"},
+		{Key: "0x4", Value: "0x4"},
+		{Key: "0x3", OldValue: "0x3"},
+	}
+	if !reflect.DeepEqual(diff.Modified[0].Storage, expected) {
+		for _, d := range diff.Modified[0].Storage {
+			t.Logf("%+v", d)
+		}
+		t.Fatal("Bad storage diff of c")
+	}
+
+	if d := delta("0x", "abc"); d != "" {
+		t.Fatalf("Expected no delta for a non number, got %q", d)
+	}
+}
+
+func TestStateDiff(t *testing.T) {
+	fc := newFakeChain()
+	hc := historyChain{fc}
+	srv := httptest.NewServer(NewHttpAPI(hc))
+	defer srv.Close()
+
+	fc.Tx("aa02", "250")
+	contract, _ := jsResult(fc.Script("code", "lll"))
+	addr := field(contract, "Address").(string)
+	fc.Msg(addr, []string{"0x1", "0x2"})
+	fc.Commit()
+
+	diff := &modules.StateDiff{}
+	if err := call(t, srv.URL, "StateDiff", `["b0","b1"]`, diff); err != nil {
+		t.Fatal(err)
+	}
+	if diff.FromBlock != "b0" || diff.ToBlock != "b1" || len(diff.Created) != 2 || len(diff.Modified) != 1 || len(diff.Deleted) != 0 {
+		t.Fatalf("Bad diff: %+v", diff)
+	}
+	m := diff.Modified[0]
+	if m.Address != "aa01" || m.BalanceDelta != "-250" || m.NonceDelta != "1" {
+		t.Fatalf("Bad diff of aa01: %+v", m)
+	}
+	for _, c := range diff.Created {
+		if c.Address == addr && (len(c.Storage) != 1 || c.Storage[0].Value != "0x2") {
+			t.Fatalf("Bad storage of %s: %+v", addr, c.Storage)
+		}
+	}
+
+	// Deleting the contract.
+	fc.mutex.Lock()
+	delete(fc.accounts, addr)
+	fc.mutex.Unlock()
+	fc.Commit()
+	if err := call(t, srv.URL, "StateDiff", `{"From":"b1","To":"b2"}`, diff); err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Created) != 0 || len(diff.Modified) != 0 || len(diff.Deleted) != 1 || diff.Deleted[0].Address != addr {
+		t.Fatalf("Bad diff: %+v", diff)
+	}
+	if !reflect.DeepEqual(diff.Deleted[0].Storage, []*modules.StorageDelta{{Key: "0x1", OldValue: "0x2"}}) {
+		t.Fatalf("Bad storage of deleted account: %+v", diff.Deleted[0].Storage)
+	}
+
+	if err := call(t, srv.URL, "StateDiff", `["b0","b9"]`, nil); err == nil {
+		t.Fatal("Expected an error for an unknown block")
+	}
+}
+
+func TestStateDiffWithoutHistory(t *testing.T) {
+	fc := newFakeChain()
+	srv := httptest.NewServer(NewHttpAPI(fc))
+	defer srv.Close()
+	fc.Tx("aa02", "250")
+	fc.Commit()
+
+	if _, err := StateDiff(fc, "b0", "b1"); err != ErrNoStateHistory {
+		t.Fatalf("Expected ErrNoStateHistory, got: %v", err)
+	}
+	err := call(t, srv.URL, "StateDiff", `["b0","b1"]`, nil)
+	if err == nil || err.Code != CHAIN_ERROR || err.Message != ErrNoStateHistory.Error() {
+		t.Fatalf("Expected a chain error, got: %v", err)
+	}
+}
+
+func TestBlockMiniFromDiff(t *testing.T) {
+	fc := newFakeChain()
+	hc := historyChain{fc}
+	fc.Tx("aa05", "10")
+	fc.Commit()
+
+	block, err := getBlock(hc, "b1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bm := &modules.BlockMini{}
+	getBlockMiniFromBlock(hc, bm, block)
+	if bm.PrevHash != "b0" || len(bm.AccountsAffected) != 2 {
+		t.Fatalf("Bad block mini: %+v", bm)
+	}
+	for _, am := range bm.AccountsAffected {
+		switch am.Address {
+		case "aa05":
+			if am.Flag != ACCOUNT_CREATED || am.Balance != "10" {
+				t.Fatalf("Bad created account: %+v", am)
+			}
+		case "aa01":
+			if am.Flag != ACCOUNT_MODIFIED || am.Balance != "990" || am.Nonce != "1" {
+				t.Fatalf("Bad modified account: %+v", am)
+			}
+		default:
+			t.Fatalf("Unexpected account: %+v", am)
+		}
+	}
+}
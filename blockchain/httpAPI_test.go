@@ -0,0 +1,502 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/eris-ltd/decerver-interfaces/events"
+	"github.com/eris-ltd/decerver-interfaces/modules"
+)
+
+// An in-memory chain. Tx moves value from the active address. Transactions are
+// pending until Commit, which adds a block and posts a 'newBlock' event.
+type fakeChain struct {
+	mutex      *sync.Mutex
+	accounts   map[string]*modules.Account
+	blocks     map[string]*modules.Block
+	latest     string
+	keys       []string
+	active     int
+	autoCommit bool
+	pending    []*modules.Transaction
+	// See wsAPI_test.go.
+	subs map[string]*fakeSub
+	// Called after a subscription is made, if set.
+	subscribed func(name string)
+	// The world state after each block. See diff_test.go.
+	states map[string]*modules.WorldState
+}
+
+func newFakeChain() *fakeChain {
+	fc := &fakeChain{}
+	fc.mutex = &sync.Mutex{}
+	fc.accounts = make(map[string]*modules.Account)
+	fc.blocks = make(map[string]*modules.Block)
+	fc.subs = make(map[string]*fakeSub)
+	fc.states = make(map[string]*modules.WorldState)
+	fc.keys = []string{"aa01", "aa02"}
+	fc.setAccount("aa01", "1000", "", nil)
+	genesis := &modules.Block{Number: "0", Hash: "b0", Coinbase: "aa01"}
+	fc.blocks["b0"] = genesis
+	fc.latest = "b0"
+	fc.states["b0"] = fc.snapshot()
+	return fc
+}
+
+func (fc *fakeChain) setAccount(addr, balance, script string, storage map[string]string) {
+	st := &modules.Storage{Storage: make(map[string]string), Order: []string{}}
+	for k, v := range storage {
+		st.Storage[k] = v
+		st.Order = append(st.Order, k)
+	}
+	fc.accounts[addr] = &modules.Account{
+		Address:  addr,
+		Balance:  balance,
+		Nonce:    "0",
+		Script:   script,
+		Storage:  st,
+		IsScript: script != "",
+	}
+}
+
+// Accounts are in address order.
+func (fc *fakeChain) addresses() []string {
+	addrs := make([]string, 0, len(fc.accounts))
+	for addr := range fc.accounts {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+func (fc *fakeChain) WorldState() modules.JsObject {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	ws := &modules.WorldState{Accounts: make(map[string]*modules.Account)}
+	for _, addr := range fc.addresses() {
+		ws.Accounts[addr] = fc.accounts[addr]
+		ws.Order = append(ws.Order, addr)
+	}
+	return modules.JsReturnVal(modules.ToMap(ws), nil)
+}
+
+func (fc *fakeChain) State() modules.JsObject {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	st := &modules.State{State: make(map[string]*modules.Storage)}
+	for _, addr := range fc.addresses() {
+		st.State[addr] = fc.accounts[addr].Storage
+		st.Order = append(st.Order, addr)
+	}
+	return modules.JsReturnVal(modules.ToMap(st), nil)
+}
+
+func (fc *fakeChain) Storage(target string) modules.JsObject {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	acc, ok := fc.accounts[target]
+	if !ok {
+		return modules.JsReturnValErr(fmt.Errorf("No such account: %s", target))
+	}
+	return modules.JsReturnVal(modules.ToMap(acc.Storage), nil)
+}
+
+func (fc *fakeChain) Account(target string) modules.JsObject {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	acc, ok := fc.accounts[target]
+	if !ok {
+		return modules.JsReturnValErr(fmt.Errorf("No such account: %s", target))
+	}
+	return modules.JsReturnVal(modules.ToMap(acc), nil)
+}
+
+func (fc *fakeChain) StorageAt(target, storage string) modules.JsObject {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	acc, ok := fc.accounts[target]
+	if !ok {
+		return modules.JsReturnValErr(fmt.Errorf("No such account: %s", target))
+	}
+	return modules.JsReturnVal(acc.Storage.Storage[storage], nil)
+}
+
+func (fc *fakeChain) BlockCount() modules.JsObject {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	return modules.JsReturnVal(len(fc.blocks), nil)
+}
+
+func (fc *fakeChain) LatestBlock() modules.JsObject {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	return modules.JsReturnVal(fc.latest, nil)
+}
+
+func (fc *fakeChain) Block(hash string) modules.JsObject {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	b, ok := fc.blocks[hash]
+	if !ok {
+		return modules.JsReturnValErr(fmt.Errorf("No such block: %s", hash))
+	}
+	return modules.JsReturnVal(modules.ToMap(b), nil)
+}
+
+func (fc *fakeChain) IsScript(target string) modules.JsObject {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	acc, ok := fc.accounts[target]
+	return modules.JsReturnVal(ok && acc.IsScript, nil)
+}
+
+func (fc *fakeChain) Tx(addr, amt string) modules.JsObject {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	if _, ok := fc.accounts[addr]; !ok {
+		fc.setAccount(addr, "0", "", nil)
+	}
+	acc := fc.accounts[addr]
+	acc.Balance = addStrings(acc.Balance, amt)
+	from := fc.accounts[fc.keys[fc.active]]
+	from.Balance = addStrings(from.Balance, "-"+amt)
+	from.Nonce = addStrings(from.Nonce, "1")
+	tx := &modules.Transaction{Hash: fmt.Sprintf("tx%d", len(fc.pending)), Sender: from.Address, Recipient: addr, Value: amt}
+	fc.pending = append(fc.pending, tx)
+	return modules.JsReturnVal(modules.JsObject{"Hash": tx.Hash, "Address": "", "Error": ""}, nil)
+}
+
+func (fc *fakeChain) Msg(addr string, data []string) modules.JsObject {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	acc, ok := fc.accounts[addr]
+	if !ok || !acc.IsScript {
+		return modules.JsReturnValErr(fmt.Errorf("Not a contract: %s", addr))
+	}
+	for i := 0; i+1 < len(data); i += 2 {
+		if _, ok := acc.Storage.Storage[data[i]]; !ok {
+			acc.Storage.Order = append(acc.Storage.Order, data[i])
+		}
+		acc.Storage.Storage[data[i]] = data[i+1]
+	}
+	tx := &modules.Transaction{Hash: fmt.Sprintf("tx%d", len(fc.pending)), Sender: fc.keys[fc.active], Recipient: addr}
+	fc.pending = append(fc.pending, tx)
+	return modules.JsReturnVal(modules.JsObject{"Hash": tx.Hash, "Address": "", "Error": ""}, nil)
+}
+
+func (fc *fakeChain) Script(file, lang string) modules.JsObject {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	if file == "" {
+		return modules.JsReturnValErr(fmt.Errorf("No script"))
+	}
+	addr := fmt.Sprintf("cc%02d", len(fc.accounts))
+	fc.setAccount(addr, "0", file, nil)
+	tx := &modules.Transaction{Hash: fmt.Sprintf("tx%d", len(fc.pending)), Sender: fc.keys[fc.active], Recipient: addr, ContractCreation: true}
+	fc.pending = append(fc.pending, tx)
+	return modules.JsReturnVal(modules.JsObject{"Hash": "", "Address": addr, "Error": ""}, nil)
+}
+
+func (fc *fakeChain) Commit() modules.JsObject {
+	fc.mutex.Lock()
+	b := fc.commit()
+	fc.mutex.Unlock()
+	fc.post(events.EVENT_NEW_BLOCK, "", b)
+	return modules.JsReturnVal(nil, nil)
+}
+
+func (fc *fakeChain) commit() *modules.Block {
+	n := len(fc.blocks)
+	b := &modules.Block{
+		Number:       fmt.Sprintf("%d", n),
+		Hash:         fmt.Sprintf("b%d", n),
+		PrevHash:     fc.latest,
+		Coinbase:     fc.keys[fc.active],
+		Transactions: fc.pending,
+	}
+	fc.pending = nil
+	fc.blocks[b.Hash] = b
+	fc.latest = b.Hash
+	fc.states[b.Hash] = fc.snapshot()
+	return b
+}
+
+func (fc *fakeChain) AutoCommit(toggle bool) modules.JsObject {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	fc.autoCommit = toggle
+	return modules.JsReturnVal(nil, nil)
+}
+
+func (fc *fakeChain) IsAutocommit() modules.JsObject {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	return modules.JsReturnVal(fc.autoCommit, nil)
+}
+
+func (fc *fakeChain) ActiveAddress() modules.JsObject {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	return modules.JsReturnVal(fc.keys[fc.active], nil)
+}
+
+func (fc *fakeChain) Address(n int) modules.JsObject {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	if n < 0 || n >= len(fc.keys) {
+		return modules.JsReturnValErr(fmt.Errorf("cursor %d out of range (0..%d)", n, len(fc.keys)))
+	}
+	return modules.JsReturnVal(fc.keys[n], nil)
+}
+
+func (fc *fakeChain) SetAddress(addr string) modules.JsObject {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	for i, k := range fc.keys {
+		if k == addr {
+			fc.active = i
+			return modules.JsReturnValNoErr(nil)
+		}
+	}
+	return modules.JsReturnValErr(fmt.Errorf("Address %s not found in keyring", addr))
+}
+
+func (fc *fakeChain) SetAddressN(n int) modules.JsObject {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	fc.active = n
+	return modules.JsReturnValNoErr(nil)
+}
+
+func (fc *fakeChain) NewAddress(set bool) modules.JsObject {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	addr := fmt.Sprintf("aa%02d", len(fc.keys)+1)
+	fc.keys = append(fc.keys, addr)
+	if set {
+		fc.active = len(fc.keys) - 1
+	}
+	return modules.JsReturnValNoErr(addr)
+}
+
+func (fc *fakeChain) Addresses() modules.JsObject {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	return modules.JsReturnVal(modules.JsObject{"Addresses": append([]string{}, fc.keys...)}, nil)
+}
+
+func (fc *fakeChain) AddressCount() modules.JsObject {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	return modules.JsReturnValNoErr(len(fc.keys))
+}
+
+func addStrings(a, b string) string {
+	var x, y int
+	fmt.Sscan(a, &x)
+	fmt.Sscan(b, &y)
+	return fmt.Sprintf("%d", x+y)
+}
+
+func post(t *testing.T, url, body string) (int, []byte) {
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, b
+}
+
+// Calls a method and decodes the result into res. Returns the error, if any.
+func call(t *testing.T, url, method, params string, res interface{}) *Error {
+	body := fmt.Sprintf(`{"jsonrpc":"2.0","id":1,"method":"%s","params":%s}`, method, params)
+	code, b := post(t, url, body)
+	if code != http.StatusOK {
+		t.Fatalf("%s: status %d: %s", method, code, b)
+	}
+	resp := &struct {
+		Jsonrpc string
+		Result  json.RawMessage
+		Error   *Error
+		Id      int
+	}{}
+	if err := json.Unmarshal(b, resp); err != nil {
+		t.Fatalf("%s: %s: %s", method, err, b)
+	}
+	if resp.Jsonrpc != "2.0" || resp.Id != 1 {
+		t.Fatalf("%s: bad response: %s", method, b)
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if res != nil {
+		if err := json.Unmarshal(resp.Result, res); err != nil {
+			t.Fatalf("%s: %s: %s", method, err, resp.Result)
+		}
+	}
+	return nil
+}
+
+func TestHttpAPI(t *testing.T) {
+	fc := newFakeChain()
+	srv := httptest.NewServer(NewHttpAPI(fc))
+	defer srv.Close()
+
+	var addr string
+	if err := call(t, srv.URL, "MyAddress", "null", &addr); err != nil || addr != "aa01" {
+		t.Fatalf("MyAddress: %q, %v", addr, err)
+	}
+	var gascost string
+	if err := call(t, srv.URL, "MinGascost", "null", &gascost); err != nil || gascost != MIN_GASCOST {
+		t.Fatalf("MinGascost: %q, %v", gascost, err)
+	}
+	var bal string
+	if err := call(t, srv.URL, "MyBalance", "[]", &bal); err != nil || bal != "1000" {
+		t.Fatalf("MyBalance: %q, %v", bal, err)
+	}
+
+	// Positional and named params.
+	receipt := &modules.TxReceipt{}
+	if err := call(t, srv.URL, "Transact", `{"Recipient":"aa02","Value":"300"}`, receipt); err != nil || !receipt.Success || receipt.Hash == "" {
+		t.Fatalf("Transact: %+v, %v", receipt, err)
+	}
+	if err := call(t, srv.URL, "BalanceAt", `["aa02"]`, &bal); err != nil || bal != "300" {
+		t.Fatalf("BalanceAt: %q, %v", bal, err)
+	}
+	if err := call(t, srv.URL, "Transact", `{"Data":"contract"}`, receipt); err != nil || !receipt.Compiled || receipt.Address == "" {
+		t.Fatalf("Transact (create): %+v, %v", receipt, err)
+	}
+	contract := receipt.Address
+	if err := call(t, srv.URL, "Transact", fmt.Sprintf(`{"Recipient":"%s","Data":"0x1\n 0x2 "}`, contract), receipt); err != nil || !receipt.Success {
+		t.Fatalf("Transact (msg): %+v, %v", receipt, err)
+	}
+	var val string
+	if err := call(t, srv.URL, "StorageAt", fmt.Sprintf(`["%s","0x1"]`, contract), &val); err != nil || val != "0x2" {
+		t.Fatalf("StorageAt: %q, %v", val, err)
+	}
+	var isContract bool
+	if err := call(t, srv.URL, "IsContract", fmt.Sprintf(`{"Target":"%s"}`, contract), &isContract); err != nil || !isContract {
+		t.Fatalf("IsContract: %v, %v", isContract, err)
+	}
+
+	if err := call(t, srv.URL, "Commit", "null", nil); err != nil {
+		t.Fatal(err)
+	}
+	block := &modules.Block{}
+	if err := call(t, srv.URL, "BlockLatest", "null", block); err != nil || block.Number != "1" || len(block.Transactions) != 3 {
+		t.Fatalf("BlockLatest: %+v, %v", block, err)
+	}
+	if err := call(t, srv.URL, "BlockByHash", `["b0"]`, block); err != nil || block.Number != "0" {
+		t.Fatalf("BlockByHash: %+v, %v", block, err)
+	}
+
+	var mining bool
+	call(t, srv.URL, "StartMining", "null", nil)
+	if err := call(t, srv.URL, "IsMining", "null", &mining); err != nil || !mining {
+		t.Fatalf("IsMining: %v, %v", mining, err)
+	}
+	call(t, srv.URL, "StopMining", "null", nil)
+	if call(t, srv.URL, "IsMining", "null", &mining); mining {
+		t.Fatal("Still mining")
+	}
+
+	if err := call(t, srv.URL, "NewAddress", "[true]", &addr); err != nil || addr != "aa03" {
+		t.Fatalf("NewAddress: %q, %v", addr, err)
+	}
+	var count int
+	if err := call(t, srv.URL, "AddressCount", "null", &count); err != nil || count != 3 {
+		t.Fatalf("AddressCount: %d, %v", count, err)
+	}
+	if err := call(t, srv.URL, "SetAddress", `["aa02"]`, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := call(t, srv.URL, "SetAddress", `["ff"]`, nil); err == nil || err.Code != CHAIN_ERROR || !strings.Contains(err.Message, "not found") {
+		t.Fatalf("Expected a chain error, got %v", err)
+	}
+
+	ws := &struct {
+		Accounts map[string]*modules.Account
+		Order    []string
+	}{}
+	if err := call(t, srv.URL, "WorldState", "null", ws); err != nil || len(ws.Order) != 3 || ws.Accounts["aa02"].Balance != "300" {
+		t.Fatalf("WorldState: %+v, %v", ws, err)
+	}
+}
+
+func TestHttpAPIErrors(t *testing.T) {
+	srv := httptest.NewServer(NewHttpAPI(newFakeChain()))
+	defer srv.Close()
+
+	if err := call(t, srv.URL, "NoSuchMethod", "null", nil); err == nil || err.Code != METHOD_NOT_FOUND {
+		t.Fatalf("Expected method not found, got %v", err)
+	}
+	if err := call(t, srv.URL, "Account", `[1]`, nil); err == nil || err.Code != INVALID_PARAMS {
+		t.Fatalf("Expected invalid params, got %v", err)
+	}
+	if err := call(t, srv.URL, "Account", `["aa01","aa02"]`, nil); err == nil || err.Code != INVALID_PARAMS {
+		t.Fatalf("Expected invalid params, got %v", err)
+	}
+
+	code, b := post(t, srv.URL, `{"jsonrpc":"2.0",`)
+	if code != http.StatusOK || !bytes.Contains(b, []byte(`"code":-32700`)) {
+		t.Fatalf("Expected a parse error, got %d: %s", code, b)
+	}
+	code, b = post(t, srv.URL, `{"jsonrpc":"1.0","id":1,"method":"BlockCount"}`)
+	if !bytes.Contains(b, []byte(`"code":-32600`)) {
+		t.Fatalf("Expected an invalid request, got %d: %s", code, b)
+	}
+
+	// Notifications get no response.
+	code, b = post(t, srv.URL, `{"jsonrpc":"2.0","method":"Commit"}`)
+	if code != http.StatusNoContent || len(b) != 0 {
+		t.Fatalf("Expected no content, got %d: %s", code, b)
+	}
+
+	code, b = post(t, srv.URL, `{"jsonrpc":"2.0","method":7}`)
+	if !bytes.Contains(b, []byte(`"code":-32600`)) || !bytes.Contains(b, []byte(`"id":null`)) {
+		t.Fatalf("Expected an invalid request with a null id, got %d: %s", code, b)
+	}
+	code, b = post(t, srv.URL, `{"jsonrpc":"2.0","method":""}`)
+	if !bytes.Contains(b, []byte(`"code":-32600`)) || !bytes.Contains(b, []byte(`"id":null`)) {
+		t.Fatalf("Expected an invalid request with a null id, got %d: %s", code, b)
+	}
+
+	code, b = post(t, srv.URL, `[{"jsonrpc":"2.0","id":1,"method":"BlockCount"},{"jsonrpc":"2.0","method":"Commit"},{"jsonrpc":"2.0","id":"x","method":"Nope"}]`)
+	var batch []*Response
+	if err := json.Unmarshal(b, &batch); err != nil || len(batch) != 2 {
+		t.Fatalf("Bad batch response %d: %s", code, b)
+	}
+	if string(*batch[0].Id) != "1" || batch[0].Result.(float64) != 2 {
+		t.Fatalf("Bad batch result: %s", b)
+	}
+	if string(*batch[1].Id) != `"x"` || batch[1].Error.Code != METHOD_NOT_FOUND {
+		t.Fatalf("Bad batch error: %s", b)
+	}
+
+	// A null id is not a notification, and a null result is sent.
+	code, b = post(t, srv.URL, `{"jsonrpc":"2.0","id":null,"method":"Commit"}`)
+	if code != http.StatusOK || string(bytes.TrimSpace(b)) != `{"jsonrpc":"2.0","result":null,"id":null}` {
+		t.Fatalf("Expected a null result, got %d: %s", code, b)
+	}
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("Expected 405, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,424 @@
+package blockchain
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/eris-ltd/decerver-interfaces/modules"
+)
+
+const (
+	DEFAULT_PAGE_SIZE = 100
+	MAX_PAGE_SIZE     = 1000
+	// Iterators give up after this many empty pages in a row.
+	MAX_EMPTY_PAGES = 16
+)
+
+// Paging works on any blockchain. If it is a modules.StatePager the pages come
+// from the blockchain, otherwise the whole state is fetched and cut into pages.
+// Either way, the order is that of the Order slices of WorldState, State and
+// Storage. The iterators fetch the whole state only once.
+
+func WorldStatePage(bc modules.Blockchain, cursor string, limit int) (*modules.WorldStatePage, error) {
+	if sp, ok := bc.(modules.StatePager); ok {
+		page := &modules.WorldStatePage{}
+		if err := decodeData(sp.WorldStatePage(cursor, pageSize(limit)), page); err != nil {
+			return nil, err
+		}
+		return page, nil
+	}
+	ws, err := loadWorldState(bc)
+	if err != nil {
+		return nil, err
+	}
+	return PageWorldState(ws, cursor, limit)
+}
+
+func StatePage(bc modules.Blockchain, cursor string, limit int) (*modules.StatePage, error) {
+	if sp, ok := bc.(modules.StatePager); ok {
+		page := &modules.StatePage{}
+		if err := decodeData(sp.StatePage(cursor, pageSize(limit)), page); err != nil {
+			return nil, err
+		}
+		return page, nil
+	}
+	st, err := loadState(bc)
+	if err != nil {
+		return nil, err
+	}
+	return PageState(st, cursor, limit)
+}
+
+func StoragePage(bc modules.Blockchain, target, cursor string, limit int) (*modules.StoragePage, error) {
+	if sp, ok := bc.(modules.StatePager); ok {
+		page := &modules.StoragePage{}
+		if err := decodeData(sp.StoragePage(target, cursor, pageSize(limit)), page); err != nil {
+			return nil, err
+		}
+		return page, nil
+	}
+	st, err := loadStorage(bc, target)
+	if err != nil {
+		return nil, err
+	}
+	return PageStorage(target, st, cursor, limit)
+}
+
+// Cut a page out of a world state. Blockchains that have their state at hand can
+// use this, and the two below, to implement modules.StatePager.
+func PageWorldState(ws *modules.WorldState, cursor string, limit int) (*modules.WorldStatePage, error) {
+	keys := make([]string, 0, len(ws.Accounts))
+	for k := range ws.Accounts {
+		keys = append(keys, k)
+	}
+	order, next, err := pageOf(orderOf(ws.Order, keys), "worldstate", cursor, pageSize(limit))
+	if err != nil {
+		return nil, err
+	}
+	page := &modules.WorldStatePage{Accounts: make(map[string]*modules.Account, len(order))}
+	for _, addr := range order {
+		page.Accounts[addr] = ws.Accounts[addr]
+	}
+	page.Order = order
+	page.Next = next
+	return page, nil
+}
+
+func PageState(st *modules.State, cursor string, limit int) (*modules.StatePage, error) {
+	keys := make([]string, 0, len(st.State))
+	for k := range st.State {
+		keys = append(keys, k)
+	}
+	order, next, err := pageOf(orderOf(st.Order, keys), "state", cursor, pageSize(limit))
+	if err != nil {
+		return nil, err
+	}
+	page := &modules.StatePage{State: make(map[string]*modules.Storage, len(order))}
+	for _, addr := range order {
+		page.State[addr] = st.State[addr]
+	}
+	page.Order = order
+	page.Next = next
+	return page, nil
+}
+
+// The target is part of the cursor, so a cursor for one account can not be used
+// for another.
+func PageStorage(target string, st *modules.Storage, cursor string, limit int) (*modules.StoragePage, error) {
+	keys := make([]string, 0, len(st.Storage))
+	for k := range st.Storage {
+		keys = append(keys, k)
+	}
+	order, next, err := pageOf(orderOf(st.Order, keys), "storage:"+target, cursor, pageSize(limit))
+	if err != nil {
+		return nil, err
+	}
+	page := &modules.StoragePage{Storage: make(map[string]string, len(order))}
+	for _, key := range order {
+		page.Storage[key] = st.Storage[key]
+	}
+	page.Order = order
+	page.Next = next
+	return page, nil
+}
+
+func loadWorldState(bc modules.Blockchain) (*modules.WorldState, error) {
+	ws := &modules.WorldState{}
+	if err := decodeData(bc.WorldState(), ws); err != nil {
+		return nil, err
+	}
+	return ws, nil
+}
+
+func loadState(bc modules.Blockchain) (*modules.State, error) {
+	// modules.ToMap puts the storage of a State in 'Storage'.
+	st := &struct {
+		State   map[string]*modules.Storage
+		Storage map[string]*modules.Storage
+		Order   []string
+	}{}
+	if err := decodeData(bc.State(), st); err != nil {
+		return nil, err
+	}
+	if len(st.State) == 0 {
+		st.State = st.Storage
+	}
+	return &modules.State{State: st.State, Order: st.Order}, nil
+}
+
+func loadStorage(bc modules.Blockchain, target string) (*modules.Storage, error) {
+	st := &modules.Storage{}
+	if err := decodeData(bc.Storage(target), st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func pageSize(limit int) int {
+	if limit <= 0 {
+		return DEFAULT_PAGE_SIZE
+	}
+	if limit > MAX_PAGE_SIZE {
+		return MAX_PAGE_SIZE
+	}
+	return limit
+}
+
+// The order, or the sorted keys if there is none.
+func orderOf(order, keys []string) []string {
+	if len(order) > 0 || len(keys) == 0 {
+		return order
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// What a cursor holds: what is paged through, and the position and key of the
+// last item that was returned.
+type pageCursor struct {
+	Scope string `json:"s"`
+	Index int    `json:"i"`
+	Key   string `json:"k"`
+}
+
+func encodeCursor(c *pageCursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s, scope string) (*pageCursor, error) {
+	c := &pageCursor{}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(b, c)
+	}
+	if err != nil || c.Scope != scope || c.Index < 0 {
+		return nil, NewError(INVALID_PARAMS, "Invalid cursor: %s", s)
+	}
+	return c, nil
+}
+
+// Where the page after the cursor starts. The page continues after the last key,
+// so items that are added or removed before it do not shift the pages. If the
+// key is gone, the position is used.
+func (c *pageCursor) start(order []string) int {
+	if c.Index > 0 && c.Index <= len(order) && order[c.Index-1] == c.Key {
+		return c.Index
+	}
+	for i, k := range order {
+		if k == c.Key {
+			return i + 1
+		}
+	}
+	if c.Index > len(order) {
+		return len(order)
+	}
+	return c.Index
+}
+
+// The keys of the page after the cursor, and the cursor of the next page.
+func pageOf(order []string, scope, cursor string, limit int) ([]string, string, error) {
+	start := 0
+	if cursor != "" {
+		c, err := decodeCursor(cursor, scope)
+		if err != nil {
+			return nil, "", err
+		}
+		start = c.start(order)
+	}
+	end := start + limit
+	if end >= len(order) {
+		return order[start:], "", nil
+	}
+	next := encodeCursor(&pageCursor{Scope: scope, Index: end, Key: order[end-1]})
+	return order[start:end], next, nil
+}
+
+// Iterates over pages: fetch gets the keys of the page after a cursor, and the
+// cursor of the next page.
+type pageIterator struct {
+	fetch   func(cursor string) ([]string, string, error)
+	order   []string
+	pos     int
+	next    string
+	fetched bool
+	err     error
+}
+
+func (it *pageIterator) advance() bool {
+	if it.err != nil {
+		return false
+	}
+	it.pos++
+	empty := 0
+	for it.pos >= len(it.order) {
+		if it.fetched && it.next == "" {
+			return false
+		}
+		cursor := it.next
+		it.order, it.next, it.err = it.fetch(cursor)
+		it.fetched = true
+		it.pos = 0
+		if it.err != nil {
+			return false
+		}
+		// A blockchain that pages by itself may get this wrong.
+		if it.next != "" && it.next == cursor {
+			it.order = nil
+			it.err = fmt.Errorf("The page after cursor '%s' points back to it", cursor)
+			return false
+		}
+		if len(it.order) > 0 {
+			break
+		}
+		if empty++; empty >= MAX_EMPTY_PAGES {
+			it.err = fmt.Errorf("%d empty pages in a row", empty)
+			return false
+		}
+	}
+	return true
+}
+
+func (it *pageIterator) key() string {
+	return it.order[it.pos]
+}
+
+// The error that stopped the iteration, if any.
+func (it *pageIterator) Err() error {
+	return it.err
+}
+
+// Streams the world state, a page at a time:
+//
+//	it := NewWorldStateIterator(bc, 0)
+//	for it.Next() {
+//		addr, acc := it.Account()
+//	}
+//	if it.Err() != nil { ... }
+type WorldStateIterator struct {
+	pageIterator
+	page *modules.WorldStatePage
+}
+
+// A pageSize of 0 is DEFAULT_PAGE_SIZE.
+func NewWorldStateIterator(bc modules.Blockchain, pageSize int) *WorldStateIterator {
+	it := &WorldStateIterator{}
+	it.pos = -1
+	_, paged := bc.(modules.StatePager)
+	var ws *modules.WorldState
+	it.fetch = func(cursor string) ([]string, string, error) {
+		var page *modules.WorldStatePage
+		var err error
+		if paged {
+			page, err = WorldStatePage(bc, cursor, pageSize)
+		} else {
+			// One snapshot for the whole iteration.
+			if ws == nil {
+				ws, err = loadWorldState(bc)
+			}
+			if err == nil {
+				page, err = PageWorldState(ws, cursor, pageSize)
+			}
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		it.page = page
+		return page.Order, page.Next, nil
+	}
+	return it
+}
+
+func (it *WorldStateIterator) Next() bool {
+	return it.advance()
+}
+
+func (it *WorldStateIterator) Account() (string, *modules.Account) {
+	addr := it.key()
+	return addr, it.page.Accounts[addr]
+}
+
+type StateIterator struct {
+	pageIterator
+	page *modules.StatePage
+}
+
+func NewStateIterator(bc modules.Blockchain, pageSize int) *StateIterator {
+	it := &StateIterator{}
+	it.pos = -1
+	_, paged := bc.(modules.StatePager)
+	var st *modules.State
+	it.fetch = func(cursor string) ([]string, string, error) {
+		var page *modules.StatePage
+		var err error
+		if paged {
+			page, err = StatePage(bc, cursor, pageSize)
+		} else {
+			if st == nil {
+				st, err = loadState(bc)
+			}
+			if err == nil {
+				page, err = PageState(st, cursor, pageSize)
+			}
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		it.page = page
+		return page.Order, page.Next, nil
+	}
+	return it
+}
+
+func (it *StateIterator) Next() bool {
+	return it.advance()
+}
+
+func (it *StateIterator) Storage() (string, *modules.Storage) {
+	addr := it.key()
+	return addr, it.page.State[addr]
+}
+
+type StorageIterator struct {
+	pageIterator
+	page *modules.StoragePage
+}
+
+func NewStorageIterator(bc modules.Blockchain, target string, pageSize int) *StorageIterator {
+	it := &StorageIterator{}
+	it.pos = -1
+	_, paged := bc.(modules.StatePager)
+	var st *modules.Storage
+	it.fetch = func(cursor string) ([]string, string, error) {
+		var page *modules.StoragePage
+		var err error
+		if paged {
+			page, err = StoragePage(bc, target, cursor, pageSize)
+		} else {
+			if st == nil {
+				st, err = loadStorage(bc, target)
+			}
+			if err == nil {
+				page, err = PageStorage(target, st, cursor, pageSize)
+			}
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		it.page = page
+		return page.Order, page.Next, nil
+	}
+	return it
+}
+
+func (it *StorageIterator) Next() bool {
+	return it.advance()
+}
+
+// The storage key and value.
+func (it *StorageIterator) Slot() (string, string) {
+	key := it.key()
+	return key, it.page.Storage[key]
+}
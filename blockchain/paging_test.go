@@ -0,0 +1,277 @@
+package blockchain
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/eris-ltd/decerver-interfaces/modules"
+)
+
+// A fake chain that pages by itself, and fails if the whole state is asked for.
+type pagerChain struct {
+	*fakeChain
+	pages *int
+}
+
+func (pc pagerChain) WorldState() modules.JsObject {
+	return modules.JsReturnValErr(fmt.Errorf("Too large"))
+}
+
+func (pc pagerChain) WorldStatePage(cursor string, limit int) modules.JsObject {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	*pc.pages++
+	order, next, err := pageOf(pc.addresses(), "pager", cursor, limit)
+	if err != nil {
+		return modules.JsReturnValErr(err)
+	}
+	page := &modules.WorldStatePage{Accounts: make(map[string]*modules.Account), Order: order, Next: next}
+	for _, addr := range order {
+		page.Accounts[addr] = pc.accounts[addr]
+	}
+	return modules.JsReturnVal(modules.ToMap(page), nil)
+}
+
+func (pc pagerChain) StatePage(cursor string, limit int) modules.JsObject {
+	return modules.JsReturnValErr(fmt.Errorf("Not implemented"))
+}
+
+func (pc pagerChain) StoragePage(target, cursor string, limit int) modules.JsObject {
+	return modules.JsReturnValErr(fmt.Errorf("Not implemented"))
+}
+
+// A fake chain that counts how often the whole world state is fetched.
+type countingChain struct {
+	*fakeChain
+	fetches *int
+}
+
+func (cc countingChain) WorldState() modules.JsObject {
+	*cc.fetches++
+	return cc.fakeChain.WorldState()
+}
+
+func manyAccounts(n int) *fakeChain {
+	fc := newFakeChain()
+	for i := 0; i < n; i++ {
+		fc.setAccount(fmt.Sprintf("bb%02d", i), fmt.Sprintf("%d", i), "", nil)
+	}
+	return fc
+}
+
+func TestWorldStatePages(t *testing.T) {
+	fc := manyAccounts(23)
+	all := fc.addresses()
+
+	seen := make([]string, 0)
+	cursor := ""
+	pages := 0
+	for {
+		page, err := WorldStatePage(fc, cursor, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages++
+		for _, addr := range page.Order {
+			if page.Accounts[addr] == nil || page.Accounts[addr].Address != addr {
+				t.Fatalf("Missing account %s", addr)
+			}
+		}
+		seen = append(seen, page.Order...)
+		if page.Next == "" {
+			break
+		}
+		cursor = page.Next
+	}
+	if pages != 3 || !reflect.DeepEqual(seen, all) {
+		t.Fatalf("Expected %v in 3 pages, got %v in %d", all, seen, pages)
+	}
+
+	// Accounts added before the cursor do not shift the next page.
+	first, _ := WorldStatePage(fc, "", 10)
+	fc.mutex.Lock()
+	fc.setAccount("aa00", "0", "", nil)
+	fc.mutex.Unlock()
+	second, err := WorldStatePage(fc, first.Next, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Order[0] != all[10] {
+		t.Fatalf("Expected the second page to start at %s, got %v", all[10], second.Order)
+	}
+
+	// Cursors are checked.
+	if _, err := WorldStatePage(fc, "garbage", 10); err == nil {
+		t.Fatal("Expected an error for an invalid cursor")
+	}
+	st, _ := StatePage(fc, "", 10)
+	if _, err := WorldStatePage(fc, st.Next, 10); err == nil {
+		t.Fatal("Expected an error for a cursor of another kind")
+	}
+	if page, _ := WorldStatePage(fc, "", MAX_PAGE_SIZE+1); len(page.Order) != 25 {
+		t.Fatalf("Expected everything on one page, got %d", len(page.Order))
+	}
+}
+
+func TestStateAndStoragePages(t *testing.T) {
+	fc := manyAccounts(5)
+	fc.setAccount("cc01", "0", "code", map[string]string{})
+	contract := fc.accounts["cc01"]
+	for i := 0; i < 7; i++ {
+		// Not in key order, so paging must follow Order.
+		key := fmt.Sprintf("0x%d", 7-i)
+		contract.Storage.Storage[key] = fmt.Sprintf("0x%d", i)
+		contract.Storage.Order = append(contract.Storage.Order, key)
+	}
+
+	page, err := StatePage(fc, "", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Order) != 4 || page.Next == "" || page.State[page.Order[0]] == nil {
+		t.Fatalf("Bad state page: %+v", page)
+	}
+
+	keys := make([]string, 0)
+	it := NewStorageIterator(fc, "cc01", 3)
+	for it.Next() {
+		k, v := it.Slot()
+		if v != contract.Storage.Storage[k] {
+			t.Fatalf("Bad value for %s: %s", k, v)
+		}
+		keys = append(keys, k)
+	}
+	if it.Err() != nil || !reflect.DeepEqual(keys, contract.Storage.Order) {
+		t.Fatalf("Expected %v, got %v (%v)", contract.Storage.Order, keys, it.Err())
+	}
+
+	addrs := make([]string, 0)
+	sit := NewStateIterator(fc, 2)
+	for sit.Next() {
+		addr, st := sit.Storage()
+		if st == nil {
+			t.Fatalf("No storage for %s", addr)
+		}
+		addrs = append(addrs, addr)
+	}
+	if sit.Err() != nil || !reflect.DeepEqual(addrs, fc.addresses()) {
+		t.Fatalf("Expected %v, got %v (%v)", fc.addresses(), addrs, sit.Err())
+	}
+
+	if it := NewStorageIterator(fc, "nobody", 3); it.Next() || it.Err() == nil {
+		t.Fatal("Expected an error for an unknown account")
+	}
+}
+
+func TestWorldStateIteratorWithPager(t *testing.T) {
+	pages := 0
+	pc := pagerChain{manyAccounts(10), &pages}
+	addrs := make([]string, 0)
+	it := NewWorldStateIterator(pc, 4)
+	for it.Next() {
+		addr, acc := it.Account()
+		if acc == nil || acc.Address != addr {
+			t.Fatalf("Bad account %s: %+v", addr, acc)
+		}
+		addrs = append(addrs, addr)
+	}
+	if it.Err() != nil || !reflect.DeepEqual(addrs, pc.addresses()) {
+		t.Fatalf("Expected %v, got %v (%v)", pc.addresses(), addrs, it.Err())
+	}
+	if pages != 3 {
+		t.Fatalf("Expected 3 pages from the chain, got %d", pages)
+	}
+}
+
+func TestWorldStateIteratorSnapshot(t *testing.T) {
+	fetches := 0
+	cc := countingChain{manyAccounts(10), &fetches}
+	n := 0
+	it := NewWorldStateIterator(cc, 3)
+	for it.Next() {
+		n++
+	}
+	if it.Err() != nil || n != len(cc.addresses()) {
+		t.Fatalf("Expected %d accounts, got %d (%v)", len(cc.addresses()), n, it.Err())
+	}
+	if fetches != 1 {
+		t.Fatalf("Expected the world state to be fetched once, got %d", fetches)
+	}
+}
+
+// A fake chain whose pages come from a function.
+type brokenPager struct {
+	*fakeChain
+	page func(cursor string) *modules.WorldStatePage
+}
+
+func (bp brokenPager) WorldStatePage(cursor string, limit int) modules.JsObject {
+	return modules.JsReturnVal(modules.ToMap(bp.page(cursor)), nil)
+}
+
+func (bp brokenPager) StatePage(cursor string, limit int) modules.JsObject {
+	return modules.JsReturnValErr(fmt.Errorf("Not implemented"))
+}
+
+func (bp brokenPager) StoragePage(target, cursor string, limit int) modules.JsObject {
+	return modules.JsReturnValErr(fmt.Errorf("Not implemented"))
+}
+
+func TestWorldStateIteratorBrokenPager(t *testing.T) {
+	acc := &modules.Account{Address: "aa01", Storage: &modules.Storage{}}
+	// A page that is not empty, but points back to itself.
+	stuck := brokenPager{newFakeChain(), func(cursor string) *modules.WorldStatePage {
+		return &modules.WorldStatePage{Accounts: map[string]*modules.Account{"aa01": acc}, Order: []string{"aa01"}, Next: "c"}
+	}}
+	// Empty pages with new cursors, forever.
+	calls := 0
+	empty := brokenPager{newFakeChain(), func(cursor string) *modules.WorldStatePage {
+		calls++
+		return &modules.WorldStatePage{Next: fmt.Sprintf("c%d", calls)}
+	}}
+	for name, bc := range map[string]modules.Blockchain{"stuck": stuck, "empty": empty} {
+		n := 0
+		it := NewWorldStateIterator(bc, 10)
+		for it.Next() && n < 1000 {
+			n++
+		}
+		if it.Err() == nil || n >= 1000 {
+			t.Errorf("%s: expected the iteration to fail, got %d accounts", name, n)
+		}
+	}
+	if calls != MAX_EMPTY_PAGES {
+		t.Errorf("Expected %d empty pages, got %d", MAX_EMPTY_PAGES, calls)
+	}
+}
+
+func TestPagesOverHttp(t *testing.T) {
+	fc := manyAccounts(3)
+	srv := httptest.NewServer(NewHttpAPI(fc))
+	defer srv.Close()
+
+	page := &modules.WorldStatePage{}
+	if err := call(t, srv.URL, "WorldStatePage", `{"Limit":3}`, page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Order) != 3 || page.Next == "" {
+		t.Fatalf("Bad first page: %+v", page)
+	}
+	next := page.Next
+	page = &modules.WorldStatePage{}
+	if err := call(t, srv.URL, "WorldStatePage", fmt.Sprintf(`["%s",3]`, next), page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Order) != 1 || page.Next != "" || page.Accounts[page.Order[0]] == nil {
+		t.Fatalf("Bad last page: %+v", page)
+	}
+	if err := call(t, srv.URL, "WorldStatePage", `["nope"]`, nil); err == nil || err.Code != INVALID_PARAMS {
+		t.Fatalf("Expected invalid params for a bad cursor, got %v", err)
+	}
+
+	storage := &modules.StoragePage{}
+	if err := call(t, srv.URL, "StoragePage", `["aa01"]`, storage); err != nil || len(storage.Order) != 0 || storage.Next != "" {
+		t.Fatalf("Bad storage page: %+v, %v", storage, err)
+	}
+}
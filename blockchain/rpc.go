@@ -0,0 +1,557 @@
+package blockchain
+
+// JSON-RPC 2.0 over a modules.Blockchain. The same methods are served over http
+// (httpAPI.go) and websockets (wsAPI.go).
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/eris-ltd/decerver-interfaces/modules"
+)
+
+const JSONRPC_VERSION = "2.0"
+
+// What MinGascost returns. TODO min gascost is hardcoded in block_chain NewBlock.
+// Will this vary at some point?
+const MIN_GASCOST = "10000000000000"
+
+// JSON-RPC 2.0 error codes.
+const (
+	PARSE_ERROR      = -32700
+	INVALID_REQUEST  = -32600
+	METHOD_NOT_FOUND = -32601
+	INVALID_PARAMS   = -32602
+	INTERNAL_ERROR   = -32603
+	// The blockchain returned an error (the 'Error' field of its JsObject).
+	CHAIN_ERROR = -32000
+)
+
+type (
+	Request struct {
+		Jsonrpc string           `json:"jsonrpc"`
+		Method  string           `json:"method"`
+		Params  *json.RawMessage `json:"params,omitempty"`
+		// A request without id is a notification, and gets no response. An id
+		// that is null is kept as the raw 'null', so it is not a notification.
+		Id *json.RawMessage `json:"id,omitempty"`
+	}
+
+	// A response has either a result (which may be null) or an error; see
+	// MarshalJSON.
+	Response struct {
+		Jsonrpc string           `json:"jsonrpc"`
+		Result  interface{}      `json:"result"`
+		Error   *Error           `json:"error"`
+		Id      *json.RawMessage `json:"id"`
+	}
+
+	resultResponse struct {
+		Jsonrpc string           `json:"jsonrpc"`
+		Result  interface{}      `json:"result"`
+		Id      *json.RawMessage `json:"id"`
+	}
+
+	errorResponse struct {
+		Jsonrpc string           `json:"jsonrpc"`
+		Error   *Error           `json:"error"`
+		Id      *json.RawMessage `json:"id"`
+	}
+
+	Error struct {
+		Code    int         `json:"code"`
+		Message string      `json:"message"`
+		Data    interface{} `json:"data,omitempty"`
+	}
+)
+
+// Used to decode a Request without calling its UnmarshalJSON.
+type request Request
+
+func (req *Request) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, (*request)(req)); err != nil {
+		return err
+	}
+	if req.Id == nil {
+		fields := make(map[string]json.RawMessage)
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return err
+		}
+		if id, ok := fields["id"]; ok {
+			req.Id = &id
+		}
+	}
+	return nil
+}
+
+// The result is always there when there is no error, even if it is null, and
+// left out when there is one.
+func (resp Response) MarshalJSON() ([]byte, error) {
+	if resp.Error != nil {
+		return json.Marshal(&errorResponse{resp.Jsonrpc, resp.Error, resp.Id})
+	}
+	return json.Marshal(&resultResponse{resp.Jsonrpc, resp.Result, resp.Id})
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (%d)", e.Message, e.Code)
+}
+
+func NewError(code int, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// A method gets the raw params of the request (nil if there are none). If it
+// returns an *Error, that is what the client gets; any other error is sent as a
+// CHAIN_ERROR.
+type Method func(params *json.RawMessage) (interface{}, error)
+
+// A set of named methods. Safe for concurrent use.
+type Service struct {
+	mutex   *sync.Mutex
+	methods map[string]Method
+}
+
+func NewService() *Service {
+	srv := &Service{}
+	srv.mutex = &sync.Mutex{}
+	srv.methods = make(map[string]Method)
+	return srv
+}
+
+// A service with all the blockchain methods.
+func NewBlockchainService(bc modules.Blockchain) *Service {
+	srv := NewService()
+	for name, m := range blockchainMethods(bc) {
+		srv.methods[name] = m
+	}
+	return srv
+}
+
+func (srv *Service) AddMethod(name string, method Method, replaceOld bool) error {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	if _, ok := srv.methods[name]; ok && !replaceOld {
+		return fmt.Errorf("Method '%s' already exists", name)
+	}
+	srv.methods[name] = method
+	return nil
+}
+
+func (srv *Service) RemoveMethod(name string) {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	delete(srv.methods, name)
+}
+
+func (srv *Service) Method(name string) Method {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	return srv.methods[name]
+}
+
+// Run a request. Returns nil for notifications. An invalid request always gets a
+// response, with a null id if it had none.
+func (srv *Service) Call(req *Request) *Response {
+	resp := &Response{Jsonrpc: JSONRPC_VERSION, Id: req.Id}
+	if req.Jsonrpc != JSONRPC_VERSION || req.Method == "" {
+		resp.Error = NewError(INVALID_REQUEST, "Invalid request")
+		return resp
+	} else if m := srv.Method(req.Method); m == nil {
+		resp.Error = NewError(METHOD_NOT_FOUND, "Method not found: %s", req.Method)
+	} else {
+		result, err := callMethod(m, req.Params)
+		if err != nil {
+			if rpcErr, ok := err.(*Error); ok {
+				resp.Error = rpcErr
+			} else {
+				resp.Error = NewError(CHAIN_ERROR, "%s", err)
+			}
+		} else {
+			resp.Result = result
+		}
+	}
+	if req.Id == nil {
+		return nil
+	}
+	return resp
+}
+
+// Handle a request or a batch of requests, as raw json. Returns nil if there is
+// nothing to send back (only notifications).
+func (srv *Service) Handle(data []byte) interface{} {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var raws []json.RawMessage
+		if err := json.Unmarshal(data, &raws); err != nil {
+			return &Response{Jsonrpc: JSONRPC_VERSION, Error: NewError(PARSE_ERROR, "Parse error: %s", err)}
+		}
+		if len(raws) == 0 {
+			return &Response{Jsonrpc: JSONRPC_VERSION, Error: NewError(INVALID_REQUEST, "Empty batch")}
+		}
+		resps := make([]*Response, 0, len(raws))
+		for _, raw := range raws {
+			if resp := srv.handleOne(raw); resp != nil {
+				resps = append(resps, resp)
+			}
+		}
+		if len(resps) == 0 {
+			return nil
+		}
+		return resps
+	}
+	if resp := srv.handleOne(data); resp != nil {
+		return resp
+	}
+	return nil
+}
+
+func (srv *Service) handleOne(data []byte) *Response {
+	if !json.Valid(data) {
+		return &Response{Jsonrpc: JSONRPC_VERSION, Error: NewError(PARSE_ERROR, "Parse error")}
+	}
+	req := &Request{}
+	if err := json.Unmarshal(data, req); err != nil {
+		return &Response{Jsonrpc: JSONRPC_VERSION, Error: NewError(INVALID_REQUEST, "Invalid request: %s", err)}
+	}
+	return srv.Call(req)
+}
+
+// A panicking module should not take the server down.
+func callMethod(m Method, params *json.RawMessage) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = nil
+			err = NewError(INTERNAL_ERROR, "Internal error: %v", r)
+		}
+	}()
+	return m(params)
+}
+
+// Decode params into the fields of the struct that args points to. Params are
+// either positional (a json array, in field order) or named (a json object).
+// Missing params keep their zero value.
+func parseParams(params *json.RawMessage, args interface{}) error {
+	if params == nil || len(*params) == 0 || string(*params) == "null" {
+		return nil
+	}
+	raw := bytes.TrimSpace(*params)
+	if len(raw) > 0 && raw[0] == '[' {
+		var list []json.RawMessage
+		if err := json.Unmarshal(raw, &list); err != nil {
+			return NewError(INVALID_PARAMS, "Invalid params: %s", err)
+		}
+		v := reflect.ValueOf(args).Elem()
+		if len(list) > v.NumField() {
+			return NewError(INVALID_PARAMS, "Too many params: expected at most %d", v.NumField())
+		}
+		for i, p := range list {
+			if err := json.Unmarshal(p, v.Field(i).Addr().Interface()); err != nil {
+				return NewError(INVALID_PARAMS, "Invalid param %d: %s", i, err)
+			}
+		}
+		return nil
+	}
+	if err := json.Unmarshal(raw, args); err != nil {
+		return NewError(INVALID_PARAMS, "Invalid params: %s", err)
+	}
+	return nil
+}
+
+// The data of a JsObject, or its error.
+func jsResult(obj modules.JsObject) (interface{}, error) {
+	if obj == nil {
+		return nil, fmt.Errorf("No result")
+	}
+	if e, ok := obj["Error"].(string); ok && e != "" {
+		return nil, fmt.Errorf("%s", e)
+	}
+	return obj["Data"], nil
+}
+
+func jsString(obj modules.JsObject) (string, error) {
+	data, err := jsResult(obj)
+	if err != nil {
+		return "", err
+	}
+	s, ok := data.(string)
+	if !ok {
+		return "", fmt.Errorf("Expected a string, got %T", data)
+	}
+	return s, nil
+}
+
+// Look up a field in map data (modules.ToMap output, or a JsObject).
+func field(data interface{}, name string) interface{} {
+	switch m := data.(type) {
+	case map[string]interface{}:
+		return m[name]
+	case modules.JsObject:
+		return m[name]
+	}
+	return nil
+}
+
+type (
+	targetArgs  struct{ Target string }
+	storageArgs struct{ Target, Storage string }
+	hashArgs    struct{ Hash string }
+	indexArgs   struct{ N int }
+	toggleArgs  struct{ Toggle bool }
+	txArgs      struct{ Addr, Amount string }
+	msgArgs     struct {
+		Addr string
+		Data []string
+	}
+	scriptArgs struct{ File, Lang string }
+	diffArgs   struct{ From, To string }
+	pageArgs   struct {
+		Cursor string
+		Limit  int
+	}
+	storagePageArgs struct {
+		Target string
+		Cursor string
+		Limit  int
+	}
+)
+
+func blockchainMethods(bc modules.Blockchain) map[string]Method {
+	m := make(map[string]Method)
+
+	// Blockchain
+	m["WorldState"] = func(params *json.RawMessage) (interface{}, error) {
+		return jsResult(bc.WorldState())
+	}
+	m["State"] = func(params *json.RawMessage) (interface{}, error) {
+		return jsResult(bc.State())
+	}
+	m["Storage"] = func(params *json.RawMessage) (interface{}, error) {
+		args := &targetArgs{}
+		if err := parseParams(params, args); err != nil {
+			return nil, err
+		}
+		return jsResult(bc.Storage(args.Target))
+	}
+	m["Account"] = func(params *json.RawMessage) (interface{}, error) {
+		args := &targetArgs{}
+		if err := parseParams(params, args); err != nil {
+			return nil, err
+		}
+		return jsResult(bc.Account(args.Target))
+	}
+	m["StorageAt"] = func(params *json.RawMessage) (interface{}, error) {
+		args := &storageArgs{}
+		if err := parseParams(params, args); err != nil {
+			return nil, err
+		}
+		return jsResult(bc.StorageAt(args.Target, args.Storage))
+	}
+	m["BlockCount"] = func(params *json.RawMessage) (interface{}, error) {
+		return jsResult(bc.BlockCount())
+	}
+	m["LatestBlock"] = func(params *json.RawMessage) (interface{}, error) {
+		return jsResult(bc.LatestBlock())
+	}
+	m["Block"] = func(params *json.RawMessage) (interface{}, error) {
+		args := &hashArgs{}
+		if err := parseParams(params, args); err != nil {
+			return nil, err
+		}
+		return jsResult(bc.Block(args.Hash))
+	}
+	m["IsScript"] = func(params *json.RawMessage) (interface{}, error) {
+		args := &targetArgs{}
+		if err := parseParams(params, args); err != nil {
+			return nil, err
+		}
+		return jsResult(bc.IsScript(args.Target))
+	}
+	m["Tx"] = func(params *json.RawMessage) (interface{}, error) {
+		args := &txArgs{}
+		if err := parseParams(params, args); err != nil {
+			return nil, err
+		}
+		return jsResult(bc.Tx(args.Addr, args.Amount))
+	}
+	m["Msg"] = func(params *json.RawMessage) (interface{}, error) {
+		args := &msgArgs{}
+		if err := parseParams(params, args); err != nil {
+			return nil, err
+		}
+		return jsResult(bc.Msg(args.Addr, args.Data))
+	}
+	m["Script"] = func(params *json.RawMessage) (interface{}, error) {
+		args := &scriptArgs{}
+		if err := parseParams(params, args); err != nil {
+			return nil, err
+		}
+		return jsResult(bc.Script(args.File, args.Lang))
+	}
+	m["Commit"] = func(params *json.RawMessage) (interface{}, error) {
+		return jsResult(bc.Commit())
+	}
+	m["AutoCommit"] = func(params *json.RawMessage) (interface{}, error) {
+		args := &toggleArgs{}
+		if err := parseParams(params, args); err != nil {
+			return nil, err
+		}
+		return jsResult(bc.AutoCommit(args.Toggle))
+	}
+	m["IsAutocommit"] = func(params *json.RawMessage) (interface{}, error) {
+		return jsResult(bc.IsAutocommit())
+	}
+
+	// KeyManager
+	m["ActiveAddress"] = func(params *json.RawMessage) (interface{}, error) {
+		return jsResult(bc.ActiveAddress())
+	}
+	m["Address"] = func(params *json.RawMessage) (interface{}, error) {
+		args := &indexArgs{}
+		if err := parseParams(params, args); err != nil {
+			return nil, err
+		}
+		return jsResult(bc.Address(args.N))
+	}
+	m["SetAddress"] = func(params *json.RawMessage) (interface{}, error) {
+		args := &targetArgs{}
+		if err := parseParams(params, args); err != nil {
+			return nil, err
+		}
+		return jsResult(bc.SetAddress(args.Target))
+	}
+	m["SetAddressN"] = func(params *json.RawMessage) (interface{}, error) {
+		args := &indexArgs{}
+		if err := parseParams(params, args); err != nil {
+			return nil, err
+		}
+		return jsResult(bc.SetAddressN(args.N))
+	}
+	m["NewAddress"] = func(params *json.RawMessage) (interface{}, error) {
+		args := &toggleArgs{}
+		if err := parseParams(params, args); err != nil {
+			return nil, err
+		}
+		return jsResult(bc.NewAddress(args.Toggle))
+	}
+	m["Addresses"] = func(params *json.RawMessage) (interface{}, error) {
+		return jsResult(bc.Addresses())
+	}
+	m["AddressCount"] = func(params *json.RawMessage) (interface{}, error) {
+		return jsResult(bc.AddressCount())
+	}
+
+	m["StateDiff"] = func(params *json.RawMessage) (interface{}, error) {
+		args := &diffArgs{}
+		if err := parseParams(params, args); err != nil {
+			return nil, err
+		}
+		return StateDiff(bc, args.From, args.To)
+	}
+
+	m["WorldStatePage"] = func(params *json.RawMessage) (interface{}, error) {
+		args := &pageArgs{}
+		if err := parseParams(params, args); err != nil {
+			return nil, err
+		}
+		return WorldStatePage(bc, args.Cursor, args.Limit)
+	}
+	m["StatePage"] = func(params *json.RawMessage) (interface{}, error) {
+		args := &pageArgs{}
+		if err := parseParams(params, args); err != nil {
+			return nil, err
+		}
+		return StatePage(bc, args.Cursor, args.Limit)
+	}
+	m["StoragePage"] = func(params *json.RawMessage) (interface{}, error) {
+		args := &storagePageArgs{}
+		if err := parseParams(params, args); err != nil {
+			return nil, err
+		}
+		return StoragePage(bc, args.Target, args.Cursor, args.Limit)
+	}
+
+	// The methods of the old monk api, on top of the ones above.
+	m["MyAddress"] = m["ActiveAddress"]
+	m["BlockLatest"] = func(params *json.RawMessage) (interface{}, error) {
+		hash, err := jsString(bc.LatestBlock())
+		if err != nil {
+			return nil, err
+		}
+		return jsResult(bc.Block(hash))
+	}
+	m["BlockByHash"] = m["Block"]
+	m["IsContract"] = m["IsScript"]
+	m["BalanceAt"] = func(params *json.RawMessage) (interface{}, error) {
+		args := &targetArgs{}
+		if err := parseParams(params, args); err != nil {
+			return nil, err
+		}
+		return balance(bc, args.Target)
+	}
+	m["MyBalance"] = func(params *json.RawMessage) (interface{}, error) {
+		addr, err := jsString(bc.ActiveAddress())
+		if err != nil {
+			return nil, err
+		}
+		return balance(bc, addr)
+	}
+	m["StartMining"] = func(params *json.RawMessage) (interface{}, error) {
+		return jsResult(bc.AutoCommit(true))
+	}
+	m["StopMining"] = func(params *json.RawMessage) (interface{}, error) {
+		return jsResult(bc.AutoCommit(false))
+	}
+	m["IsMining"] = m["IsAutocommit"]
+	m["MinGascost"] = func(params *json.RawMessage) (interface{}, error) {
+		return MIN_GASCOST, nil
+	}
+	m["Transact"] = func(params *json.RawMessage) (interface{}, error) {
+		args := &modules.TxIndata{}
+		if err := parseParams(params, args); err != nil {
+			return nil, err
+		}
+		return transact(bc, args)
+	}
+	return m
+}
+
+func balance(bc modules.Blockchain, addr string) (interface{}, error) {
+	acc, err := jsResult(bc.Account(addr))
+	if err != nil {
+		return nil, err
+	}
+	bal, _ := field(acc, "Balance").(string)
+	return bal, nil
+}
+
+// A contract is created if there is no recipient, a plain transaction is sent if
+// there is no data, and a message otherwise. Data is one item per line.
+func transact(bc modules.Blockchain, args *modules.TxIndata) (*modules.TxReceipt, error) {
+	var obj modules.JsObject
+	receipt := &modules.TxReceipt{}
+	if args.Recipient == "" {
+		obj = bc.Script(args.Data, "lll-literal")
+	} else if args.Data == "" {
+		obj = bc.Tx(args.Recipient, args.Value)
+	} else {
+		txData := strings.Split(args.Data, "\n")
+		for i, d := range txData {
+			txData[i] = strings.TrimSpace(d)
+		}
+		obj = bc.Msg(args.Recipient, txData)
+	}
+	data, err := jsResult(obj)
+	if err != nil {
+		receipt.Error = err.Error()
+		return receipt, nil
+	}
+	receipt.Success = true
+	receipt.Compiled = args.Recipient == ""
+	receipt.Hash, _ = field(data, "Hash").(string)
+	receipt.Address, _ = field(data, "Address").(string)
+	return receipt, nil
+}
@@ -1,218 +1,171 @@
 package blockchain
 
-/*
 import (
+	"encoding/json"
 	"fmt"
-	"github.com/eris-ltd/decerver-interfaces/modules"
-)
 
-const (
-	ERR_NO_SUCH_BLOCK        = "NO SUCH BLOCK"
-	ERR_NO_SUCH_TX           = "NO SUCH TX"
-	ERR_NO_SUCH_ADDRESS      = "NO SUCH ADDRESS"
-	ERR_STATE_NO_STORAGE     = "STATE NO STORAGE"
-	ERR_MALFORMED_ADDRESS    = "MALFORMED ADDRESS"
-	ERR_MALFORMED_BLOCK_HASH = "MALFORMED BLOCK HASH"
-	ERR_MALFORMED_TX_HASH    = "MALFORMED TX HASH"
+	"github.com/eris-ltd/decerver-interfaces/modules"
 )
 
+// Values of modules.AccountMini.Flag.
 const (
 	ACCOUNT_MODIFIED = iota
 	ACCOUNT_CREATED
 	ACCOUNT_DELETED
+)
 
-    ZeroHash160 = "00000000000000000000"
-    ZerHash256 = "00000000000000000000000000000000"
+const (
+	ZeroHash160 = "0000000000000000000000000000000000000000"
+	ZeroHash256 = "0000000000000000000000000000000000000000000000000000000000000000"
 )
 
-func getBlockChain(chain modules.Blockchain) []*modules.BlockMini {
-	lastNum := chain.BlockCount()
-	ctr := int(lastNum)
-	fmt.Printf("Last Block Number: %d\n", lastNum)
-	blocks := make([]*modules.BlockMini, ctr+1)
-	bHash := chain.LatestBlock()
-	block := chain.Block(bHash)
-	fmt.Printf("Current Block Number: %s\n", block.Number)
-	bmd := &modules.BlockMini{}
-	getBlockMiniWSFromBlock(bmd, block)
-	blocks[ctr] = bmd
-	fmt.Printf("Current Block Mini: %v\n", bmd)
-	ctr--
-	for ctr >= 0 {
-		pHash := block.PrevHash
-		block = chain.Block(pHash)
-		fmt.Printf("Current Block Number: %s\n", block.Number)
+// Decode the data of a JsObject into v. Modules return modules.ToMap output,
+// which has the same field names as the structs.
+func decodeData(obj modules.JsObject, v interface{}) error {
+	data, err := jsResult(obj)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		return fmt.Errorf("No data")
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func getBlock(chain modules.Blockchain, hash string) (*modules.Block, error) {
+	block := &modules.Block{}
+	if err := decodeData(chain.Block(hash), block); err != nil {
+		return nil, fmt.Errorf("No block with hash %s: %s", hash, err)
+	}
+	return block, nil
+}
+
+func getAccount(chain modules.Blockchain, addr string) (*modules.Account, error) {
+	acc := &modules.Account{}
+	if err := decodeData(chain.Account(addr), acc); err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+// All blocks, from the latest back to the first one (the one without a parent),
+// as minis without accounts.
+func getBlockChain(chain modules.Blockchain) ([]*modules.BlockMini, error) {
+	hash, err := jsString(chain.LatestBlock())
+	if err != nil {
+		return nil, err
+	}
+	blocks := make([]*modules.BlockMini, 0)
+	seen := make(map[string]bool)
+	for hash != "" && hash != ZeroHash160 && hash != ZeroHash256 && !seen[hash] {
+		seen[hash] = true
+		block, err := getBlock(chain, hash)
+		if err != nil {
+			return nil, err
+		}
 		bmd := &modules.BlockMini{}
 		getBlockMiniWSFromBlock(bmd, block)
-		blocks[ctr] = bmd
-		fmt.Printf("Current Block Mini: %v\n", bmd)
-		ctr--
+		blocks = append(blocks, bmd)
+		hash = block.PrevHash
 	}
-
-	return blocks
+	return blocks, nil
 }
 
 // Used during world state generation, when we don't care about the transactions.
-func getBlockMiniWSFromBlock(reply *modules.BlockMini, block *modules.Block){
-
+func getBlockMiniWSFromBlock(reply *modules.BlockMini, block *modules.Block) {
 	reply.Number = block.Number
 	reply.Hash = block.Hash
-
-	if block.Transactions != nil && len(block.Transactions) > 0 {
-		size := len(block.Transactions)
-		reply.Transactions = size
-	} else {
-		reply.Transactions = 0
+	reply.Transactions = len(block.Transactions)
+	if block.PrevHash != ZeroHash160 && block.PrevHash != ZeroHash256 {
+		reply.PrevHash = block.PrevHash
 	}
 }
 
-// Used in block updates from reactor, when we want account diffs along with the block data.
+// Used in block updates, when we want the affected accounts along with the block
+// data. If the blockchain keeps state history, the accounts are the ones that
+// changed since the parent block. If not, senders, recipients, created contracts
+// and the coinbase are taken to be affected.
 func getBlockMiniFromBlock(chain modules.Blockchain, reply *modules.BlockMini, block *modules.Block) {
-
-	reply.Number = block.Number
-	reply.Hash = block.Hash
+	getBlockMiniWSFromBlock(reply, block)
+	if _, ok := chain.(modules.StateHistory); ok {
+		if err := getAccountsAffectedFromDiff(chain, reply, block); err == nil {
+			return
+		}
+	}
 
 	aa := make(map[string]int)
-	size := len(block.Transactions)
-	reply.Transactions = size
-
-	// Just check who sender and receiver is. Receiver may be a contract
-	// creation address or a transaction receiver; either way it's a valid
-	// account.
-	for _, tx := range block.Transactions {
-
-		// Sender cannot be anything other then modified, which
-		// does not change the flag. It can however be unset.
-		if _, ok := aa[tx.Sender]; !ok {
-			aa[tx.Sender] = ACCOUNT_MODIFIED
+	order := make([]string, 0)
+	touch := func(addr string, flag int) {
+		if addr == "" {
+			return
 		}
-
-		// This flag is used for the receiver (or creation address).
-		rFlag := ACCOUNT_MODIFIED
-		var receiver string
-		if tx.ContractCreation {
-			rFlag |= ACCOUNT_CREATED
-			receiver = tx.Recipient
-		} else {
-			receiver = tx.Recipient
+		if _, ok := aa[addr]; !ok {
+			order = append(order, addr)
 		}
-
-		// Receiver
-		if _, ok := aa[receiver]; !ok {
-			aa[receiver] = rFlag
+		aa[addr] |= flag
+	}
+	for _, tx := range block.Transactions {
+		touch(tx.Sender, ACCOUNT_MODIFIED)
+		if tx.ContractCreation {
+			touch(tx.Recipient, ACCOUNT_CREATED)
 		} else {
-			aa[receiver] |= rFlag
+			touch(tx.Recipient, ACCOUNT_MODIFIED)
 		}
-
 	}
+	touch(block.Coinbase, ACCOUNT_MODIFIED)
 
-	// Coinbase
-	cbAddr := block.Coinbase
-
-	if _, ok := aa[cbAddr]; !ok {
-		aa[cbAddr] = ACCOUNT_MODIFIED
-	}
-
-	reply.AccountsAffected = make([]*modules.AccountMini, len(aa))
-	// For the final step, we check if all the affected contracts still exist. If any of
-	// the contracts has been removed, we update the flag to DELETED.
-	ctr := 0
-
-	for addr, flag := range aa {
-		// TODO really convert back and forth between bytes...
-		//addrBytes, _ := hex.DecodeString(addr)
-		//stObj := ethChain.Ethereum.BlockChain().CurrentBlock.State().GetStateObject(addrBytes)
-        acc := chain.Account(addr)
-		am := &modules.AccountMini{}
-		if acc == nil {
-			am.Address = addr
+	// Accounts that are gone were deleted.
+	reply.AccountsAffected = make([]*modules.AccountMini, 0, len(order))
+	for _, addr := range order {
+		am := &modules.AccountMini{Address: addr}
+		acc, err := getAccount(chain, addr)
+		if err != nil {
 			am.Flag = ACCOUNT_DELETED
 		} else {
-			am.Address = addr
-			am.Nonce = acc.Nonce
-			am.Balance = acc.Balance
-			am.Flag = flag
+			getAccountMiniFromAccount(am, acc)
+			am.Flag = aa[addr]
 		}
-		reply.AccountsAffected[ctr] = am
-		ctr++
-	}
-
-	// Block PrevHash
-	if block.PrevHash != "" && block.PrevHash != ZeroHash160 {
-		reply.PrevHash = block.PrevHash
+		reply.AccountsAffected = append(reply.AccountsAffected, am)
 	}
 }
 
-
-func createTx(chain Blockchain, recipient, valueStr, gasStr, gasPriceStr, scriptStr string, reply *TxReceipt) error {
-	var contractCreation bool
-	if len(recipient) == 0 {
-		contractCreation = true
-	}
-	hash, _ := hex.DecodeString(recipient)
-	fmt.Printf("Recipient: %x\n", hash)
-	value := ethutil.Big(valueStr)
-	gas := ethutil.Big(gasStr)
-	gasPrice := ethutil.Big(gasPriceStr)
-	var tx *ethchain.Transaction
-	// Compile and assemble the given data
-	if contractCreation {
-		// TODO disabled for now. Mutan is going away. Only LLL and Solidity.
-		var script []byte
+func getAccountsAffectedFromDiff(chain modules.Blockchain, reply *modules.BlockMini, block *modules.Block) error {
+	from := &modules.WorldState{}
+	if reply.PrevHash != "" {
 		var err error
-		if ethutil.IsHex(scriptStr) {
-			script, err = hex.DecodeString(scriptStr)
-			reply.Compiled = false
-		} else {
-			script, err = ethutil.Compile(scriptStr, false)
-			reply.Compiled = true
-		}
-		if err != nil {
-
+		if from, err = worldStateAt(chain, reply.PrevHash); err != nil {
 			return err
 		}
-
-		tx = ethchain.NewContractCreationTx(value, gas, gasPrice, script)
-	} else {
-		data := ethutil.StringToByteFunc(scriptStr, func(s string) (ret []byte) {
-			slice := strings.Split(s, "\n")
-			for _, dataItem := range slice {
-				d := ethutil.FormatData(dataItem)
-				ret = append(ret, d...)
-			}
-			return
-		})
-
-		tx = ethchain.NewTransactionMessage(hash, value, gas, gasPrice, data)
 	}
-
-	keyPair := ethChain.Ethereum.KeyManager().KeyPair()
-	acc := ethChain.Ethereum.StateManager().TransState().GetOrNewStateObject(keyPair.Address())
-	tx.Nonce = acc.Nonce
-	acc.Nonce += 1
-	ethChain.Ethereum.StateManager().TransState().UpdateStateObject(acc)
-
-	tx.Sign(keyPair.PrivateKey)
-	ethChain.Ethereum.TxPool().QueueTransaction(tx)
-
-	// Now write
-	if contractCreation {
-		reply.Address = hex.EncodeToString(tx.CreationAddress())
-		fmt.Printf("Contract addr %x", tx.CreationAddress())
+	to, err := worldStateAt(chain, block.Hash)
+	if err != nil {
+		return err
 	}
-
-	reply.Hash = hex.EncodeToString(tx.Hash())
-	reply.Success = true
-
+	diff := DiffWorldStates(from, to)
+	reply.AccountsAffected = make([]*modules.AccountMini, 0)
+	add := func(ads []*modules.AccountDiff, flag int) {
+		for _, ad := range ads {
+			am := &modules.AccountMini{Flag: flag, Address: ad.Address, Contract: ad.Contract}
+			if flag != ACCOUNT_DELETED {
+				am.Balance = ad.Balance
+				am.Nonce = ad.Nonce
+			}
+			reply.AccountsAffected = append(reply.AccountsAffected, am)
+		}
+	}
+	add(diff.Created, ACCOUNT_CREATED)
+	add(diff.Modified, ACCOUNT_MODIFIED)
+	add(diff.Deleted, ACCOUNT_DELETED)
 	return nil
 }
 
-
 func getAccountMiniFromAccount(am *modules.AccountMini, acc *modules.Account) {
 	am.Address = acc.Address
-	am.Contract = len(acc.Script) > 0
+	am.Contract = len(acc.Script) > 0 || acc.IsScript
 	am.Balance = acc.Balance
 	am.Nonce = acc.Nonce
-	return
 }
-*/
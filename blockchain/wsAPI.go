@@ -1,21 +1,67 @@
 package blockchain
 
-/*
-
 // This handles socket-based rpc. Part of it is reacting to requests sent from the
-// client, and part of it is reacting to changes in the ethereum world state,
-// and propagating these.
+// client, and part of it is reacting to changes in the blockchain, and pushing
+// these to the client.
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
-	"github.com/eris-ltd/decerver-interfaces/api"
+	"sort"
+	"sync"
+
 	"github.com/eris-ltd/decerver-interfaces/events"
 	"github.com/eris-ltd/decerver-interfaces/modules"
+	"github.com/eris-ltd/decerver-interfaces/network"
 	"github.com/eris-ltd/decerver-interfaces/util"
-	"strconv"
-	"strings"
-	"time"
+)
+
+// Methods of the notifications that are pushed to the client.
+const (
+	NOTIFY_BLOCK_ADDED = "BlockAdded"
+	NOTIFY_TX_ADDED    = "TxAdded"
+	NOTIFY_TX_FAILED   = "TxFailed"
+	// An event of a client subscription (a *SubscriptionEvent).
+	NOTIFY_EVENT = "Event"
+	// Sent while the world state is streamed (see WebSocketAPI.WorldState).
+	NOTIFY_NUM_BLOCKS   = "NumBlocks"
+	NOTIFY_BLOCK        = "Blocks"
+	NOTIFY_NUM_ACCOUNTS = "NumAccounts"
+	NOTIFY_ACCOUNT      = "Accounts"
+)
+
+// Upper limit for the number of client subscriptions of a session.
+const MAX_SUBSCRIPTIONS = 64
+
+// Blockchains that events can be subscribed to, like a modules.Module. If the
+// blockchain is not one, nothing is pushed.
+type EventSource interface {
+	Subscribe(name, event, target string) chan events.Event
+	UnSubscribe(name string)
+}
+
+// A JSON-RPC 2.0 notification, pushed from the server.
+type Notification struct {
+	Jsonrpc string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+func NewNotification(method string, params interface{}) *Notification {
+	return &Notification{Jsonrpc: JSONRPC_VERSION, Method: method, Params: params}
+}
+
+// Params of a NOTIFY_EVENT notification.
+type SubscriptionEvent struct {
+	Subscription string
+	Event        events.Event
+}
+
+type (
+	subscribeArgs struct {
+		Event  string
+		Target string
+	}
+	unsubscribeArgs struct{ Id string }
 )
 
 type WebSocketAPIFactory struct {
@@ -31,487 +77,367 @@ func NewWebSocketAPIFactory(bc modules.Blockchain) *WebSocketAPIFactory {
 	return fact
 }
 
-func (fact *WebSocketAPIFactory) Init() {
-
-}
-
-func (fact *WebSocketAPIFactory) Shutdown() {
-
-}
-
 func (fact *WebSocketAPIFactory) ServiceName() string {
 	return fact.serviceName
 }
 
-func (fact *WebSocketAPIFactory) CreateService() api.WsAPIService {
-	service := newWebSocketAPI(fact.bc)
+// A new api for the session. Call Init to start pushing events.
+func (fact *WebSocketAPIFactory) CreateService(session network.WsSession) *WebSocketAPI {
+	service := newWebSocketAPI(fact.bc, session)
 	service.name = fact.serviceName
 	return service
 }
 
 type WebSocketAPI struct {
 	name       string
-	mappings   map[string]api.WsAPIMethod
 	bc         modules.Blockchain
-	session    api.WsSession
+	service    *Service
+	session    network.WsSession
 	bcListener *BcListener
+	// Blocks that arrive while the world state is streamed are queued, and
+	// pushed when it is done.
+	mutex      *sync.Mutex
 	blockQueue *util.BlockMiniQueue
-	wsUpdated  bool
+	syncing    bool
+	// Sessions are written to from the listener and from rpc calls.
+	writeMutex *sync.Mutex
 }
 
-// Create a new handler
-func newWebSocketAPI(bc modules.Blockchain) *WebSocketAPI {
-
+func newWebSocketAPI(bc modules.Blockchain, session network.WsSession) *WebSocketAPI {
 	bcAPI := &WebSocketAPI{}
 	bcAPI.bc = bc
+	bcAPI.session = session
+	bcAPI.service = NewBlockchainService(bc)
+	bcAPI.mutex = &sync.Mutex{}
+	bcAPI.writeMutex = &sync.Mutex{}
 	bcAPI.blockQueue = util.NewBlockMiniQueue()
-	bcAPI.wsUpdated = false
-
-	bcAPI.mappings = make(map[string]api.WsAPIMethod)
-	bcAPI.mappings["MyBalance"] = bcAPI.MyBalance
-	bcAPI.mappings["ActiveAddress"] = bcAPI.ActiveAddress
-	bcAPI.mappings["MyAddresses"] = bcAPI.MyAddresses
-	bcAPI.mappings["NewAddress"] = bcAPI.NewAddress
-	bcAPI.mappings["SetAddress"] = bcAPI.SetAddress
-	bcAPI.mappings["StartMining"] = bcAPI.StartMining
-	bcAPI.mappings["StopMining"] = bcAPI.StopMining
-	bcAPI.mappings["LastBlockNumber"] = bcAPI.LastBlockNumber
-	bcAPI.mappings["BlockMiniByHash"] = bcAPI.BlockMiniByHash
-	bcAPI.mappings["BlockByHash"] = bcAPI.BlockByHash
-	bcAPI.mappings["Account"] = bcAPI.Account
-	bcAPI.mappings["Transact"] = bcAPI.Transact
-	bcAPI.mappings["WorldState"] = bcAPI.WorldState
-
+	bcAPI.service.AddMethod("WorldState", bcAPI.WorldState, true)
+	bcAPI.service.AddMethod("subscribe", bcAPI.Subscribe, true)
+	bcAPI.service.AddMethod("unsubscribe", bcAPI.Unsubscribe, true)
 	return bcAPI
 }
 
-func (bcAPI *WebSocketAPI) SetSession(wsSession api.WsSession) {
-	bcAPI.session = wsSession
-}
-
 func (bcAPI *WebSocketAPI) Init() {
+	bcAPI.mutex.Lock()
+	defer bcAPI.mutex.Unlock()
 	bcAPI.bcListener = newBcListener(bcAPI)
 }
 
+// Stops pushing events, and removes the client subscriptions. Call it when the
+// session is closed.
 func (bcAPI *WebSocketAPI) Shutdown() {
-	bcAPI.bcListener.Close()
+	if bl := bcAPI.listener(); bl != nil {
+		bl.Close()
+	}
 }
 
-func (bcAPI *WebSocketAPI) Name() string {
-	return bcAPI.name
+func (bcAPI *WebSocketAPI) listener() *BcListener {
+	bcAPI.mutex.Lock()
+	defer bcAPI.mutex.Unlock()
+	return bcAPI.bcListener
 }
 
-func (bcAPI *WebSocketAPI) HandleRPC(rpcReq *api.Request) (*api.Response, error) {
-	methodName := rpcReq.Method
-	resp := &api.Response{}
-	if bcAPI.mappings[methodName] == nil {
-		fmt.Errorf("Method not supported: %s\n", methodName)
-		return nil, errors.New("SRPC Method not supported.")
+// Subscribe to an event (params: Event, Target). The target is an address, or
+// whatever else the blockchain takes as a target (e.g. a storage slot). Returns
+// the subscription id. Matching events are pushed as NOTIFY_EVENT notifications.
+func (bcAPI *WebSocketAPI) Subscribe(params *json.RawMessage) (interface{}, error) {
+	args := &subscribeArgs{}
+	if err := parseParams(params, args); err != nil {
+		return nil, err
 	}
-
-	// Run the method.
-	bcAPI.mappings[methodName](rpcReq, resp)
-	// The ID is the method being called, for now.
-	resp.Id = methodName
-
-	return resp, nil
-}
-
-// Add a new method
-func (bcAPI *WebSocketAPI) AddMethod(methodName string, method api.WsAPIMethod, replaceOld bool) error {
-	if bcAPI.mappings[methodName] != nil {
-		if !replaceOld {
-			return errors.New("Tried to overwrite an already existing method.")
-		} else {
-			fmt.Printf("Overwriting old method for '" + methodName + "'.")
-		}
+	if args.Event == "" {
+		return nil, NewError(INVALID_PARAMS, "No event given")
 	}
-	bcAPI.mappings[methodName] = method
-	return nil
+	bl := bcAPI.listener()
+	if bl == nil {
+		return nil, fmt.Errorf("The api is not started")
+	}
+	return bl.subscribe(args.Event, args.Target)
 }
 
-// Remove a method
-func (bcAPI *WebSocketAPI) RemoveMethod(methodName string) {
-	if bcAPI.mappings[methodName] == nil {
-		fmt.Printf("Removal failed. There is no handler for '" + methodName + "'.")
-	} else {
-		delete(bcAPI.mappings, methodName)
+// Remove a subscription (params: Id). Returns true.
+func (bcAPI *WebSocketAPI) Unsubscribe(params *json.RawMessage) (interface{}, error) {
+	args := &unsubscribeArgs{}
+	if err := parseParams(params, args); err != nil {
+		return nil, err
 	}
-	return
-}
-
-func (bcAPI *WebSocketAPI) MyBalance(req *api.Request, resp *api.Response) {
-	// TODO add
-	retVal := &modules.VString{}
-	// TODO Replace with pipe
-	myAddr := bcAPI.bc.ActiveAddress()
-	retVal.SVal = bcAPI.bc.Account(myAddr).Balance
-	resp.Result = retVal
-}
-
-func (bcAPI *WebSocketAPI) ActiveAddress(req *api.Request, resp *api.Response) {
-	retVal := &modules.VString{}
-	// TODO Replace with pipe
-	retVal.SVal = bcAPI.bc.ActiveAddress()
-	resp.Result = retVal
-}
-
-func (bcAPI *WebSocketAPI) MyAddresses(req *api.Request, resp *api.Response) {
-	retVal := &modules.Addresses{}
-	retVal.ActiveAddress = bcAPI.bc.ActiveAddress()
-	numKeys := bcAPI.bc.AddressCount()
-	keyArr := make([]string, numKeys)
-	var err error
-	for idx := 0; idx < numKeys; idx++ {
-		keyArr[idx] , err = bcAPI.bc.Address(idx)
-		if err != nil {
-			resp.Error = err.Error()
-			break
-		}
+	bl := bcAPI.listener()
+	if bl == nil {
+		return nil, fmt.Errorf("The api is not started")
+	}
+	if err := bl.unsubscribe(args.Id); err != nil {
+		return nil, err
 	}
-	retVal.AddressList = keyArr
-	resp.Result = retVal
+	return true, nil
 }
 
-func (bcAPI *WebSocketAPI) NewAddress(req *api.Request, resp *api.Response) {
-	retVal := &modules.VString{}
-	// TODO Replace with pipe
-	retVal.SVal = bcAPI.bc.NewAddress(true)
-	resp.Result = retVal
+func (bcAPI *WebSocketAPI) Name() string {
+	return bcAPI.name
 }
 
-func (bcAPI *WebSocketAPI) SetAddress(req *api.Request, resp *api.Response) {
-
-	params := &modules.VString{}
-	err := json.Unmarshal(*req.Params, params)
-
-	if err != nil {
-		resp.Error = err.Error()
-		return
-	}
-
-	retVal := &modules.VString{}
-	// TODO Replace with pipe
-	err = bcAPI.bc.SetAddress(params.SVal)
-
-	if err != nil {
-		retVal.SVal = err.Error()
-	}
-	resp.Result = retVal
+// The methods that are served. Methods can be added and removed at any time.
+func (bcAPI *WebSocketAPI) Service() *Service {
+	return bcAPI.service
 }
 
-func (bcAPI *WebSocketAPI) StartMining(req *api.Request, resp *api.Response) {
-	retVal := &modules.VBool{}
-	bcAPI.bc.AutoCommit(true)
-	retVal.BVal = true
-	resp.Result = retVal
+// Handle a message from the client (a request or a batch). The response, if
+// any, is written to the session.
+func (bcAPI *WebSocketAPI) HandleRPC(msg []byte) {
+	if resp := bcAPI.service.Handle(msg); resp != nil {
+		bcAPI.write(resp)
+	}
 }
 
-func (bcAPI *WebSocketAPI) StopMining(req *api.Request, resp *api.Response) {
-	retVal := &modules.VBool{}
-	bcAPI.bc.AutoCommit(false)
-	retVal.BVal = true
-	resp.Result = retVal
+func (bcAPI *WebSocketAPI) write(msg interface{}) {
+	bcAPI.writeMutex.Lock()
+	defer bcAPI.writeMutex.Unlock()
+	bcAPI.session.WriteJsonMsg(msg)
 }
 
-func (bcAPI *WebSocketAPI) LastBlockNumber(req *api.Request, resp *api.Response) {
-	retVal := &modules.VInteger{}
-	retVal.IVal = bcAPI.bc.BlockCount() - 1
-	resp.Result = retVal
+func (bcAPI *WebSocketAPI) notify(method string, params interface{}) {
+	bcAPI.write(NewNotification(method, params))
 }
 
-func (bcAPI *WebSocketAPI) BlockMiniByHash(req *api.Request, resp *api.Response) {
-	params := &modules.VString{}
-	err := json.Unmarshal(*req.Params, params)
-
-	if err != nil {
-		resp.Error = err.Error()
-		return
-	}
-
-	retVal := &modules.BlockMini{}
-	fmt.Printf("Block %s\n", params.SVal)
-	return
-
-	block := bcAPI.bc.Block(params.SVal)
-	if block == nil {
-		resp.Error = "No block with hash: " + params.SVal
-		return
+func (bcAPI *WebSocketAPI) blockAdded(block *modules.Block) {
+	bd := &modules.BlockMini{}
+	getBlockMiniFromBlock(bcAPI.bc, bd, block)
+	bcAPI.mutex.Lock()
+	defer bcAPI.mutex.Unlock()
+	if bcAPI.syncing {
+		bcAPI.blockQueue.Push(bd)
+	} else {
+		bcAPI.notify(NOTIFY_BLOCK_ADDED, bd)
 	}
-
-	getBlockMiniFromBlock(bcAPI.bc, retVal, block)
-
-	resp.Result = retVal
-
 }
 
-func (bcAPI *WebSocketAPI) BlockByHash(req *api.Request, resp *api.Response) {
-	params := &modules.VString{}
-	err := json.Unmarshal(*req.Params, params)
-
-	if err != nil {
-		resp.Error = err.Error()
-		return
+// Streams the world state to the client: the number of blocks and then every
+// block (latest first), followed by every account, in order, and the number of
+// accounts. The accounts are read a page at a time (see NewWorldStateIterator),
+// so their number is only known at the end. Blocks that are added in the meantime
+// are pushed after that. The result has the number of blocks and accounts that
+// were sent.
+func (bcAPI *WebSocketAPI) WorldState(params *json.RawMessage) (interface{}, error) {
+	bcAPI.mutex.Lock()
+	if bcAPI.syncing {
+		bcAPI.mutex.Unlock()
+		return nil, fmt.Errorf("The world state is already being sent")
 	}
-	//params.SVal = "0x" + params.SVal
-	fmt.Println("Block being fetched: " + params.SVal)
-
-	block := bcAPI.bc.Block(params.SVal)
-	if block == nil {
-		resp.Error = "No block with hash: " + params.SVal
-		return
-	}
-
-	resp.Result = block
-}
-
-func (bcAPI *WebSocketAPI) Account(req *api.Request, resp *api.Response) {
-	params := &modules.VString{}
-	err := json.Unmarshal(*req.Params, params)
+	bcAPI.syncing = true
+	bcAPI.mutex.Unlock()
+	defer bcAPI.flushBlocks()
 
+	blocks, err := getBlockChain(bcAPI.bc)
 	if err != nil {
-		resp.Error = err.Error()
-		return
+		return nil, err
 	}
 
-	retVal := bcAPI.bc.Account(params.SVal)
-	resp.Result = retVal
-}
-
-func (bcAPI *WebSocketAPI) Transact(req *api.Request, resp *api.Response) {
-
-	params := &modules.TxIndata{}
-	err := json.Unmarshal(*req.Params, params)
-
-	if err != nil {
-		fmt.Printf("Tx indata error: %s\n", err.Error())
-		resp.Error = err.Error()
-		return
+	bcAPI.notify(NOTIFY_NUM_BLOCKS, len(blocks))
+	for _, b := range blocks {
+		bcAPI.notify(NOTIFY_BLOCK, b)
 	}
-
-	fmt.Printf("Tx indata: %v\n", params)
-
-	retVal := &modules.TxReceipt{}
-
-	// Contract create
-	if params.Recipient == "" {
-		fmt.Println("Processing contract create tx")
-		addr, err := bcAPI.bc.Script(params.Data, "lll-literal")
-		if err != nil {
-			retVal.Compiled = false
-			retVal.Error = err.Error()
-			retVal.Success = false
-		} else {
-			retVal.Address = addr
-			retVal.Compiled = true
-			retVal.Success = true
-		}
-		// Tx
-	} else if params.Data == "" {
-		fmt.Println("Processing tx")
-		hash, _ := bcAPI.bc.Tx(params.Recipient, params.Value)
-		retVal.Success = true
-		retVal.Hash = hash
-		// It's a message
-	} else if params.Value == "" {
-		fmt.Println("Processing message")
-		txData := strings.Split(params.Data, "\n")
-		for idx, val := range txData {
-			txData[idx] = strings.Trim(val, " ")
-		}
-
-		hash, _ := bcAPI.bc.Msg(params.Recipient, txData)
-		retVal.Success = true
-		retVal.Hash = hash
-	} else {
-		// TODO general purpose transaction.
-		fmt.Println("Processing message")
-		txData := strings.Split(params.Data, "\n")
-		for idx, val := range txData {
-			txData[idx] = strings.Trim(val, " ")
+	accounts := 0
+	it := NewWorldStateIterator(bcAPI.bc, 0)
+	for it.Next() {
+		_, acc := it.Account()
+		if acc == nil {
+			continue
 		}
-		hash, _ := bcAPI.bc.Msg(params.Recipient, txData)
-		retVal.Success = true
-		retVal.Hash = hash
+		am := &modules.AccountMini{}
+		getAccountMiniFromAccount(am, acc)
+		bcAPI.notify(NOTIFY_ACCOUNT, am)
+		accounts++
 	}
-	resp.Result = retVal
-}
-
-func (bcAPI *WebSocketAPI) WorldState(req *api.Request, resp *api.Response) {
-	// We do this all in one go.
-	blocks := getBlockChain(bcAPI.bc)
-	// Let the client know how many blocks there are.
-	resp = &api.Response{}
-	resp.Id = "NumBlocks"
-	resp.Result = &modules.VInteger{IVal: len(blocks) - 1}
-	bcAPI.session.WriteJsonMsg(resp)
-
-	// Send blocks one at a time.
-	for i := 0; i < len(blocks); i++ {
-		resp = &api.Response{}
-		resp.Id = "Blocks"
-		resp.Result = blocks[i]
-		bcAPI.session.WriteJsonMsg(resp)
-		time.Sleep(50)
+	if err := it.Err(); err != nil {
+		return nil, err
 	}
+	bcAPI.notify(NOTIFY_NUM_ACCOUNTS, accounts)
+	return map[string]int{"Blocks": len(blocks), "Accounts": accounts}, nil
+}
 
-	accounts := bcAPI.bc.WorldState()
-	// Let the client know how many accounts there are.
-	worldSize := len(accounts.Accounts)
-	resp = &api.Response{}
-	resp.Id = "NumAccounts"
-	resp.Result = &modules.VInteger{IVal: worldSize}
-	bcAPI.session.WriteJsonMsg(resp)
-
-	// Send one at a time.
-	for _, hash := range accounts.Order {
-		resp = &api.Response{}
-		resp.Id = "Accounts"
-		acc := accounts.Accounts[hash]
-		accMini := &modules.AccountMini{}
-		getAccountMiniFromAccount(accMini, acc)
-		resp.Result = accMini
-		bcAPI.session.WriteJsonMsg(resp)
-		time.Sleep(50)
-	}
-
-	time.Sleep(200)
-
-	// Now flush the generated block queue
+func (bcAPI *WebSocketAPI) flushBlocks() {
+	bcAPI.mutex.Lock()
+	defer bcAPI.mutex.Unlock()
 	for !bcAPI.blockQueue.IsEmpty() {
-		// Finalize.
-		resp = &api.Response{}
-		resp.Id = "BlockAdded"
-		resp.Result = bcAPI.blockQueue.Pop()
-		bcAPI.session.WriteJsonMsg(resp)
+		bcAPI.notify(NOTIFY_BLOCK_ADDED, bcAPI.blockQueue.Pop())
 	}
-
-	bcAPI.wsUpdated = true
-
-	// Finalize.
-	resp = &api.Response{}
-	resp.Id = "WorldStateDone"
-	resp.Result = &modules.NoArgs{}
-	bcAPI.session.WriteJsonMsg(resp)
-
+	bcAPI.syncing = false
 }
 
 // This object is used to subscribe directly to the blockchain rather then going through
-// the global eventprocessor.
+// the global eventprocessor. It has the fixed feeds (blocks and transactions), and
+// the subscriptions made by the client.
 type BcListener struct {
-	bcAPI             *WebSocketAPI
-	txPreChannel      chan events.Event
-	txPreFailChannel  chan events.Event
-	txPostChannel     chan events.Event
-	txPostFailChannel chan events.Event
-	blockChannel      chan events.Event
-	stopChannel       chan bool
+	bcAPI  *WebSocketAPI
+	source EventSource
+	mutex  *sync.Mutex
+	// Subscription name -> the channel that stops its goroutine.
+	stops map[string]chan struct{}
+	// Client subscription id -> subscription name.
+	clientSubs map[string]string
+	lastId     uint64
+	closed     bool
+	wg         *sync.WaitGroup
 }
 
 func newBcListener(bcAPI *WebSocketAPI) *BcListener {
 	bl := &BcListener{}
 	bl.bcAPI = bcAPI
+	bl.mutex = &sync.Mutex{}
+	bl.stops = make(map[string]chan struct{})
+	bl.clientSubs = make(map[string]string)
+	bl.wg = &sync.WaitGroup{}
+	source, ok := bcAPI.bc.(EventSource)
+	if !ok {
+		return bl
+	}
+	bl.source = source
+
+	bl.listen(bl.name(events.EVENT_NEW_BLOCK), events.EVENT_NEW_BLOCK, "", func(evt events.Event) {
+		if block, _ := evt.Resource.(*modules.Block); block != nil {
+			bcAPI.blockAdded(block)
+		}
+	})
+	bl.listen(bl.name(events.EVENT_NEW_TX), events.EVENT_NEW_TX, "", func(evt events.Event) {
+		if tx, _ := evt.Resource.(*modules.Transaction); tx != nil {
+			bcAPI.notify(NOTIFY_TX_ADDED, tx)
+		}
+	})
+	bl.listen(bl.name(events.EVENT_TX_FAILED), events.EVENT_TX_FAILED, "", func(evt events.Event) {
+		if tx, _ := evt.Resource.(*modules.Transaction); tx != nil {
+			bcAPI.notify(NOTIFY_TX_FAILED, tx)
+		}
+	})
+	return bl
+}
+
+// Subscription names are unique per api and session.
+func (bl *BcListener) name(suffix string) string {
+	return fmt.Sprintf("%s%d:%s", bl.bcAPI.name, bl.bcAPI.session.SessionId(), suffix)
+}
 
-	bl.blockChannel = make(chan events.Event, 10)
-	bl.txPreChannel = make(chan events.Event, 10)
-	bl.txPreFailChannel = make(chan events.Event, 10)
-	bl.txPostChannel = make(chan events.Event, 10)
-	bl.txPostFailChannel = make(chan events.Event, 10)
-	bl.stopChannel = make(chan bool)
-	idStr := strconv.Itoa(int(bl.bcAPI.session.SessionId()))
-	c := "newBlock"
-	bl.blockChannel = bl.bcAPI.bc.Subscribe(c+idStr, c, "")
-	c = "newTx:pre"
-	bl.txPreChannel = bl.bcAPI.bc.Subscribe(c+idStr, c, "")
-	c = "newTx:pre:fail"
-	bl.txPreFailChannel = bl.bcAPI.bc.Subscribe(c+idStr, c, "")
-	c = "newTx:post"
-	bl.txPostChannel = bl.bcAPI.bc.Subscribe(c+idStr, c, "")
-	c = "newTx:post:fail"
-	bl.txPostFailChannel = bl.bcAPI.bc.Subscribe(c+idStr, c, "")
-
-	go func(bl *BcListener) {
+// Subscribe to an event, and pass everything on the channel to handle until
+// the subscription is removed or the listener is closed. Returns false if the
+// source would not subscribe, or the listener was closed in the meantime.
+func (bl *BcListener) listen(name, event, target string, handle func(evt events.Event)) bool {
+	ch := bl.source.Subscribe(name, event, target)
+	if ch == nil {
+		return false
+	}
+	stop := make(chan struct{})
+	bl.mutex.Lock()
+	if bl.closed {
+		bl.mutex.Unlock()
+		bl.source.UnSubscribe(name)
+		return false
+	}
+	bl.stops[name] = stop
+	// Added under the mutex, so Close can not be waiting already.
+	bl.wg.Add(1)
+	bl.mutex.Unlock()
+	go func() {
+		defer bl.wg.Done()
 		for {
 			select {
-			case evt := <-bl.blockChannel:
-				block, _ := evt.Resource.(*modules.Block)
-				if block == nil {
-					continue;
-				}
-				fmt.Println("Block added")
-				resp := &api.Response{}
-				resp.Id = "BlockAdded"
-				bd := &modules.BlockMini{}
-				getBlockMiniFromBlock(bl.bcAPI.bc, bd, block)
-				if bl.bcAPI.wsUpdated == false {
-					bl.bcAPI.blockQueue.Push(bd)
-				} else {
-					resp.Result = bd
-					bl.bcAPI.session.WriteJsonMsg(resp)
-				}
-			case evt := <-bl.txPreChannel:
-				tx, _ := evt.Resource.(*modules.Transaction)
-				if tx == nil {
-					continue;
-				}
-				resp := &api.Response{}
-				resp.Id = "TxPre"
-				resp.Result = tx
-				bl.bcAPI.session.WriteJsonMsg(resp)
-			case evt := <-bl.txPreFailChannel:
-				tx, _ := evt.Resource.(*modules.Transaction)
-				if tx == nil {
-					continue;
-				}
-				resp := &api.Response{}
-				resp.Id = "TxPreFail"
-				resp.Result = tx
-				resp.Error = tx.Error
-				bl.bcAPI.session.WriteJsonMsg(resp)
-			case evt := <-bl.txPostChannel:
-				tx, _ := evt.Resource.(*modules.Transaction)
-				if tx == nil {
-					continue;
-				}
-				resp := &api.Response{}
-				resp.Id = "TxPost"
-				resp.Result = tx
-				bl.bcAPI.session.WriteJsonMsg(resp)
-			case evt := <-bl.txPostFailChannel:
-				tx, _ := evt.Resource.(*modules.Transaction)
-				if tx == nil {
-					continue;
+			case evt, ok := <-ch:
+				if !ok {
+					return
 				}
-				resp := &api.Response{}
-				resp.Id = "TxPostFail"
-				resp.Result = tx
-				resp.Error = tx.Error
-				bl.bcAPI.session.WriteJsonMsg(resp)
-			case <-bl.stopChannel:
-				// Quit this
+				handle(evt)
+			case <-stop:
 				return
 			}
 		}
-	}(bl)
-	return bl
+	}()
+	return true
+}
+
+func (bl *BcListener) remove(name string) {
+	bl.mutex.Lock()
+	stop, ok := bl.stops[name]
+	delete(bl.stops, name)
+	bl.mutex.Unlock()
+	if ok {
+		close(stop)
+		bl.source.UnSubscribe(name)
+	}
+}
+
+// Add a client subscription. Matching events are pushed in NOTIFY_EVENT
+// notifications with the returned id.
+func (bl *BcListener) subscribe(event, target string) (string, error) {
+	if bl.source == nil {
+		return "", fmt.Errorf("The blockchain has no events")
+	}
+	if events.Kind(event) == nil && !events.IsPattern(event) {
+		return "", fmt.Errorf("Unknown event: %s", event)
+	}
+	bl.mutex.Lock()
+	if bl.closed {
+		bl.mutex.Unlock()
+		return "", fmt.Errorf("The session is closed")
+	}
+	if len(bl.clientSubs) >= MAX_SUBSCRIPTIONS {
+		bl.mutex.Unlock()
+		return "", fmt.Errorf("Too many subscriptions (max %d)", MAX_SUBSCRIPTIONS)
+	}
+	bl.lastId++
+	id := fmt.Sprintf("%d", bl.lastId)
+	name := bl.name("sub" + id)
+	bl.clientSubs[id] = name
+	bl.mutex.Unlock()
+
+	ok := bl.listen(name, event, target, func(evt events.Event) {
+		bl.bcAPI.notify(NOTIFY_EVENT, &SubscriptionEvent{Subscription: id, Event: evt})
+	})
+	if !ok {
+		bl.mutex.Lock()
+		delete(bl.clientSubs, id)
+		closed := bl.closed
+		bl.mutex.Unlock()
+		if closed {
+			return "", fmt.Errorf("The session is closed")
+		}
+		return "", fmt.Errorf("Could not subscribe to %s", event)
+	}
+	return id, nil
 }
 
+func (bl *BcListener) unsubscribe(id string) error {
+	bl.mutex.Lock()
+	name, ok := bl.clientSubs[id]
+	delete(bl.clientSubs, id)
+	bl.mutex.Unlock()
+	if !ok {
+		return fmt.Errorf("No subscription with id %s", id)
+	}
+	bl.remove(name)
+	return nil
+}
+
+// The ids of the client subscriptions.
+func (bl *BcListener) Subscriptions() []string {
+	bl.mutex.Lock()
+	defer bl.mutex.Unlock()
+	ids := make([]string, 0, len(bl.clientSubs))
+	for id := range bl.clientSubs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Removes all subscriptions, the client ones included.
 func (bl *BcListener) Close() {
-	idStr := strconv.Itoa(int(bl.bcAPI.session.SessionId()))
-	c := "newBlock"
-	fmt.Printf("Unregister: " + c + idStr)
-	bl.bcAPI.bc.UnSubscribe(c + idStr)
-	c = "newTx:pre"
-	fmt.Printf("Unregister: " + c + idStr)
-	bl.bcAPI.bc.UnSubscribe(c + idStr)
-	c = "newTx:pre:fail"
-	fmt.Printf("Unregister: " + c + idStr)
-	bl.bcAPI.bc.UnSubscribe(c + idStr)
-	c = "newTx:post"
-	fmt.Printf("Unregister: " + c + idStr)
-	bl.bcAPI.bc.UnSubscribe(c + idStr)
-	c = "newTx:post:fail"
-	fmt.Printf("Unregister: " + c + idStr)
-	bl.bcAPI.bc.UnSubscribe(c + idStr)
-}
-*/
+	bl.mutex.Lock()
+	bl.closed = true
+	names := make([]string, 0, len(bl.stops))
+	for name := range bl.stops {
+		names = append(names, name)
+	}
+	bl.clientSubs = make(map[string]string)
+	bl.mutex.Unlock()
+	for _, name := range names {
+		bl.remove(name)
+	}
+	bl.wg.Wait()
+}
@@ -0,0 +1,319 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eris-ltd/decerver-interfaces/events"
+	"github.com/eris-ltd/decerver-interfaces/modules"
+)
+
+type fakeSub struct {
+	event  string
+	target string
+	ch     chan events.Event
+}
+
+func (fc *fakeChain) Subscribe(name, event, target string) chan events.Event {
+	fc.mutex.Lock()
+	sub := &fakeSub{event: event, target: target, ch: make(chan events.Event, 16)}
+	fc.subs[name] = sub
+	subscribed := fc.subscribed
+	fc.mutex.Unlock()
+	if subscribed != nil {
+		subscribed(name)
+	}
+	return sub.ch
+}
+
+func (fc *fakeChain) UnSubscribe(name string) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	if sub, ok := fc.subs[name]; ok {
+		close(sub.ch)
+		delete(fc.subs, name)
+	}
+}
+
+func (fc *fakeChain) subCount() int {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	return len(fc.subs)
+}
+
+// Events are dropped if a subscriber is behind.
+func (fc *fakeChain) post(event, target string, resource interface{}) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	for _, sub := range fc.subs {
+		if sub.event != event || (sub.target != "" && sub.target != target) {
+			continue
+		}
+		select {
+		case sub.ch <- events.Event{Event: event, Target: target, Resource: resource, Source: "fake", TimeStamp: time.Now()}:
+		default:
+		}
+	}
+}
+
+// Keeps every message as json.
+type fakeSession struct {
+	mutex *sync.Mutex
+	id    uint32
+	msgs  []json.RawMessage
+}
+
+func newFakeSession(id uint32) *fakeSession {
+	return &fakeSession{mutex: &sync.Mutex{}, id: id}
+}
+
+func (fs *fakeSession) SessionId() uint32 { return fs.id }
+func (fs *fakeSession) WriteCloseMsg()    {}
+func (fs *fakeSession) WriteJsonMsg(msg interface{}) {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	fs.msgs = append(fs.msgs, b)
+}
+
+type wsMsg struct {
+	Method string
+	Params json.RawMessage
+	Result json.RawMessage
+	Error  *Error
+	Id     *int
+}
+
+// Waits until there are n messages, and returns them.
+func (fs *fakeSession) wait(t *testing.T, n int) []*wsMsg {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		fs.mutex.Lock()
+		count := len(fs.msgs)
+		fs.mutex.Unlock()
+		if count >= n {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d messages, got %d", n, count)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	msgs := make([]*wsMsg, len(fs.msgs))
+	for i, b := range fs.msgs {
+		msgs[i] = &wsMsg{}
+		if err := json.Unmarshal(b, msgs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return msgs
+}
+
+func (fs *fakeSession) reset() {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	fs.msgs = nil
+}
+
+func affected(t *testing.T, msg *wsMsg) map[string]*modules.AccountMini {
+	if msg.Method != NOTIFY_BLOCK_ADDED {
+		t.Fatalf("Expected %s, got %+v", NOTIFY_BLOCK_ADDED, msg)
+	}
+	bm := &modules.BlockMini{}
+	if err := json.Unmarshal(msg.Params, bm); err != nil {
+		t.Fatal(err)
+	}
+	accs := make(map[string]*modules.AccountMini)
+	for _, am := range bm.AccountsAffected {
+		accs[am.Address] = am
+	}
+	return accs
+}
+
+func TestWebSocketAPI(t *testing.T) {
+	fc := newFakeChain()
+	session := newFakeSession(7)
+	api := NewWebSocketAPIFactory(fc).CreateService(session)
+	api.Init()
+
+	api.HandleRPC([]byte(`{"jsonrpc":"2.0","id":1,"method":"MyAddress"}`))
+	msgs := session.wait(t, 1)
+	if *msgs[0].Id != 1 || string(msgs[0].Result) != `"aa01"` {
+		t.Fatalf("Bad response: %+v", msgs[0])
+	}
+	session.reset()
+
+	// A transaction, and a block with it.
+	api.HandleRPC([]byte(`{"jsonrpc":"2.0","id":2,"method":"Transact","params":{"Recipient":"aa02","Value":"300"}}`))
+	api.HandleRPC([]byte(`{"jsonrpc":"2.0","method":"Commit"}`))
+	msgs = session.wait(t, 2)
+	accs := affected(t, msgs[1])
+	if len(accs) != 2 || accs["aa01"].Balance != "700" || accs["aa02"].Balance != "300" || accs["aa02"].Flag != ACCOUNT_MODIFIED {
+		t.Fatalf("Bad affected accounts: %s", msgs[1].Params)
+	}
+	session.reset()
+
+	api.HandleRPC([]byte(`{"jsonrpc":"2.0","id":3,"method":"Transact","params":{"Data":"code"}}`))
+	api.HandleRPC([]byte(`{"jsonrpc":"2.0","method":"Commit"}`))
+	msgs = session.wait(t, 2)
+	receipt := &modules.TxReceipt{}
+	json.Unmarshal(msgs[0].Result, receipt)
+	accs = affected(t, msgs[1])
+	if am := accs[receipt.Address]; am == nil || am.Flag != ACCOUNT_CREATED || !am.Contract {
+		t.Fatalf("Contract not created: %s", msgs[1].Params)
+	}
+	session.reset()
+
+	// Three blocks, then three accounts, then the response.
+	api.HandleRPC([]byte(`{"jsonrpc":"2.0","id":4,"method":"WorldState"}`))
+	msgs = session.wait(t, 9)
+	expected := []string{NOTIFY_NUM_BLOCKS, NOTIFY_BLOCK, NOTIFY_BLOCK, NOTIFY_BLOCK, NOTIFY_ACCOUNT, NOTIFY_ACCOUNT, NOTIFY_ACCOUNT, NOTIFY_NUM_ACCOUNTS, ""}
+	for i, m := range msgs {
+		if m.Method != expected[i] {
+			t.Fatalf("Message %d: expected %q, got %q", i, expected[i], m.Method)
+		}
+	}
+	if string(msgs[1].Params) == "" || string(msgs[8].Result) != `{"Accounts":3,"Blocks":3}` {
+		t.Fatalf("Bad world state: %s, %s", msgs[1].Params, msgs[8].Result)
+	}
+
+	api.Shutdown()
+	if n := fc.subCount(); n != 0 {
+		t.Fatalf("%d subscriptions left after shutdown", n)
+	}
+}
+
+func TestWebSocketAPIQueuesBlocksWhileSyncing(t *testing.T) {
+	fc := newFakeChain()
+	session := newFakeSession(1)
+	api := newWebSocketAPI(fc, session)
+
+	api.syncing = true
+	api.blockAdded(&modules.Block{Number: "1", Hash: "b1", Coinbase: "aa01"})
+	if msgs := session.wait(t, 0); len(msgs) != 0 {
+		t.Fatalf("Block pushed while syncing: %+v", msgs)
+	}
+	api.flushBlocks()
+	msgs := session.wait(t, 1)
+	if accs := affected(t, msgs[0]); accs["aa01"] == nil {
+		t.Fatal("Coinbase not affected")
+	}
+	if api.syncing {
+		t.Fatal("Still syncing")
+	}
+}
+
+func TestWebSocketAPISubscriptions(t *testing.T) {
+	fc := newFakeChain()
+	session := newFakeSession(3)
+	api := NewWebSocketAPIFactory(fc).CreateService(session)
+	api.Init()
+	fixed := fc.subCount()
+
+	api.HandleRPC([]byte(`{"jsonrpc":"2.0","id":1,"method":"subscribe","params":{"Event":"storageChanged","Target":"cc01"}}`))
+	api.HandleRPC([]byte(`{"jsonrpc":"2.0","id":2,"method":"subscribe","params":["addressChanged"]}`))
+	api.HandleRPC([]byte(`{"jsonrpc":"2.0","id":3,"method":"subscribe","params":["noSuchEvent"]}`))
+	msgs := session.wait(t, 3)
+	var id1, id2 string
+	if err := json.Unmarshal(msgs[0].Result, &id1); err != nil || id1 == "" {
+		t.Fatalf("No subscription id: %+v", msgs[0])
+	}
+	if err := json.Unmarshal(msgs[1].Result, &id2); err != nil || id2 == "" || id2 == id1 {
+		t.Fatalf("Bad subscription id: %+v", msgs[1])
+	}
+	if msgs[2].Error == nil || msgs[2].Error.Code != CHAIN_ERROR {
+		t.Fatalf("Expected an error for an unknown event: %+v", msgs[2])
+	}
+	if n := fc.subCount(); n != fixed+2 {
+		t.Fatalf("Expected %d subscriptions, got %d", fixed+2, n)
+	}
+	if ids := api.listener().Subscriptions(); len(ids) != 2 {
+		t.Fatalf("Expected 2 client subscriptions, got %v", ids)
+	}
+	session.reset()
+
+	// Only the matching target is pushed.
+	fc.post(events.EVENT_STORAGE_CHANGED, "cc02", &modules.StorageChange{Address: "cc02", Storage: "0x1", Value: "0x5"})
+	fc.post(events.EVENT_STORAGE_CHANGED, "cc01", &modules.StorageChange{Address: "cc01", Storage: "0x1", Value: "0x2"})
+	session.wait(t, 1)
+	time.Sleep(20 * time.Millisecond)
+	if msgs = session.wait(t, 1); len(msgs) != 1 || msgs[0].Method != NOTIFY_EVENT {
+		t.Fatalf("Expected one event, got %+v", msgs)
+	}
+	pushed := &struct {
+		Subscription string
+		Event        struct {
+			Event    string
+			Target   string
+			Resource *modules.StorageChange
+		}
+	}{}
+	if err := json.Unmarshal(msgs[0].Params, pushed); err != nil {
+		t.Fatal(err)
+	}
+	if pushed.Subscription != id1 || pushed.Event.Target != "cc01" || pushed.Event.Resource.Value != "0x2" {
+		t.Fatalf("Bad event: %s", msgs[0].Params)
+	}
+	session.reset()
+
+	api.HandleRPC([]byte(`{"jsonrpc":"2.0","id":4,"method":"unsubscribe","params":["` + id1 + `"]}`))
+	api.HandleRPC([]byte(`{"jsonrpc":"2.0","id":5,"method":"unsubscribe","params":["` + id1 + `"]}`))
+	msgs = session.wait(t, 2)
+	if string(msgs[0].Result) != "true" || msgs[1].Error == nil {
+		t.Fatalf("Bad unsubscribe responses: %+v, %+v", msgs[0], msgs[1])
+	}
+	if n := fc.subCount(); n != fixed+1 {
+		t.Fatalf("Expected %d subscriptions, got %d", fixed+1, n)
+	}
+
+	// Closing the session removes the rest.
+	api.Shutdown()
+	if n := fc.subCount(); n != 0 {
+		t.Fatalf("%d subscriptions left after shutdown", n)
+	}
+	session.reset()
+	api.HandleRPC([]byte(`{"jsonrpc":"2.0","id":6,"method":"subscribe","params":["newBlock"]}`))
+	if msgs = session.wait(t, 1); msgs[0].Error == nil {
+		t.Fatal("Subscribed after shutdown")
+	}
+}
+
+// A subscription made while the session closes is rolled back, not left behind.
+func TestWebSocketAPISubscribeWhileClosing(t *testing.T) {
+	fc := newFakeChain()
+	api := NewWebSocketAPIFactory(fc).CreateService(newFakeSession(4))
+	api.Init()
+	fc.subscribed = func(name string) {
+		api.Shutdown()
+	}
+	if _, err := api.listener().subscribe(events.EVENT_NEW_BLOCK, ""); err == nil || err.Error() != "The session is closed" {
+		t.Fatalf("Expected the session to be closed, got: %v", err)
+	}
+	if n := fc.subCount(); n != 0 {
+		t.Fatalf("%d subscriptions left after shutdown", n)
+	}
+}
+
+// A chain that pages by itself is streamed a page at a time.
+func TestWebSocketAPIWorldStatePages(t *testing.T) {
+	pages := 0
+	pc := pagerChain{manyAccounts(250), &pages}
+	api := newWebSocketAPI(pc, newFakeSession(5))
+	res, err := api.WorldState(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := res.(map[string]int)["Accounts"]; n != len(pc.addresses()) {
+		t.Fatalf("Expected %d accounts, got %d", len(pc.addresses()), n)
+	}
+	if pages != 3 {
+		t.Fatalf("Expected 3 pages from the chain, got %d", pages)
+	}
+}
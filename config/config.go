@@ -0,0 +1,486 @@
+// Package config loads module configuration structs in layers: defaults, then a
+// json file, then environment variables, then explicit overrides (from flags, or
+// the decerver). Every field can be validated with a 'validate' struct tag:
+//
+//	Port     int    `json:"port" validate:"port"`
+//	RootDir  string `json:"root_dir" validate:"required"`
+//	LogLevel int    `json:"log_level" validate:"min=0,max=5"`
+//
+// A field is read from the environment variable <prefix>_<NAME>, where NAME is
+// the upper case json name, e.g. DECERVER_ETH_PORT or DECERVER_ETH_ROOT_DIR.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/eris-ltd/decerver-interfaces/core"
+)
+
+// Where the value of a field came from.
+type Source int
+
+const (
+	SourceDefault Source = iota
+	SourceFile
+	SourceEnv
+	SourceOverride
+)
+
+func (s Source) String() string {
+	switch s {
+	case SourceDefault:
+		return "default"
+	case SourceFile:
+		return "file"
+	case SourceEnv:
+		return "environment"
+	case SourceOverride:
+		return "override"
+	}
+	return "unknown"
+}
+
+// A problem with a single field.
+type FieldError struct {
+	Field  string
+	Source Source
+	// Env var name, file name etc. May be empty.
+	Origin string
+	Err    error
+}
+
+func (fe *FieldError) Error() string {
+	if fe.Origin != "" {
+		return fmt.Sprintf("%s (from %s %s): %s", fe.Field, fe.Source, fe.Origin, fe.Err)
+	}
+	return fmt.Sprintf("%s (from %s): %s", fe.Field, fe.Source, fe.Err)
+}
+
+// Error holds every field error found while loading.
+type Error struct {
+	Errors []*FieldError
+}
+
+func (e *Error) Error() string {
+	buf := &bytes.Buffer{}
+	buf.WriteString("Invalid configuration:")
+	for _, fe := range e.Errors {
+		buf.WriteString("\n  ")
+		buf.WriteString(fe.Error())
+	}
+	return buf.String()
+}
+
+// Loader describes the layers. Everything but Defaults is optional.
+type Loader struct {
+	// A pointer to a struct of the same type as the one being loaded.
+	Defaults interface{}
+	// Json file. If it does not exist it is skipped. If it exists but can not be
+	// read or parsed, Load fails; the file is never overwritten in that case.
+	File string
+	// Write the loaded config to File if it does not exist yet.
+	WriteIfMissing bool
+	// Prefix of environment variables, like "DECERVER_ETH". Empty means no env layer.
+	EnvPrefix string
+	// By field name or json name.
+	Overrides map[string]interface{}
+	// Defaults to os.Getenv.
+	Getenv func(string) string
+}
+
+// Load fills 'cfg' (a pointer to a struct) from all layers, and validates it. If
+// anything is wrong, a *Error is returned with every problem, and cfg is left alone.
+// The struct is set in place, so other references to it see the new values.
+func (l *Loader) Load(cfg interface{}) error {
+	cv, err := structValue(cfg)
+	if err != nil {
+		return err
+	}
+	// Work on a copy, so a failed load changes nothing.
+	tmp := reflect.New(cv.Type())
+	if l.Defaults != nil {
+		dv, err := structValue(l.Defaults)
+		if err != nil {
+			return err
+		}
+		if dv.Type() != cv.Type() {
+			return fmt.Errorf("Defaults are a %v, config is a %v", dv.Type(), cv.Type())
+		}
+		tmp.Elem().Set(dv)
+	}
+	fields := fieldsOf(cv.Type())
+	sources := make(map[string]*FieldError)
+	for _, f := range fields {
+		sources[f.name] = &FieldError{Field: f.name, Source: SourceDefault}
+	}
+	errs := make([]*FieldError, 0)
+
+	missing := false
+	if l.File != "" {
+		b, err := ioutil.ReadFile(l.File)
+		if os.IsNotExist(err) {
+			missing = true
+		} else if err != nil {
+			return fmt.Errorf("Could not read config file %s: %s", l.File, err)
+		} else {
+			if err := json.Unmarshal(b, tmp.Interface()); err != nil {
+				return fmt.Errorf("Could not parse config file %s: %s", l.File, err)
+			}
+			present := make(map[string]interface{})
+			json.Unmarshal(b, &present)
+			for _, f := range fields {
+				if _, ok := present[f.json]; ok {
+					sources[f.name] = &FieldError{Field: f.name, Source: SourceFile, Origin: l.File}
+				}
+			}
+		}
+	}
+
+	if l.EnvPrefix != "" {
+		getenv := l.Getenv
+		if getenv == nil {
+			getenv = os.Getenv
+		}
+		for _, f := range fields {
+			name := EnvName(l.EnvPrefix, f.json)
+			val := getenv(name)
+			if val == "" {
+				continue
+			}
+			src := &FieldError{Field: f.name, Source: SourceEnv, Origin: name}
+			sources[f.name] = src
+			if err := setValue(tmp.Elem().Field(f.index), val); err != nil {
+				src.Err = err
+				errs = append(errs, src)
+			}
+		}
+	}
+
+	for key, val := range l.Overrides {
+		f := findField(fields, key)
+		if f == nil {
+			errs = append(errs, &FieldError{Field: key, Source: SourceOverride, Err: fmt.Errorf("No such field")})
+			continue
+		}
+		src := &FieldError{Field: f.name, Source: SourceOverride}
+		sources[f.name] = src
+		if err := setValue(tmp.Elem().Field(f.index), val); err != nil {
+			src.Err = err
+			errs = append(errs, src)
+		}
+	}
+
+	for _, f := range fields {
+		if err := validateField(f, tmp.Elem().Field(f.index)); err != nil {
+			src := sources[f.name]
+			if src.Err == nil {
+				src.Err = err
+				errs = append(errs, src)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return &Error{errs}
+	}
+
+	cv.Set(tmp.Elem())
+	if missing && l.WriteIfMissing {
+		if err := Write(cfg, l.File); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Set a single field (by field or json name), converting the value to the field
+// type, and validate it. On error the field is left alone.
+func Set(cfg interface{}, field string, value interface{}) error {
+	cv, err := structValue(cfg)
+	if err != nil {
+		return err
+	}
+	f := findField(fieldsOf(cv.Type()), field)
+	if f == nil {
+		return fmt.Errorf("No config field named: %s", field)
+	}
+	tmp := reflect.New(f.typ).Elem()
+	if err := setValue(tmp, value); err != nil {
+		return fmt.Errorf("Invalid value for %s: %s", f.name, err)
+	}
+	if err := validateField(*f, tmp); err != nil {
+		return fmt.Errorf("Invalid value for %s: %s", f.name, err)
+	}
+	cv.Field(f.index).Set(tmp)
+	return nil
+}
+
+// Validate every field of a config struct.
+func Validate(cfg interface{}) error {
+	cv, err := structValue(cfg)
+	if err != nil {
+		return err
+	}
+	errs := make([]*FieldError, 0)
+	for _, f := range fieldsOf(cv.Type()) {
+		if err := validateField(f, cv.Field(f.index)); err != nil {
+			errs = append(errs, &FieldError{Field: f.name, Err: err})
+		}
+	}
+	if len(errs) > 0 {
+		return &Error{errs}
+	}
+	return nil
+}
+
+// Write a config as indented json. The file is replaced atomically.
+func Write(cfg interface{}, filename string) error {
+	b, err := json.MarshalIndent(cfg, "", "\t")
+	if err != nil {
+		return err
+	}
+	return core.WriteFileAtomic(filename, b, 0600)
+}
+
+// The environment variable for a field.
+func EnvName(prefix, jsonName string) string {
+	name := strings.ToUpper(strings.Replace(jsonName, "-", "_", -1))
+	return prefix + "_" + name
+}
+
+type field struct {
+	name     string
+	json     string
+	index    int
+	typ      reflect.Type
+	validate string
+}
+
+func fieldsOf(t reflect.Type) []field {
+	fields := make([]field, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		jsonName := sf.Name
+		if tag := sf.Tag.Get("json"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			if n := strings.Split(tag, ",")[0]; n != "" {
+				jsonName = n
+			}
+		}
+		fields = append(fields, field{sf.Name, jsonName, i, sf.Type, sf.Tag.Get("validate")})
+	}
+	return fields
+}
+
+func findField(fields []field, name string) *field {
+	for i := range fields {
+		if fields[i].name == name || fields[i].json == name {
+			return &fields[i]
+		}
+	}
+	return nil
+}
+
+func structValue(cfg interface{}) (reflect.Value, error) {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}, fmt.Errorf("Config must be a pointer to a struct, got %T", cfg)
+	}
+	return v.Elem(), nil
+}
+
+// Set a field from a string (env vars, flags) or any go value. Numbers are
+// converted between types as long as nothing is lost, so a float64 from
+// javascript or json can set an int.
+func setValue(f reflect.Value, value interface{}) error {
+	if s, ok := value.(string); ok && f.Kind() != reflect.String {
+		return parseValue(f, s)
+	}
+	v := reflect.ValueOf(value)
+	if !v.IsValid() {
+		f.Set(reflect.Zero(f.Type()))
+		return nil
+	}
+	if v.Type().AssignableTo(f.Type()) {
+		f.Set(v)
+		return nil
+	}
+	switch f.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			i = v.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if v.Uint() > math.MaxInt64 {
+				return fmt.Errorf("%v is out of range", value)
+			}
+			i = int64(v.Uint())
+		case reflect.Float32, reflect.Float64:
+			fl := v.Float()
+			if fl != math.Trunc(fl) || fl >= math.MaxInt64 || fl < math.MinInt64 {
+				return fmt.Errorf("%v is not an integer", value)
+			}
+			i = int64(fl)
+		default:
+			return fmt.Errorf("Expected %v, got %T", f.Type(), value)
+		}
+		if f.OverflowInt(i) {
+			return fmt.Errorf("%v is out of range", value)
+		}
+		f.SetInt(i)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var u uint64
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if v.Int() < 0 {
+				return fmt.Errorf("%v is negative", value)
+			}
+			u = uint64(v.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			u = v.Uint()
+		case reflect.Float32, reflect.Float64:
+			fl := v.Float()
+			if fl != math.Trunc(fl) || fl < 0 || fl >= math.MaxUint64 {
+				return fmt.Errorf("%v is not a positive integer", value)
+			}
+			u = uint64(fl)
+		default:
+			return fmt.Errorf("Expected %v, got %T", f.Type(), value)
+		}
+		if f.OverflowUint(u) {
+			return fmt.Errorf("%v is out of range", value)
+		}
+		f.SetUint(u)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			f.SetFloat(float64(v.Int()))
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			f.SetFloat(float64(v.Uint()))
+		case reflect.Float32, reflect.Float64:
+			f.SetFloat(v.Float())
+		default:
+			return fmt.Errorf("Expected %v, got %T", f.Type(), value)
+		}
+		return nil
+	}
+	return fmt.Errorf("Expected %v, got %T", f.Type(), value)
+}
+
+func parseValue(f reflect.Value, s string) error {
+	switch f.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, f.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("'%s' is not a valid %v", s, f.Type())
+		}
+		f.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 10, f.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("'%s' is not a valid %v", s, f.Type())
+		}
+		f.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		fl, err := strconv.ParseFloat(s, f.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("'%s' is not a number", s)
+		}
+		f.SetFloat(fl)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a bool", s)
+		}
+		f.SetBool(b)
+	case reflect.Slice:
+		if f.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("Can not set a %v from a string", f.Type())
+		}
+		parts := strings.Split(s, ",")
+		f.Set(reflect.ValueOf(parts).Convert(f.Type()))
+	default:
+		return fmt.Errorf("Can not set a %v from a string", f.Type())
+	}
+	return nil
+}
+
+// Rules, comma separated:
+//
+//	required   not the zero value
+//	port       an integer from 0 to 65535
+//	min=N      a number >= N, or a string/slice with at least N elements
+//	max=N      a number <= N, or a string/slice with at most N elements
+func validateField(f field, v reflect.Value) error {
+	if f.validate == "" {
+		return nil
+	}
+	for _, rule := range strings.Split(f.validate, ",") {
+		rule = strings.TrimSpace(rule)
+		arg := ""
+		if i := strings.Index(rule, "="); i >= 0 {
+			rule, arg = rule[:i], rule[i+1:]
+		}
+		switch rule {
+		case "required":
+			if reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface()) {
+				return fmt.Errorf("A value is required")
+			}
+		case "port":
+			n, ok := number(v)
+			if !ok || n < 0 || n > 65535 || n != math.Trunc(n) {
+				return fmt.Errorf("%v is not a valid port", v.Interface())
+			}
+		case "min", "max":
+			limit, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				return fmt.Errorf("Bad validation rule '%s=%s'", rule, arg)
+			}
+			n, ok := number(v)
+			if !ok {
+				switch v.Kind() {
+				case reflect.String, reflect.Slice, reflect.Map:
+					n = float64(v.Len())
+				default:
+					return fmt.Errorf("Rule '%s' does not apply to %v", rule, v.Type())
+				}
+			}
+			if rule == "min" && n < limit {
+				return fmt.Errorf("%v is less than %v", v.Interface(), limit)
+			}
+			if rule == "max" && n > limit {
+				return fmt.Errorf("%v is more than %v", v.Interface(), limit)
+			}
+		default:
+			return fmt.Errorf("Unknown validation rule '%s'", rule)
+		}
+	}
+	return nil
+}
+
+func number(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
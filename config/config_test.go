@@ -0,0 +1,180 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testConfig struct {
+	Port     int      `json:"port" validate:"port"`
+	Mining   bool     `json:"mining"`
+	RootDir  string   `json:"root_dir" validate:"required"`
+	LogLevel int      `json:"log_level" validate:"min=0,max=5"`
+	Ratio    float64  `json:"ratio"`
+	Peers    []string `json:"peers"`
+	Plain    uint16
+}
+
+var testDefaults = &testConfig{
+	Port:     30303,
+	RootDir:  "/tmp/chain",
+	LogLevel: 5,
+	Ratio:    1,
+}
+
+func env(vars map[string]string) func(string) string {
+	return func(name string) string {
+		return vars[name]
+	}
+}
+
+func tempFile(t *testing.T, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fname := filepath.Join(dir, "config.json")
+	if content != "" {
+		ioutil.WriteFile(fname, []byte(content), 0600)
+	}
+	return fname, func() { os.RemoveAll(dir) }
+}
+
+func TestLayers(t *testing.T) {
+	fname, cleanup := tempFile(t, `{"port": 40000, "mining": true, "log_level": 3}`)
+	defer cleanup()
+	l := &Loader{
+		Defaults:  testDefaults,
+		File:      fname,
+		EnvPrefix: "DECERVER_ETH",
+		Getenv: env(map[string]string{
+			"DECERVER_ETH_PORT":  "40001",
+			"DECERVER_ETH_PEERS": "a:1,b:2",
+			"DECERVER_ETH_PLAIN": "7",
+		}),
+		Overrides: map[string]interface{}{"ratio": 0.5, "LogLevel": float64(2)},
+	}
+	cfg := &testConfig{}
+	if err := l.Load(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != 40001 {
+		t.Errorf("Expected port from env, Got: %d", cfg.Port)
+	}
+	if !cfg.Mining {
+		t.Error("Expected mining from file")
+	}
+	if cfg.RootDir != "/tmp/chain" {
+		t.Errorf("Expected root dir default, Got: %s", cfg.RootDir)
+	}
+	if cfg.LogLevel != 2 || cfg.Ratio != 0.5 {
+		t.Errorf("Expected overrides, Got: %d, %v", cfg.LogLevel, cfg.Ratio)
+	}
+	if len(cfg.Peers) != 2 || cfg.Peers[1] != "b:2" || cfg.Plain != 7 {
+		t.Errorf("Unexpected env values: %v, %d", cfg.Peers, cfg.Plain)
+	}
+	// Defaults are not touched.
+	if testDefaults.Port != 30303 {
+		t.Fatal("Defaults were changed")
+	}
+}
+
+func TestValidationErrors(t *testing.T) {
+	fname, cleanup := tempFile(t, `{"port": 70000, "root_dir": ""}`)
+	defer cleanup()
+	l := &Loader{
+		Defaults:  testDefaults,
+		File:      fname,
+		EnvPrefix: "X",
+		Getenv:    env(map[string]string{"X_MINING": "yes please"}),
+		Overrides: map[string]interface{}{"log_level": 9, "nothing": 1},
+	}
+	cfg := &testConfig{Port: 1}
+	err := l.Load(cfg)
+	cerr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("Expected a config error, Got: %v", err)
+	}
+	if len(cerr.Errors) != 5 {
+		t.Fatalf("Expected 5 errors, Got: %s", cerr)
+	}
+	msg := cerr.Error()
+	for _, s := range []string{"Port (from file", "Mining (from environment X_MINING)", "LogLevel (from override)", "nothing", "RootDir (from file"} {
+		if !strings.Contains(msg, s) {
+			t.Errorf("Expected '%s' in: %s", s, msg)
+		}
+	}
+	if cfg.Port != 1 {
+		t.Fatal("Config was changed by a failed load")
+	}
+}
+
+func TestBadFileIsNotOverwritten(t *testing.T) {
+	fname, cleanup := tempFile(t, `{"port": 40000,`)
+	defer cleanup()
+	l := &Loader{Defaults: testDefaults, File: fname, WriteIfMissing: true}
+	if err := l.Load(&testConfig{}); err == nil {
+		t.Fatal("Expected a parse error")
+	}
+	b, _ := ioutil.ReadFile(fname)
+	if string(b) != `{"port": 40000,` {
+		t.Fatalf("File was overwritten: %s", b)
+	}
+}
+
+func TestWriteIfMissing(t *testing.T) {
+	fname, cleanup := tempFile(t, "")
+	defer cleanup()
+	l := &Loader{Defaults: testDefaults, File: fname, WriteIfMissing: true}
+	cfg := &testConfig{}
+	if err := l.Load(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg, testDefaults) {
+		t.Fatalf("Expected defaults, Got: %v", cfg)
+	}
+	cfg2 := &testConfig{}
+	if err := (&Loader{File: fname}).Load(cfg2); err != nil {
+		t.Fatal(err)
+	}
+	if cfg2.Port != 30303 || cfg2.RootDir != "/tmp/chain" {
+		t.Fatalf("Expected the written defaults, Got: %v", cfg2)
+	}
+}
+
+func TestSet(t *testing.T) {
+	cfg := &testConfig{Port: 1, RootDir: "x"}
+	if err := Set(cfg, "Port", float64(8080)); err != nil {
+		t.Fatal(err)
+	}
+	if err := Set(cfg, "log_level", "4"); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != 8080 || cfg.LogLevel != 4 {
+		t.Fatalf("Unexpected values: %v", cfg)
+	}
+	bad := map[string]interface{}{
+		"Port":     1.5,
+		"port":     -1,
+		"RootDir":  "",
+		"Mining":   "maybe",
+		"Plain":    -3,
+		"Nothing":  1,
+		"LogLevel": true,
+	}
+	for field, value := range bad {
+		if err := Set(cfg, field, value); err == nil {
+			t.Errorf("Expected setting %s to %v to fail", field, value)
+		}
+	}
+	if cfg.Port != 8080 || cfg.RootDir != "x" {
+		t.Fatalf("Failed sets changed the config: %v", cfg)
+	}
+	if err := Validate(cfg); err != nil {
+		t.Fatal(err)
+	}
+}
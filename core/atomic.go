@@ -0,0 +1,84 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Suffix of the lock files used by WriteFileAtomic.
+const LOCK_FILE_SUFFIX = ".lock"
+
+// WriteFileAtomic writes data to a temporary file in the same directory, syncs it
+// and renames it over 'filename', so readers see either the old or the new content
+// and never a half written file. An advisory lock on '<filename>.lock' is held
+// while writing, so concurrent writers (even in other processes) take turns.
+func WriteFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	unlock, err := LockFile(filename)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	dir, base := filepath.Split(filename)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := ioutil.TempFile(dir, "."+base+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	// Only does something if we fail before the rename.
+	defer os.Remove(tmpName)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, filename); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// LockFile takes an exclusive advisory lock for 'filename', waiting for it if
+// needed. The lock is on a separate '<filename>.lock' file, since the file itself
+// is replaced on every atomic write. Call the returned function to release it.
+func LockFile(filename string) (func(), error) {
+	f, err := os.OpenFile(filename+LOCK_FILE_SUFFIX, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
+
+// Make a rename durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	// Not supported everywhere (windows), and the data itself is already synced.
+	d.Sync()
+	return nil
+}
@@ -0,0 +1,111 @@
+package core
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "atomic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "config.json")
+
+	if err := WriteFileAtomic(fname, []byte("first"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFileAtomic(fname, []byte("second"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "second" {
+		t.Fatalf("Expected: second, Got: %s", b)
+	}
+	fi, _ := os.Stat(fname)
+	if fi.Mode().Perm() != 0644 {
+		t.Errorf("Expected mode 0644, Got: %v", fi.Mode().Perm())
+	}
+	// Only the file and its lock file are left.
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 2 {
+		t.Errorf("Expected 2 files, Got: %d", len(files))
+	}
+}
+
+// Concurrent writers must never leave a mix of their data behind.
+func TestWriteFileAtomicConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "atomic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "config.json")
+
+	datas := make([][]byte, 8)
+	for i := range datas {
+		datas[i] = bytes.Repeat([]byte{byte('a' + i)}, 64*1024)
+	}
+	wg := &sync.WaitGroup{}
+	for _, data := range datas {
+		wg.Add(1)
+		go func(data []byte) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if err := WriteFileAtomic(fname, data, 0600); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(data)
+	}
+	// Read while writing. Content must always be one complete write.
+	for i := 0; i < 50; i++ {
+		b, err := ioutil.ReadFile(fname)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(b) != 64*1024 || !bytes.Equal(b, bytes.Repeat(b[:1], len(b))) {
+			t.Fatal("Read a partially written file")
+		}
+	}
+	wg.Wait()
+}
+
+func TestLockFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "atomic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "state")
+
+	unlock, err := LockFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		WriteFileAtomic(fname, []byte("x"), 0600)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("Write did not wait for the lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	<-done
+}
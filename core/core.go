@@ -1,15 +1,28 @@
 package core
 
 import (
+	"context"
+	"errors"
 	"log"
 	"os"
+	"time"
 )
 
+// Returned by Runtime calls that run past their deadline.
+var ErrScriptTimeout = errors.New("Script execution timed out")
+
 type DCConfig struct {
 	RootDir    string `json:"decerverDirectory"`
 	LogFile    string `json:"logFile"`
 	MaxClients int    `json:"maxClients"`
 	Port       int    `json:"portNumber"`
+	// Default time limit for a script call, in milliseconds. 0 means no limit.
+	ScriptTimeout int `json:"scriptTimeout"`
+}
+
+// The script timeout as a duration.
+func (cfg *DCConfig) ScriptTimeoutDuration() time.Duration {
+	return time.Duration(cfg.ScriptTimeout) * time.Millisecond
 }
 
 type DeCerver interface {
@@ -52,6 +65,13 @@ type Runtime interface {
 	AddScript(script string) error
 	CallFunc(funcName string, param ...interface{}) (interface{}, error)
 	CallFuncOnObj(objName, funcName string, param ...interface{}) (interface{}, error)
+	// Like CallFunc and CallFuncOnObj, but the script is interrupted when ctx is done.
+	// ErrScriptTimeout is returned if the deadline passed.
+	CallFuncContext(ctx context.Context, funcName string, param ...interface{}) (interface{}, error)
+	CallFuncOnObjContext(ctx context.Context, objName, funcName string, param ...interface{}) (interface{}, error)
+	// The default time limit for calls. 0 means no limit.
+	SetTimeout(timeout time.Duration)
+	Timeout() time.Duration
 }
 
 func NewLogger(name string) *log.Logger {
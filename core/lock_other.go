@@ -0,0 +1,15 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package core
+
+import "os"
+
+// No advisory locking here. Writes are still atomic.
+func lockFile(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,231 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Directory layout under the decerver root.
+const (
+	LOG_DIR         = "logs"
+	DAPPS_DIR       = "dapps"
+	BLOCKCHAINS_DIR = "blockchains"
+	FILESYSTEMS_DIR = "filesystems"
+	MODULES_DIR     = "modules"
+	SYSTEM_DIR      = "system"
+)
+
+// Paths is the standard implementation of FileIO. ReadFile and WriteFile only
+// accept directories handed out by the object itself (or subdirectories of them),
+// and names that stay inside those directories: absolute names, names that climb
+// out with '..' and symlinks that point outside are all rejected.
+//
+// A scoped view (see Scope) gives a module or dapp its own subdirectory of each
+// directory, and can not reach anything outside of those.
+type Paths struct {
+	root        string
+	log         string
+	dapps       string
+	blockchains string
+	filesystems string
+	modules     string
+	system      string
+}
+
+// Create the directory layout under cfg.RootDir (if it does not already exist).
+func NewPaths(cfg *DCConfig) (*Paths, error) {
+	if cfg.RootDir == "" {
+		return nil, fmt.Errorf("No decerver root directory set")
+	}
+	root, err := filepath.Abs(cfg.RootDir)
+	if err != nil {
+		return nil, err
+	}
+	p := &Paths{
+		root:        root,
+		log:         filepath.Join(root, LOG_DIR),
+		dapps:       filepath.Join(root, DAPPS_DIR),
+		blockchains: filepath.Join(root, BLOCKCHAINS_DIR),
+		filesystems: filepath.Join(root, FILESYSTEMS_DIR),
+		modules:     filepath.Join(root, MODULES_DIR),
+		system:      filepath.Join(root, SYSTEM_DIR),
+	}
+	if err := p.mkdirs(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Scope returns a view for a single module or dapp. Each of its directories is
+// a subdirectory with the given name, e.g. Dapps() is <root>/dapps/<name> and
+// Modules() is <root>/modules/<name>. Root() is the decerver root, but the view
+// can only read and write inside its own directories.
+func (p *Paths) Scope(name string) (*Paths, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return nil, fmt.Errorf("Invalid scope name: '%s'", name)
+	}
+	sp := &Paths{
+		root:        p.root,
+		log:         filepath.Join(p.log, name),
+		dapps:       filepath.Join(p.dapps, name),
+		blockchains: filepath.Join(p.blockchains, name),
+		filesystems: filepath.Join(p.filesystems, name),
+		modules:     filepath.Join(p.modules, name),
+		system:      filepath.Join(p.system, name),
+	}
+	if err := sp.mkdirs(); err != nil {
+		return nil, err
+	}
+	return sp, nil
+}
+
+func (p *Paths) Root() string {
+	return p.root
+}
+
+func (p *Paths) Log() string {
+	return p.log
+}
+
+func (p *Paths) Dapps() string {
+	return p.dapps
+}
+
+func (p *Paths) Blockchains() string {
+	return p.blockchains
+}
+
+func (p *Paths) Filesystems() string {
+	return p.filesystems
+}
+
+func (p *Paths) Modules() string {
+	return p.modules
+}
+
+func (p *Paths) System() string {
+	return p.system
+}
+
+func (p *Paths) ReadFile(directory, name string) ([]byte, error) {
+	fpath, err := p.Resolve(directory, name)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(fpath)
+}
+
+// WriteFile creates missing parent directories of 'name'. The file is written
+// atomically, under an advisory lock (see WriteFileAtomic).
+func (p *Paths) WriteFile(directory, name string, data []byte) error {
+	fpath, err := p.Resolve(directory, name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+		return err
+	}
+	// MkdirAll follows symlinks, so check again now that the parents exist.
+	if fpath, err = p.Resolve(directory, name); err != nil {
+		return err
+	}
+	// The lock file is created next to the file, so it must not escape either.
+	if _, err := p.Resolve(directory, name+LOCK_FILE_SUFFIX); err != nil {
+		return err
+	}
+	return WriteFileAtomic(fpath, data, 0600)
+}
+
+// Resolve returns the full path of 'name' in 'directory', or an error if that would
+// be outside of the directories of this object.
+func (p *Paths) Resolve(directory, name string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("No file name given")
+	}
+	if filepath.IsAbs(name) {
+		return "", fmt.Errorf("Invalid file name '%s': absolute paths are not allowed", name)
+	}
+	cleanName := filepath.Clean(name)
+	if cleanName == "." || cleanName == ".." || strings.HasPrefix(cleanName, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("Invalid file name '%s': outside of directory", name)
+	}
+	dir := filepath.Clean(directory)
+	base := p.baseOf(dir)
+	if base == "" {
+		return "", fmt.Errorf("Access denied: '%s' is not a decerver directory", directory)
+	}
+	fpath := filepath.Join(dir, cleanName)
+
+	// Follow symlinks, and make sure we still end up in the same place.
+	realBase, err := filepath.EvalSymlinks(base)
+	if err != nil {
+		return "", err
+	}
+	realPath, err := evalExisting(fpath)
+	if err != nil {
+		return "", err
+	}
+	if !isInside(realBase, realPath) {
+		return "", fmt.Errorf("Access denied: '%s' leads outside of '%s'", name, base)
+	}
+	return fpath, nil
+}
+
+// The directory that 'dir' is in (or is), or "" if none.
+func (p *Paths) baseOf(dir string) string {
+	for _, base := range p.dirs() {
+		if isInside(base, dir) {
+			return base
+		}
+	}
+	return ""
+}
+
+func (p *Paths) dirs() []string {
+	return []string{p.log, p.dapps, p.blockchains, p.filesystems, p.modules, p.system}
+}
+
+func (p *Paths) mkdirs() error {
+	for _, d := range p.dirs() {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Is 'fpath' equal to or below 'dir'? Both must be clean.
+func isInside(dir, fpath string) bool {
+	if dir == fpath {
+		return true
+	}
+	return strings.HasPrefix(fpath, dir+string(filepath.Separator))
+}
+
+// EvalSymlinks for paths that may not exist yet: the longest existing prefix is
+// evaluated and the rest is appended.
+func evalExisting(fpath string) (string, error) {
+	rest := ""
+	for {
+		real, err := filepath.EvalSymlinks(fpath)
+		if err == nil {
+			return filepath.Join(real, rest), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		// A dangling symlink could still be written through.
+		if _, lerr := os.Lstat(fpath); lerr == nil {
+			return "", fmt.Errorf("Access denied: '%s' is a broken symlink", fpath)
+		}
+		parent := filepath.Dir(fpath)
+		if parent == fpath {
+			return "", err
+		}
+		rest = filepath.Join(filepath.Base(fpath), rest)
+		fpath = parent
+	}
+}
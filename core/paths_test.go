@@ -0,0 +1,144 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func tempPaths(t *testing.T) *Paths {
+	dir, err := ioutil.TempDir("", "decerver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewPaths(&DCConfig{RootDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestLayout(t *testing.T) {
+	p := tempPaths(t)
+	defer os.RemoveAll(p.Root())
+	for _, d := range []string{p.Log(), p.Dapps(), p.Blockchains(), p.Filesystems(), p.Modules(), p.System()} {
+		if fi, err := os.Stat(d); err != nil || !fi.IsDir() {
+			t.Errorf("Expected directory %s", d)
+		}
+	}
+	// FileIO is satisfied
+	var _ FileIO = p
+}
+
+func TestReadWrite(t *testing.T) {
+	p := tempPaths(t)
+	defer os.RemoveAll(p.Root())
+
+	if err := p.WriteFile(p.System(), "config.json", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.WriteFile(p.Dapps(), "mydapp/models/model.js", []byte("var x;")); err != nil {
+		t.Fatal(err)
+	}
+	b, err := p.ReadFile(filepath.Join(p.Dapps(), "mydapp"), "models/model.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "var x;" {
+		t.Fatalf("Expected: var x;, Got: %s", b)
+	}
+}
+
+func TestTraversal(t *testing.T) {
+	p := tempPaths(t)
+	defer os.RemoveAll(p.Root())
+
+	bad := []struct{ dir, name string }{
+		{p.Dapps(), "../system/config.json"},
+		{p.Dapps(), "a/../../system/x"},
+		{p.Dapps(), ".."},
+		{p.Dapps(), "/etc/passwd"},
+		{p.Dapps(), ""},
+		{p.Root(), "x"},
+		{"/tmp", "x"},
+		{filepath.Join(p.Dapps(), ".."), "x"},
+	}
+	for _, c := range bad {
+		if err := p.WriteFile(c.dir, c.name, []byte("x")); err == nil {
+			t.Errorf("Expected write of '%s' in '%s' to fail", c.name, c.dir)
+		}
+		if _, err := p.ReadFile(c.dir, c.name); err == nil {
+			t.Errorf("Expected read of '%s' in '%s' to fail", c.name, c.dir)
+		}
+	}
+}
+
+func TestSymlinks(t *testing.T) {
+	p := tempPaths(t)
+	defer os.RemoveAll(p.Root())
+	outside, err := ioutil.TempDir("", "outside")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+	ioutil.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0600)
+
+	os.Symlink(outside, filepath.Join(p.Dapps(), "escape"))
+	os.Symlink(filepath.Join(outside, "nothing"), filepath.Join(p.Dapps(), "dangling"))
+	os.Symlink(p.System(), filepath.Join(p.Dapps(), "inside"))
+
+	if _, err := p.ReadFile(p.Dapps(), "escape/secret"); err == nil {
+		t.Error("Expected read through escaping symlink to fail")
+	}
+	if err := p.WriteFile(p.Dapps(), "escape/new", []byte("x")); err == nil {
+		t.Error("Expected write through escaping symlink to fail")
+	}
+	if err := p.WriteFile(p.Dapps(), "dangling", []byte("x")); err == nil {
+		t.Error("Expected write through dangling symlink to fail")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "nothing")); err == nil {
+		t.Error("File was created outside of the root")
+	}
+	// Links to another decerver directory are still outside of 'dapps'.
+	if err := p.WriteFile(p.Dapps(), "inside/x", []byte("x")); err == nil {
+		t.Error("Expected write through symlink to another directory to fail")
+	}
+}
+
+func TestScope(t *testing.T) {
+	p := tempPaths(t)
+	defer os.RemoveAll(p.Root())
+
+	a, err := p.Scope("dappA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := p.Scope("dappB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Dapps() != filepath.Join(p.Dapps(), "dappA") {
+		t.Fatalf("Unexpected scoped dir: %s", a.Dapps())
+	}
+	if err := a.WriteFile(a.Dapps(), "index.html", []byte("<html>")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.ReadFile(a.Dapps(), "index.html"); err == nil {
+		t.Error("Expected dappB to be denied access to dappA's files")
+	}
+	if _, err := b.ReadFile(b.Dapps(), "../dappA/index.html"); err == nil {
+		t.Error("Expected dappB to be denied access to dappA's files")
+	}
+	if _, err := a.ReadFile(p.Dapps(), "dappA/index.html"); err == nil {
+		t.Error("Expected scoped view to be denied access to the shared directory")
+	}
+	if _, err := p.ReadFile(p.Dapps(), "dappA/index.html"); err != nil {
+		t.Error(err)
+	}
+	for _, name := range []string{"", "..", "a/b"} {
+		if _, err := p.Scope(name); err == nil {
+			t.Errorf("Expected scope name '%s' to be rejected", name)
+		}
+	}
+}
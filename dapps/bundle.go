@@ -0,0 +1,319 @@
+package dapps
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/eris-ltd/decerver-interfaces/core"
+)
+
+// A dapp bundle is a gzipped tarball of the dapp directory (package file, index
+// file and models folder with the loading order file). The detached signature
+// is kept next to it, in a file with the same name plus SIGNATURE_SUFFIX.
+const (
+	BUNDLE_SUFFIX       = ".tar.gz"
+	SIGNATURE_SUFFIX    = ".sig"
+	SIGNATURE_ALGORITHM = "ed25519"
+	// Upper limit for the unpacked size of a bundle, in bytes.
+	MAX_BUNDLE_SIZE = 256 << 20
+)
+
+var (
+	ErrUntrustedKey = errors.New("Bundle is not signed by a trusted key")
+	ErrBadSignature = errors.New("Bundle signature does not match")
+)
+
+// A detached bundle signature. Keys and signatures are hex encoded.
+type Signature struct {
+	Algorithm string `json:"algorithm"`
+	PublicKey string `json:"public_key"`
+	Signature string `json:"signature"`
+}
+
+func ParseSignature(b []byte) (*Signature, error) {
+	sig := &Signature{}
+	if err := json.Unmarshal(b, sig); err != nil {
+		return nil, fmt.Errorf("Invalid signature file: %s", err)
+	}
+	return sig, nil
+}
+
+// Parse a hex encoded ed25519 public key.
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	b, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(b) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("Invalid public key: %s", s)
+	}
+	return ed25519.PublicKey(b), nil
+}
+
+// Pack validates the dapp in dir and returns it as a bundle. The same files
+// always give the same bundle: entries are sorted, and modification times,
+// owners and permissions are not kept. The source record of an installed dapp
+// is left out.
+func Pack(dir string) ([]byte, error) {
+	if _, err := Validate(dir); err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	gz, _ := gzip.NewWriterLevel(buf, gzip.BestCompression)
+	tw := tar.NewWriter(gz)
+	err := filepath.Walk(dir, func(fpath string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, fpath)
+		if err != nil {
+			return err
+		}
+		if rel == "." || rel == SOURCE_FILE_NAME {
+			return nil
+		}
+		hdr := &tar.Header{
+			Name:    filepath.ToSlash(rel),
+			ModTime: time.Unix(0, 0),
+		}
+		switch {
+		case fi.IsDir():
+			hdr.Typeflag = tar.TypeDir
+			hdr.Name += "/"
+			hdr.Mode = 0755
+		case fi.Mode().IsRegular():
+			hdr.Typeflag = tar.TypeReg
+			hdr.Mode = 0644
+			hdr.Size = fi.Size()
+		default:
+			return fmt.Errorf("Can not pack '%s': not a regular file or directory", rel)
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil
+		}
+		f, err := os.Open(fpath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.CopyN(tw, f, hdr.Size)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Pack the dapp in dir into the bundle file.
+func PackFile(dir, filename string) error {
+	b, err := Pack(dir)
+	if err != nil {
+		return err
+	}
+	return core.WriteFileAtomic(filename, b, 0644)
+}
+
+func Sign(bundle []byte, key ed25519.PrivateKey) *Signature {
+	return &Signature{
+		Algorithm: SIGNATURE_ALGORITHM,
+		PublicKey: hex.EncodeToString(key.Public().(ed25519.PublicKey)),
+		Signature: hex.EncodeToString(ed25519.Sign(key, bundle)),
+	}
+}
+
+// Sign a bundle file. The signature is written to the bundle file name plus SIGNATURE_SUFFIX.
+func SignFile(filename string, key ed25519.PrivateKey) error {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	sb, err := json.MarshalIndent(Sign(b, key), "", "\t")
+	if err != nil {
+		return err
+	}
+	return core.WriteFileAtomic(filename+SIGNATURE_SUFFIX, sb, 0644)
+}
+
+// Verify checks that the signature is from one of the trusted keys, and that it
+// matches the bundle.
+func Verify(bundle []byte, sig *Signature, trusted []ed25519.PublicKey) error {
+	if sig.Algorithm != SIGNATURE_ALGORITHM {
+		return fmt.Errorf("Unsupported signature algorithm: %s", sig.Algorithm)
+	}
+	pub, err := ParsePublicKey(sig.PublicKey)
+	if err != nil {
+		return err
+	}
+	isTrusted := false
+	for _, t := range trusted {
+		if bytes.Equal(t, pub) {
+			isTrusted = true
+			break
+		}
+	}
+	if !isTrusted {
+		return ErrUntrustedKey
+	}
+	s, err := hex.DecodeString(sig.Signature)
+	if err != nil || !ed25519.Verify(pub, bundle, s) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// Unpack a bundle into dir. Only regular files and directories are allowed,
+// and every entry must stay inside dir.
+func Unpack(bundle []byte, dir string) error {
+	gz, err := gzip.NewReader(bytes.NewReader(bundle))
+	if err != nil {
+		return fmt.Errorf("Invalid bundle: %s", err)
+	}
+	tr := tar.NewReader(gz)
+	var total int64
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Invalid bundle: %s", err)
+		}
+		name := path.Clean(hdr.Name)
+		if name == "." || path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") || strings.Contains(hdr.Name, `\`) {
+			return fmt.Errorf("Invalid bundle entry: %s", hdr.Name)
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			total += hdr.Size
+			if hdr.Size < 0 || total > MAX_BUNDLE_SIZE {
+				return fmt.Errorf("Bundle is larger than %d bytes", MAX_BUNDLE_SIZE)
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+			if err != nil {
+				return err
+			}
+			_, err = io.CopyN(f, tr, hdr.Size)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("Invalid bundle entry: %s is not a regular file or directory", hdr.Name)
+		}
+	}
+}
+
+// Install verifies a bundle, unpacks it and validates the dapp. It is then moved
+// to its own directory (the dapp id) in fileIO.Dapps(). If another version of the
+// dapp is installed, the new version is added to the version store and the active
+// version stays as it is. Nothing is left behind if any step fails, and an installed
+// version is never overwritten.
+func Install(fileIO core.FileIO, bundle []byte, sig *Signature, trusted []ed25519.PublicKey) (*PackageFile, error) {
+	if err := Verify(bundle, sig, trusted); err != nil {
+		return nil, err
+	}
+	return installWith(fileIO, "", nil, func(dir string) error {
+		return Unpack(bundle, dir)
+	})
+}
+
+// Fills a temporary directory in fileIO.Dapps() with fill, validates the dapp in it
+// and moves it into place. If id is not empty, the package file must have that id.
+// The source is recorded in the dapp directory if it is not nil.
+func installWith(fileIO core.FileIO, id string, src *Source, fill func(dir string) error) (*PackageFile, error) {
+	tmp, err := ioutil.TempDir(fileIO.Dapps(), ".install-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	if err := fill(tmp); err != nil {
+		return nil, err
+	}
+	pf, err := Validate(tmp)
+	if err != nil {
+		if ve, ok := err.(*ValidationError); ok {
+			ve.Dir = "bundle"
+			if src != nil {
+				ve.Dir = src.Hash
+			}
+		}
+		return nil, err
+	}
+	if id != "" && pf.Id != id {
+		return nil, fmt.Errorf("Expected dapp '%s', but the package file has id '%s'", id, pf.Id)
+	}
+	target := filepath.Join(fileIO.Dapps(), pf.Id)
+	if active, err := ActiveVersion(fileIO, pf.Id); err == nil {
+		// Another version is installed; this one goes to the version store.
+		if active != pf.Version {
+			if target, err = versionDir(fileIO, pf.Id, pf.Version); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := os.Stat(target); err == nil {
+			return nil, fmt.Errorf("Version %s of dapp '%s' is already installed", pf.Version, pf.Id)
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return nil, err
+		}
+	} else if _, err := os.Stat(target); err == nil {
+		return nil, fmt.Errorf("Dapp directory '%s' exists, but has no valid package file", pf.Id)
+	}
+	if src != nil {
+		if err := writeSource(tmp, src); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.Chmod(tmp, 0755); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		return nil, err
+	}
+	return pf, nil
+}
+
+// Install a bundle file, with the signature from the file next to it.
+func InstallFile(fileIO core.FileIO, filename string, trusted []ed25519.PublicKey) (*PackageFile, error) {
+	bundle, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	sb, err := ioutil.ReadFile(filename + SIGNATURE_SUFFIX)
+	if err != nil {
+		return nil, fmt.Errorf("Missing bundle signature: %s", err)
+	}
+	sig, err := ParseSignature(sb)
+	if err != nil {
+		return nil, err
+	}
+	return Install(fileIO, bundle, sig, trusted)
+}
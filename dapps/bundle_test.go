@@ -0,0 +1,124 @@
+package dapps
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/eris-ltd/decerver-interfaces/core"
+)
+
+func goodDapp(t *testing.T) string {
+	return writeDapp(t, map[string]string{
+		PACKAGE_FILE_NAME: goodPackage,
+		INDEX_FILE_NAME:   "<html></html>",
+		path.Join(MODELS_FOLDER_NAME, LOADING_ORDER_FILE_NAME): `{"loading_order": ["a.js"]}`,
+		path.Join(MODELS_FOLDER_NAME, "a.js"):                  "var a = 1;",
+	})
+}
+
+func tempFileIO(t *testing.T) *core.Paths {
+	dir, err := ioutil.TempDir("", "decerver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := core.NewPaths(&core.DCConfig{RootDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestPackIsReproducible(t *testing.T) {
+	dir := goodDapp(t)
+	defer os.RemoveAll(dir)
+	b1, err := Pack(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(path.Join(dir, INDEX_FILE_NAME), later, later)
+	b2, err := Pack(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b1, b2) {
+		t.Error("Expected the same bundle")
+	}
+}
+
+func TestSignVerifyInstall(t *testing.T) {
+	dir := goodDapp(t)
+	defer os.RemoveAll(dir)
+	fileIO := tempFileIO(t)
+	defer os.RemoveAll(fileIO.Root())
+
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	otherPub, _, _ := ed25519.GenerateKey(nil)
+
+	bundleFile := path.Join(fileIO.Root(), "testdapp"+BUNDLE_SUFFIX)
+	if err := PackFile(dir, bundleFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := SignFile(bundleFile, priv); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := InstallFile(fileIO, bundleFile, []ed25519.PublicKey{otherPub}); err != ErrUntrustedKey {
+		t.Errorf("Expected ErrUntrustedKey, got: %v", err)
+	}
+
+	bundle, _ := ioutil.ReadFile(bundleFile)
+	tampered := append([]byte{}, bundle...)
+	tampered[len(tampered)/2] ^= 0xff
+	if _, err := Install(fileIO, tampered, Sign(bundle, priv), []ed25519.PublicKey{pub}); err != ErrBadSignature {
+		t.Errorf("Expected ErrBadSignature, got: %v", err)
+	}
+
+	pf, err := InstallFile(fileIO, bundleFile, []ed25519.PublicKey{otherPub, pub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pf.Id != "testdapp" {
+		t.Errorf("Wrong package file: %v", pf)
+	}
+	b, err := ioutil.ReadFile(path.Join(fileIO.Dapps(), "testdapp", MODELS_FOLDER_NAME, "a.js"))
+	if err != nil || string(b) != "var a = 1;" {
+		t.Errorf("Model file not installed: %v", err)
+	}
+
+	if _, err := InstallFile(fileIO, bundleFile, []ed25519.PublicKey{pub}); err == nil {
+		t.Error("Expected an error installing the dapp twice")
+	}
+	// No temporary directories left.
+	fis, _ := ioutil.ReadDir(fileIO.Dapps())
+	if len(fis) != 1 {
+		t.Errorf("Expected only the installed dapp, found %d entries", len(fis))
+	}
+}
+
+func TestUnpackRejectsEscapes(t *testing.T) {
+	for _, hdr := range []*tar.Header{
+		{Name: "../evil.js", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "/evil.js", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"},
+	} {
+		buf := &bytes.Buffer{}
+		gz := gzip.NewWriter(buf)
+		tw := tar.NewWriter(gz)
+		tw.WriteHeader(hdr)
+		tw.Close()
+		gz.Close()
+		dir, _ := ioutil.TempDir("", "unpack")
+		if err := Unpack(buf.Bytes(), dir); err == nil {
+			t.Errorf("Expected an error for '%s'", hdr.Name)
+		}
+		os.RemoveAll(dir)
+	}
+}
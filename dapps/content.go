@@ -0,0 +1,121 @@
+package dapps
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/eris-ltd/decerver-interfaces/core"
+)
+
+// Where an installed dapp came from, kept in the dapp directory so it can be updated.
+const SOURCE_FILE_NAME = ".source.json"
+
+// A content addressed store for directory trees, e.g. ipfs. Hashes are hex encoded.
+type ContentStore interface {
+	// Add the directory and everything in it. Returns the root hash.
+	PushTree(dir string) (string, error)
+	// Write the tree with the given root hash into dir, which already exists.
+	FetchTree(hash, dir string) error
+}
+
+type Source struct {
+	Id   string `json:"id"`
+	Hash string `json:"hash"`
+}
+
+// Publish validates the dapp in dir and pushes it to the store. Returns the root
+// hash, which is what InstallFromHash takes. A dapp that was installed from a hash
+// can be published again, e.g. after it was changed: its source file is left out
+// of the tree, and the new hash is recorded in it.
+func Publish(store ContentStore, dir string) (string, error) {
+	pf, err := Validate(dir)
+	if err != nil {
+		return "", err
+	}
+	src, err := readSource(dir)
+	if err != nil {
+		return "", err
+	}
+	if src == nil {
+		return store.PushTree(dir)
+	}
+	if src.Id != pf.Id {
+		return "", fmt.Errorf("Dapp '%s' was installed as '%s'", pf.Id, src.Id)
+	}
+	// Push a copy without the source file. Pack leaves it out.
+	bundle, err := Pack(dir)
+	if err != nil {
+		return "", err
+	}
+	tmp, err := ioutil.TempDir("", "publish-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+	if err := Unpack(bundle, tmp); err != nil {
+		return "", err
+	}
+	hash, err := store.PushTree(tmp)
+	if err != nil {
+		return "", err
+	}
+	if hash != src.Hash {
+		src.Hash = hash
+		if err := writeSource(dir, src); err != nil {
+			return "", err
+		}
+	}
+	return hash, nil
+}
+
+// InstallFromHash fetches the dapp with the given root hash into fileIO.Dapps() and
+// records the hash. The package file must have the given id. As with Install, nothing
+// is left behind if a step fails.
+func InstallFromHash(fileIO core.FileIO, store ContentStore, id, hash string) (*PackageFile, error) {
+	if id == "" {
+		return nil, fmt.Errorf("No dapp id given")
+	}
+	src := &Source{Id: id, Hash: hash}
+	return installWith(fileIO, id, src, func(dir string) error {
+		if err := store.FetchTree(hash, dir); err != nil {
+			return fmt.Errorf("Could not fetch dapp '%s' (%s): %s", id, hash, err)
+		}
+		return nil
+	})
+}
+
+// The source of an installed dapp, or an error if it was not installed from a hash.
+func ReadSource(fileIO core.FileIO, id string) (*Source, error) {
+	src, err := readSource(filepath.Join(fileIO.Dapps(), id))
+	if err == nil && src == nil {
+		err = fmt.Errorf("No source recorded for dapp '%s'", id)
+	}
+	return src, err
+}
+
+// The source recorded in a dapp directory, or nil if there is none.
+func readSource(dir string) (*Source, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, SOURCE_FILE_NAME))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	src := &Source{}
+	if err := json.Unmarshal(b, src); err != nil {
+		return nil, fmt.Errorf("Invalid %s: %s", SOURCE_FILE_NAME, err)
+	}
+	return src, nil
+}
+
+func writeSource(dir string, src *Source) error {
+	b, err := json.MarshalIndent(src, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, SOURCE_FILE_NAME), b, 0644)
+}
@@ -0,0 +1,116 @@
+package dapps
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Keeps copies of the pushed trees in memory.
+type memStore struct {
+	trees map[string]map[string][]byte
+}
+
+func (ms *memStore) PushTree(dir string) (string, error) {
+	files := make(map[string][]byte)
+	h := sha256.New()
+	err := filepath.Walk(dir, func(fpath string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, fpath)
+		b, err := ioutil.ReadFile(fpath)
+		files[rel] = b
+		h.Write([]byte(rel))
+		h.Write(b)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+	ms.trees[hash] = files
+	return hash, nil
+}
+
+func (ms *memStore) FetchTree(hash, dir string) error {
+	files, ok := ms.trees[hash]
+	if !ok {
+		return os.ErrNotExist
+	}
+	for rel, b := range files {
+		p := filepath.Join(dir, rel)
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := ioutil.WriteFile(p, b, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestPublishAndInstallFromHash(t *testing.T) {
+	dir := goodDapp(t)
+	defer os.RemoveAll(dir)
+	fileIO := tempFileIO(t)
+	defer os.RemoveAll(fileIO.Root())
+	store := &memStore{make(map[string]map[string][]byte)}
+
+	hash, err := Publish(store, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := InstallFromHash(fileIO, store, "otherdapp", hash); err == nil || !strings.Contains(err.Error(), "has id 'testdapp'") {
+		t.Errorf("Expected an id mismatch, got: %v", err)
+	}
+	if _, err := InstallFromHash(fileIO, store, "testdapp", "00"); err == nil {
+		t.Error("Expected an error for an unknown hash")
+	}
+	if _, err := ReadSource(fileIO, "testdapp"); err == nil {
+		t.Error("Expected no source before installing")
+	}
+
+	pf, err := InstallFromHash(fileIO, store, "testdapp", hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pf.Id != "testdapp" {
+		t.Errorf("Wrong package file: %v", pf)
+	}
+	src, err := ReadSource(fileIO, "testdapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.Hash != hash || src.Id != "testdapp" {
+		t.Errorf("Wrong source: %v", src)
+	}
+
+	// An installed dapp publishes to the same hash, and packs like the original.
+	installed := path.Join(fileIO.Dapps(), "testdapp")
+	if h, err := Publish(store, installed); err != nil || h != hash {
+		t.Errorf("Expected to publish the installed dapp as %s, got: %s, %v", hash, h, err)
+	}
+	b1, _ := Pack(dir)
+	b2, err := Pack(installed)
+	if err != nil || string(b1) != string(b2) {
+		t.Errorf("Expected the same bundle: %v", err)
+	}
+
+	// Once changed, it gets a new hash, which is recorded.
+	ioutil.WriteFile(path.Join(installed, INDEX_FILE_NAME), []byte("<html>new</html>"), 0644)
+	h, err := Publish(store, installed)
+	if err != nil || h == hash {
+		t.Fatalf("Expected a new hash, got: %s, %v", h, err)
+	}
+	if src, _ := ReadSource(fileIO, "testdapp"); src == nil || src.Hash != h {
+		t.Errorf("Expected the new hash to be recorded: %v", src)
+	}
+	if _, ok := store.trees[h][SOURCE_FILE_NAME]; ok {
+		t.Error("Expected the source file to be left out")
+	}
+}
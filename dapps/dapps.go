@@ -30,6 +30,7 @@ type (
 		Bugs               *Bugs               `json:"bugs"`
 		Licence            *Licence            `json:"licence"`
 		ModuleDependencies []*ModuleDependency `json:"module_dependencies"`
+		Quotas             *Quotas             `json:"quotas"`
 	}
 
 	Author struct {
@@ -54,9 +55,24 @@ type (
 	ModuleDependency struct {
 		Name    string          `json:"name"`
 		Version string          `json:"version"`
-		Data    json.RawMessage `json:data`
+		Data    json.RawMessage `json:"data"`
 	}
 
+	// Resource limits for the dapp runtime. A zero value means no limit.
+	Quotas struct {
+		// Approximate size of the script state, in bytes.
+		MaxMemory int64 `json:"max_memory"`
+		// Event subscriptions the dapp can have at the same time.
+		MaxSubscriptions int `json:"max_subscriptions"`
+		// Calls per second into the objects that modules bind to the runtime,
+		// all methods together.
+		CallsPerSecond float64 `json:"calls_per_second"`
+		// Calls per second for single methods, e.g. "monk.Tx" : 1. These apply
+		// in addition to CallsPerSecond.
+		MethodCallsPerSecond map[string]float64 `json:"method_calls_per_second"`
+	}
+
+	// Data of the "monk" module dependency.
 	MonkData struct {
 		RootContract      string `json:"root_contract"`
 		ChainId           string `json:"blockchain_id"`
@@ -76,6 +92,8 @@ type DappInfo struct {
 	Licence    *Licence    `json:"licence"`
 }
 
+// The loading order file is in the models folder. It lists the model files to
+// load, relative to that folder.
 type LoadOrderConfig struct {
 	LoadingOrder []string `json:"loading_order"`
 }
@@ -105,5 +123,16 @@ func NewPackageFileFromJson(pfJson []byte) (*PackageFile, error) {
 
 type DappRegistry interface {
 	GetDappList() []*DappInfo
+	// Fails with the report from modules.CheckDapp if the module dependencies
+	// of the dapp are not met.
 	LoadDapp(dappId string) error
+	// Install a dapp from a content hash (see InstallFromHash).
+	InstallDapp(dappId, hash string) error
+	// Push a dapp to the content store and return its root hash (see Publish).
+	PublishDapp(dappId string) (string, error)
+	// Stop a dapp and release its runtime, subscriptions and routes.
+	UnloadDapp(dappId string) error
+	DappStatus(dappId string) (*DappStatus, error)
+	// Make one of the installed versions of a dapp the active one.
+	SetActiveVersion(dappId, version string) error
 }
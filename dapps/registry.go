@@ -0,0 +1,587 @@
+package dapps
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eris-ltd/decerver-interfaces/core"
+	"github.com/eris-ltd/decerver-interfaces/network"
+)
+
+var logger = core.NewLogger("Dapps")
+
+// Runtime managers that can limit a runtime, like scripting.RuntimeManager. The
+// registry uses this when it can, so the quotas in the package file apply.
+type QuotaRuntimeManager interface {
+	CreateRuntimeWithQuotas(name string, quotas *Quotas) core.Runtime
+}
+
+// ReloadError is returned when a new version of a loaded dapp fails to load. The
+// registry then goes back to the version that was running before.
+type ReloadError struct {
+	Id  string
+	Err error
+	// The version that is running again, if the rollback worked.
+	RolledBackTo string
+	RollbackErr  error
+}
+
+func (re *ReloadError) Error() string {
+	if re.RollbackErr != nil {
+		return fmt.Sprintf("Reloading dapp '%s' failed: %s. Rolling back failed too: %s", re.Id, re.Err, re.RollbackErr)
+	}
+	return fmt.Sprintf("Reloading dapp '%s' failed: %s. Rolled back to version %s", re.Id, re.Err, re.RolledBackTo)
+}
+
+type DappState int
+
+const (
+	// Installed, but not running: never loaded, or unloaded.
+	DappStopped DappState = iota
+	DappLoaded
+	DappFailed
+)
+
+func (s DappState) String() string {
+	switch s {
+	case DappStopped:
+		return "stopped"
+	case DappLoaded:
+		return "loaded"
+	case DappFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+func (s DappState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+type DappStatus struct {
+	Id    string    `json:"id"`
+	State DappState `json:"state"`
+	// Why the dapp failed.
+	Error         string   `json:"error"`
+	ActiveVersion string   `json:"active_version"`
+	Versions      []string `json:"versions"`
+	// Anything else that is off, like the directory of a loaded dapp being gone.
+	Detail string `json:"detail"`
+}
+
+// A loaded version of a dapp. It keeps the source of its models, so its runtime can
+// be started again after a failed upgrade.
+type dappVersion struct {
+	path string
+	pf   *PackageFile
+	// Model files in loading order, relative to the models folder.
+	order []string
+	// Model file -> source.
+	models map[string]string
+}
+
+func (dv *dappVersion) GetModels() []string {
+	models := make([]string, len(dv.order))
+	for i, m := range dv.order {
+		models[i] = filepath.Join(dv.path, MODELS_FOLDER_NAME, filepath.FromSlash(m))
+	}
+	return models
+}
+
+func (dv *dappVersion) GetPath() string {
+	return dv.path
+}
+
+func (dv *dappVersion) GetPackageFile() *PackageFile {
+	return dv.pf
+}
+
+type dappEntry struct {
+	state DappState
+	err   error
+	// Nil unless the dapp is loaded.
+	current  *dappVersion
+	previous *dappVersion
+	// The fingerprint of the dapp directory when it was last loaded, or tried to.
+	fingerprint string
+	// If the dapp has routes on the server.
+	registered bool
+}
+
+// Registry is an implementation of DappRegistry. Every loaded dapp gets a runtime
+// with the same name as the dapp id, with its model files run in loading order.
+//
+// A loaded dapp can be reloaded, either explicitly or by watching the dapp directories.
+// Reloading tears the runtime down (which cancels the subscriptions the scripts made)
+// and builds a new one. If that fails, the runtime of the version that was running
+// is started again; the files in the dapp directory are left as they are, so they
+// can be fixed. The previous version is kept after a successful reload, so it can
+// be rolled back to as well.
+//
+// Several versions of a dapp can be installed; the active one is in the dapp directory
+// (see VERSIONS_DIR_NAME and SetActiveVersion). Unloading a dapp releases its runtime,
+// its subscriptions and its routes on the server.
+type Registry struct {
+	mutex  *sync.Mutex
+	fileIO core.FileIO
+	rm     core.RuntimeManager
+	loaded map[string]*dappEntry
+	// Closed to stop watching.
+	stop chan struct{}
+
+	// Checks the module dependencies of a dapp before it is loaded, e.g.
+	// modules.CheckDapp with the module registry. Optional.
+	CheckDependencies func(pf *PackageFile) error
+	// Where InstallDapp and PublishDapp get and put dapps. Optional.
+	Store ContentStore
+	// Loaded dapps are registered with the server, and unregistered when they
+	// are unloaded. Optional.
+	Server network.Server
+}
+
+func NewRegistry(fileIO core.FileIO, rm core.RuntimeManager) *Registry {
+	r := &Registry{}
+	r.mutex = &sync.Mutex{}
+	r.fileIO = fileIO
+	r.rm = rm
+	r.loaded = make(map[string]*dappEntry)
+	return r
+}
+
+// The installed dapps (the ones with a package file), sorted by id.
+func (r *Registry) GetDappList() []*DappInfo {
+	fis, err := ioutil.ReadDir(r.fileIO.Dapps())
+	if err != nil {
+		logger.Printf("Could not list dapps: %s\n", err)
+		return []*DappInfo{}
+	}
+	list := make([]*DappInfo, 0, len(fis))
+	for _, fi := range fis {
+		if !fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+		pf, err := readPackageFile(filepath.Join(r.fileIO.Dapps(), fi.Name()))
+		if err != nil {
+			continue
+		}
+		list = append(list, DappInfoFromPackageFile(pf))
+	}
+	sort.Sort(dappInfoById(list))
+	return list
+}
+
+type dappInfoById []*DappInfo
+
+func (l dappInfoById) Len() int           { return len(l) }
+func (l dappInfoById) Less(i, j int) bool { return l[i].Id < l[j].Id }
+func (l dappInfoById) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+// The loaded dapp with the given id, or nil.
+func (r *Registry) GetDapp(dappId string) Dapp {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if e, ok := r.loaded[dappId]; ok && e.state == DappLoaded {
+		return e.current
+	}
+	return nil
+}
+
+// The ids of the loaded dapps.
+func (r *Registry) LoadedDapps() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	ids := make([]string, 0, len(r.loaded))
+	for id, e := range r.loaded {
+		if e.state == DappLoaded {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// The state of an installed dapp, and the versions that are installed. A loaded
+// dapp whose directory has been removed keeps its state; the version it runs is
+// reported, and the missing directory is noted in Detail.
+func (r *Registry) DappStatus(dappId string) (*DappStatus, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	dir, err := r.dappDir(dappId)
+	if err != nil {
+		return nil, err
+	}
+	st := &DappStatus{Id: dappId, State: DappStopped}
+	e, loaded := r.loaded[dappId]
+	if loaded {
+		st.State = e.state
+		if e.err != nil {
+			st.Error = e.err.Error()
+		}
+	}
+	versions, err := InstalledVersions(r.fileIO, dappId)
+	if err != nil {
+		if !loaded {
+			return nil, err
+		}
+		st.Detail = fmt.Sprintf("Dapp directory %s is missing", dir)
+		st.Versions = []string{}
+		if e.current != nil {
+			st.ActiveVersion = e.current.pf.Version
+			st.Versions = append(st.Versions, st.ActiveVersion)
+		}
+		return st, nil
+	}
+	st.Versions = versions
+	st.ActiveVersion, _ = ActiveVersion(r.fileIO, dappId)
+	return st, nil
+}
+
+// Stop a dapp: its runtime is shut down (which cancels its subscriptions), its routes
+// are removed from the server and the registry forgets the versions it kept.
+func (r *Registry) UnloadDapp(dappId string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	e, ok := r.loaded[dappId]
+	if !ok {
+		return fmt.Errorf("Dapp '%s' is not loaded", dappId)
+	}
+	r.release(dappId, e)
+	delete(r.loaded, dappId)
+	logger.Printf("Unloaded dapp '%s'\n", dappId)
+	return nil
+}
+
+func (r *Registry) release(dappId string, e *dappEntry) {
+	r.rm.RemoveRuntime(dappId)
+	if e.registered && r.Server != nil {
+		r.Server.UnregisterDapp(dappId)
+	}
+	e.registered = false
+	e.current = nil
+	e.previous = nil
+}
+
+// Put the dapp in the failed state. Everything it had is released.
+func (r *Registry) fail(dappId string, e *dappEntry, err error) {
+	r.release(dappId, e)
+	e.state = DappFailed
+	e.err = err
+	logger.Printf("Dapp '%s' failed: %s\n", dappId, err)
+}
+
+// Make an installed version of a dapp the active one. If the dapp is loaded, the
+// version is loaded in its place; if that fails, the version that was active is
+// put back and a *ReloadError is returned.
+func (r *Registry) SetActiveVersion(dappId, version string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	dir, err := r.dappDir(dappId)
+	if err != nil {
+		return err
+	}
+	prev, err := activate(r.fileIO, dappId, version)
+	if err != nil || prev == version {
+		return err
+	}
+	logger.Printf("Dapp '%s' version %s is now active\n", dappId, version)
+	e, ok := r.loaded[dappId]
+	if !ok || e.state != DappLoaded {
+		return nil
+	}
+	v, err := r.readVersion(dappId, dir)
+	if err == nil {
+		err = r.start(dappId, v)
+	}
+	if err == nil {
+		// The previous version is in the version store, not just in memory.
+		e.previous = nil
+		e.current = v
+		e.fingerprint, _ = fingerprint(dir)
+		return nil
+	}
+	re := &ReloadError{Id: dappId, Err: err}
+	if _, aerr := activate(r.fileIO, dappId, prev); aerr != nil {
+		re.RollbackErr = aerr
+	} else if serr := r.start(dappId, e.current); serr != nil {
+		re.RollbackErr = serr
+	} else {
+		re.RolledBackTo = prev
+		e.fingerprint, _ = fingerprint(dir)
+	}
+	if re.RollbackErr != nil {
+		r.fail(dappId, e, re)
+	}
+	return re
+}
+
+// Load a dapp from its directory in fileIO.Dapps(). Loading a dapp that is already
+// loaded reloads it.
+func (r *Registry) LoadDapp(dappId string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.load(dappId)
+}
+
+// Reload a loaded dapp from its directory. If the new version fails to load, the
+// old one is put back and a *ReloadError is returned.
+func (r *Registry) ReloadDapp(dappId string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if e, ok := r.loaded[dappId]; !ok || e.state != DappLoaded {
+		return fmt.Errorf("Dapp '%s' is not loaded", dappId)
+	}
+	return r.load(dappId)
+}
+
+// Go back to the version that was loaded before the last reload. The version that
+// is rolled back from becomes the previous one. Only the runtime is rolled back, not
+// the files in the dapp directory.
+func (r *Registry) Rollback(dappId string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	e, ok := r.loaded[dappId]
+	if !ok || e.state != DappLoaded {
+		return fmt.Errorf("Dapp '%s' is not loaded", dappId)
+	}
+	if e.previous == nil {
+		return fmt.Errorf("Dapp '%s' has no previous version", dappId)
+	}
+	if err := r.start(dappId, e.previous); err != nil {
+		// Try to get the current version running again.
+		if cerr := r.start(dappId, e.current); cerr != nil {
+			logger.Printf("Could not restore dapp '%s': %s\n", dappId, cerr)
+		}
+		return err
+	}
+	e.current, e.previous = e.previous, e.current
+	logger.Printf("Rolled back dapp '%s' to version %s\n", dappId, e.current.pf.Version)
+	return nil
+}
+
+// Install a dapp from the content store (see InstallFromHash). A dapp that was not
+// installed before is loaded. A new version of an installed dapp is only added; see
+// SetActiveVersion.
+func (r *Registry) InstallDapp(dappId, hash string) error {
+	if r.Store == nil {
+		return fmt.Errorf("No content store to install from")
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	_, err := ActiveVersion(r.fileIO, dappId)
+	isNew := err != nil
+	if _, err := InstallFromHash(r.fileIO, r.Store, dappId, hash); err != nil {
+		return err
+	}
+	if !isNew {
+		return nil
+	}
+	return r.load(dappId)
+}
+
+// Push an installed dapp to the content store (see Publish).
+func (r *Registry) PublishDapp(dappId string) (string, error) {
+	if r.Store == nil {
+		return "", fmt.Errorf("No content store to publish to")
+	}
+	dir, err := r.dappDir(dappId)
+	if err != nil {
+		return "", err
+	}
+	return Publish(r.Store, dir)
+}
+
+// Watch the directories of the loaded dapps, and reload a dapp when its files have
+// changed (and then stayed the same for one interval).
+func (r *Registry) Watch(interval time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.stop != nil {
+		return
+	}
+	stop := make(chan struct{})
+	r.stop = stop
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		// Dapp id -> fingerprint of a change that has not been loaded yet.
+		pending := make(map[string]string)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				r.poll(pending)
+			}
+		}
+	}()
+}
+
+func (r *Registry) StopWatching() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.stop != nil {
+		close(r.stop)
+		r.stop = nil
+	}
+}
+
+func (r *Registry) poll(pending map[string]string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for id, e := range r.loaded {
+		if e.state != DappLoaded {
+			continue
+		}
+		fp, err := fingerprint(e.current.path)
+		if err != nil || fp == e.fingerprint {
+			delete(pending, id)
+			continue
+		}
+		if pending[id] != fp {
+			pending[id] = fp
+			continue
+		}
+		delete(pending, id)
+		logger.Printf("Dapp '%s' has changed, reloading\n", id)
+		if err := r.load(id); err != nil {
+			logger.Println(err)
+		}
+	}
+}
+
+func (r *Registry) dappDir(dappId string) (string, error) {
+	if !idRegexp.MatchString(dappId) {
+		return "", fmt.Errorf("Invalid dapp id: '%s'", dappId)
+	}
+	return filepath.Join(r.fileIO.Dapps(), dappId), nil
+}
+
+// Must be called with the mutex held.
+func (r *Registry) load(dappId string) error {
+	dir, err := r.dappDir(dappId)
+	if err != nil {
+		return err
+	}
+	// Taken before reading, so changes made while loading are seen by the watcher.
+	var v *dappVersion
+	fp, err := fingerprint(dir)
+	if err == nil {
+		v, err = r.readVersion(dappId, dir)
+	}
+	if err == nil {
+		err = r.start(dappId, v)
+	}
+	e, ok := r.loaded[dappId]
+	if !ok {
+		e = &dappEntry{}
+		r.loaded[dappId] = e
+	}
+	e.fingerprint = fp
+	if err == nil {
+		if e.state == DappLoaded {
+			e.previous = e.current
+		}
+		e.current = v
+		e.state = DappLoaded
+		e.err = nil
+		if !e.registered && r.Server != nil {
+			r.Server.RegisterDapp(dappId)
+			e.registered = true
+		}
+		logger.Printf("Loaded dapp '%s' version %s\n", dappId, v.pf.Version)
+		return nil
+	}
+	if e.state != DappLoaded {
+		r.fail(dappId, e, err)
+		return err
+	}
+	re := &ReloadError{Id: dappId, Err: err}
+	if rerr := r.start(dappId, e.current); rerr != nil {
+		re.RollbackErr = rerr
+		r.fail(dappId, e, re)
+	} else {
+		re.RolledBackTo = e.current.pf.Version
+	}
+	return re
+}
+
+// Validate the dapp in dir and read its models.
+func (r *Registry) readVersion(dappId, dir string) (*dappVersion, error) {
+	pf, err := Validate(dir)
+	if err != nil {
+		return nil, err
+	}
+	if pf.Id != dappId {
+		return nil, fmt.Errorf("The package file in '%s' has id '%s'", dir, pf.Id)
+	}
+	if r.CheckDependencies != nil {
+		if err := r.CheckDependencies(pf); err != nil {
+			return nil, err
+		}
+	}
+	modelsDir := filepath.Join(dir, MODELS_FOLDER_NAME)
+	b, err := ioutil.ReadFile(filepath.Join(modelsDir, LOADING_ORDER_FILE_NAME))
+	if err != nil {
+		return nil, err
+	}
+	lo := &LoadOrderConfig{}
+	if err := json.Unmarshal(b, lo); err != nil {
+		return nil, err
+	}
+	v := &dappVersion{path: dir, pf: pf, models: make(map[string]string)}
+	for _, m := range lo.LoadingOrder {
+		m = filepath.Clean(filepath.FromSlash(m))
+		b, err := ioutil.ReadFile(filepath.Join(modelsDir, m))
+		if err != nil {
+			return nil, err
+		}
+		m = filepath.ToSlash(m)
+		v.order = append(v.order, m)
+		v.models[m] = string(b)
+	}
+	return v, nil
+}
+
+// Replace the runtime of the dapp with a new one that runs the models of v.
+func (r *Registry) start(dappId string, v *dappVersion) error {
+	r.rm.RemoveRuntime(dappId)
+	var rt core.Runtime
+	if qrm, ok := r.rm.(QuotaRuntimeManager); ok {
+		rt = qrm.CreateRuntimeWithQuotas(dappId, v.pf.Quotas)
+	} else {
+		rt = r.rm.CreateRuntime(dappId)
+	}
+	for _, m := range v.order {
+		if err := rt.AddScript(v.models[m]); err != nil {
+			r.rm.RemoveRuntime(dappId)
+			return fmt.Errorf("Error in model '%s': %s", m, err)
+		}
+	}
+	return nil
+}
+
+// A hash of the names, sizes and modification times of the files in dir.
+func fingerprint(dir string) (string, error) {
+	h := sha256.New()
+	err := filepath.Walk(dir, func(fpath string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, fpath)
+		fmt.Fprintf(h, "%s %d %d %v\n", rel, fi.Size(), fi.ModTime().UnixNano(), fi.IsDir())
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,425 @@
+package dapps
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eris-ltd/decerver-interfaces/core"
+)
+
+// Records the scripts it runs. Scripts that contain 'throw' fail.
+type fakeRuntime struct {
+	mutex   *sync.Mutex
+	scripts []string
+	down    bool
+}
+
+func (fr *fakeRuntime) Shutdown() {
+	fr.mutex.Lock()
+	defer fr.mutex.Unlock()
+	fr.down = true
+}
+func (fr *fakeRuntime) BindScriptObject(name string, val interface{}) error { return nil }
+func (fr *fakeRuntime) LoadScriptFile(fileName string) error                { return nil }
+func (fr *fakeRuntime) LoadScriptFiles(fileName ...string) error            { return nil }
+func (fr *fakeRuntime) AddScript(script string) error {
+	fr.mutex.Lock()
+	defer fr.mutex.Unlock()
+	if strings.Contains(script, "throw") {
+		return fmt.Errorf("Script threw")
+	}
+	fr.scripts = append(fr.scripts, script)
+	return nil
+}
+func (fr *fakeRuntime) CallFunc(funcName string, param ...interface{}) (interface{}, error) {
+	return nil, nil
+}
+func (fr *fakeRuntime) CallFuncOnObj(objName, funcName string, param ...interface{}) (interface{}, error) {
+	return nil, nil
+}
+func (fr *fakeRuntime) CallFuncContext(ctx context.Context, funcName string, param ...interface{}) (interface{}, error) {
+	return nil, nil
+}
+func (fr *fakeRuntime) CallFuncOnObjContext(ctx context.Context, objName, funcName string, param ...interface{}) (interface{}, error) {
+	return nil, nil
+}
+func (fr *fakeRuntime) SetTimeout(timeout time.Duration) {}
+func (fr *fakeRuntime) Timeout() time.Duration           { return 0 }
+
+func (fr *fakeRuntime) Scripts() string {
+	fr.mutex.Lock()
+	defer fr.mutex.Unlock()
+	return strings.Join(fr.scripts, ",")
+}
+
+type fakeRuntimeManager struct {
+	mutex    *sync.Mutex
+	runtimes map[string]*fakeRuntime
+	quotas   map[string]*Quotas
+}
+
+func newFakeRuntimeManager() *fakeRuntimeManager {
+	return &fakeRuntimeManager{
+		mutex:    &sync.Mutex{},
+		runtimes: make(map[string]*fakeRuntime),
+		quotas:   make(map[string]*Quotas),
+	}
+}
+
+func (rm *fakeRuntimeManager) GetRuntime(name string) core.Runtime {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+	if rt, ok := rm.runtimes[name]; ok {
+		return rt
+	}
+	return nil
+}
+func (rm *fakeRuntimeManager) CreateRuntime(name string) core.Runtime {
+	return rm.CreateRuntimeWithQuotas(name, nil)
+}
+func (rm *fakeRuntimeManager) CreateRuntimeWithQuotas(name string, quotas *Quotas) core.Runtime {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+	rt := &fakeRuntime{mutex: &sync.Mutex{}}
+	rm.runtimes[name] = rt
+	rm.quotas[name] = quotas
+	return rt
+}
+func (rm *fakeRuntimeManager) RemoveRuntime(name string) {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+	if rt, ok := rm.runtimes[name]; ok {
+		rt.Shutdown()
+		delete(rm.runtimes, name)
+	}
+}
+func (rm *fakeRuntimeManager) RegisterApiObject(name string, obj interface{}) {}
+func (rm *fakeRuntimeManager) RegisterApiScript(script string)                {}
+
+func (rm *fakeRuntimeManager) runtime(name string) *fakeRuntime {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+	return rm.runtimes[name]
+}
+
+const versionedPackage = `{"name": "Test dapp", "id": "testdapp", "version": "%s", "quotas": {"max_subscriptions": 2}}`
+
+// Write a version of testdapp into the dapps directory. The models are a.js and b.js.
+func writeVersion(t *testing.T, fileIO core.FileIO, version, a string) {
+	writeVersionTo(t, filepath.Join(fileIO.Dapps(), "testdapp"), version, a)
+}
+
+func writeVersionTo(t *testing.T, dir, version, a string) {
+	files := map[string]string{
+		PACKAGE_FILE_NAME: fmt.Sprintf(versionedPackage, version),
+		INDEX_FILE_NAME:   "<html>" + version + "</html>",
+		filepath.Join(MODELS_FOLDER_NAME, LOADING_ORDER_FILE_NAME): `{"loading_order": ["a.js", "b.js"]}`,
+		filepath.Join(MODELS_FOLDER_NAME, "a.js"):                  a,
+		filepath.Join(MODELS_FOLDER_NAME, "b.js"):                  "b" + version,
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readIndex(t *testing.T, fileIO core.FileIO) string {
+	b, err := ioutil.ReadFile(filepath.Join(fileIO.Dapps(), "testdapp", INDEX_FILE_NAME))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestRegistryLoadReloadRollback(t *testing.T) {
+	fileIO := tempFileIO(t)
+	defer os.RemoveAll(fileIO.Root())
+	rm := newFakeRuntimeManager()
+	reg := NewRegistry(fileIO, rm)
+	var _ DappRegistry = reg
+
+	writeVersion(t, fileIO, "1.0.0", "a1")
+	if err := reg.LoadDapp("testdapp"); err != nil {
+		t.Fatal(err)
+	}
+	rt1 := rm.runtime("testdapp")
+	if rt1.Scripts() != "a1,b1.0.0" {
+		t.Errorf("Models not run in loading order: %s", rt1.Scripts())
+	}
+	if rm.quotas["testdapp"] == nil || rm.quotas["testdapp"].MaxSubscriptions != 2 {
+		t.Error("Expected the quotas from the package file")
+	}
+	if list := reg.GetDappList(); len(list) != 1 || list[0].Version != "1.0.0" {
+		t.Errorf("Wrong dapp list: %v", list)
+	}
+
+	// Upgrade.
+	writeVersion(t, fileIO, "2.0.0", "a2")
+	if err := reg.ReloadDapp("testdapp"); err != nil {
+		t.Fatal(err)
+	}
+	rt2 := rm.runtime("testdapp")
+	if !rt1.down || rt2.Scripts() != "a2,b2.0.0" {
+		t.Errorf("Expected a new runtime with the new models, got: %s", rt2.Scripts())
+	}
+
+	// A broken upgrade is rolled back.
+	writeVersion(t, fileIO, "3.0.0", "throw")
+	err := reg.ReloadDapp("testdapp")
+	re, ok := err.(*ReloadError)
+	if !ok || re.RolledBackTo != "2.0.0" {
+		t.Fatalf("Expected a rollback to 2.0.0, got: %v", err)
+	}
+	if rt := rm.runtime("testdapp"); rt.Scripts() != "a2,b2.0.0" {
+		t.Errorf("Expected the 2.0.0 models, got: %s", rt.Scripts())
+	}
+	// The broken files are left for the developer to fix.
+	if readIndex(t, fileIO) != "<html>3.0.0</html>" {
+		t.Errorf("Expected the 3.0.0 files to be kept, got: %s", readIndex(t, fileIO))
+	}
+	if v := reg.GetDapp("testdapp").GetPackageFile().Version; v != "2.0.0" {
+		t.Errorf("Expected version 2.0.0 to be running, got %s", v)
+	}
+
+	// Explicit rollback to the version before.
+	if err := reg.Rollback("testdapp"); err != nil {
+		t.Fatal(err)
+	}
+	if v := reg.GetDapp("testdapp").GetPackageFile().Version; v != "1.0.0" {
+		t.Errorf("Expected version 1.0.0, got %s", v)
+	}
+	if rm.runtime("testdapp").Scripts() != "a1,b1.0.0" {
+		t.Error("Expected the 1.0.0 models")
+	}
+	if readIndex(t, fileIO) != "<html>3.0.0</html>" {
+		t.Error("Expected the files to be left alone")
+	}
+	fis, _ := ioutil.ReadDir(fileIO.Dapps())
+	if len(fis) != 1 {
+		t.Errorf("Expected only the dapp directory, found %d entries", len(fis))
+	}
+}
+
+func TestRegistryLoadFailures(t *testing.T) {
+	fileIO := tempFileIO(t)
+	defer os.RemoveAll(fileIO.Root())
+	rm := newFakeRuntimeManager()
+	reg := NewRegistry(fileIO, rm)
+	reg.CheckDependencies = func(pf *PackageFile) error {
+		return fmt.Errorf("Missing module")
+	}
+	writeVersion(t, fileIO, "1.0.0", "a1")
+	if err := reg.LoadDapp("testdapp"); err == nil || err.Error() != "Missing module" {
+		t.Errorf("Expected the dependency error, got: %v", err)
+	}
+	if reg.GetDapp("testdapp") != nil || rm.runtime("testdapp") != nil {
+		t.Error("Expected nothing to be loaded")
+	}
+	if err := reg.LoadDapp("../x"); err == nil {
+		t.Error("Expected an error for an invalid id")
+	}
+}
+
+func TestRegistryWatch(t *testing.T) {
+	fileIO := tempFileIO(t)
+	defer os.RemoveAll(fileIO.Root())
+	rm := newFakeRuntimeManager()
+	reg := NewRegistry(fileIO, rm)
+	writeVersion(t, fileIO, "1.0.0", "a1")
+	if err := reg.LoadDapp("testdapp"); err != nil {
+		t.Fatal(err)
+	}
+	reg.Watch(10 * time.Millisecond)
+	defer reg.StopWatching()
+
+	writeVersion(t, fileIO, "1.0.1", "a1.1")
+	deadline := time.Now().Add(5 * time.Second)
+	for rm.runtime("testdapp").Scripts() != "a1.1,b1.0.1" {
+		if time.Now().After(deadline) {
+			t.Fatalf("Dapp was not reloaded: %s", rm.runtime("testdapp").Scripts())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+type fakeServer struct {
+	dapps map[string]bool
+}
+
+func (fs *fakeServer) RegisterDapp(dappId string)   { fs.dapps[dappId] = true }
+func (fs *fakeServer) UnregisterDapp(dappId string) { delete(fs.dapps, dappId) }
+
+// Pack and sign a version of testdapp, and install it.
+func installVersion(t *testing.T, fileIO core.FileIO, version, a string) error {
+	dir, err := ioutil.TempDir("", "dapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeVersionTo(t, dir, version, a)
+	bundle, err := Pack(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	_, err = Install(fileIO, bundle, Sign(bundle, priv), []ed25519.PublicKey{pub})
+	return err
+}
+
+func TestRegistryVersionsAndUnload(t *testing.T) {
+	fileIO := tempFileIO(t)
+	defer os.RemoveAll(fileIO.Root())
+	rm := newFakeRuntimeManager()
+	reg := NewRegistry(fileIO, rm)
+	server := &fakeServer{make(map[string]bool)}
+	reg.Server = server
+
+	if err := installVersion(t, fileIO, "1.0.0", "a1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.LoadDapp("testdapp"); err != nil {
+		t.Fatal(err)
+	}
+	if !server.dapps["testdapp"] {
+		t.Error("Expected the dapp to be registered with the server")
+	}
+	if err := installVersion(t, fileIO, "2.0.0", "a2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := installVersion(t, fileIO, "2.0.0", "a2"); err == nil {
+		t.Error("Expected an error installing a version twice")
+	}
+	if err := installVersion(t, fileIO, "10.0.0", "throw"); err != nil {
+		t.Fatal(err)
+	}
+	st, err := reg.DappStatus("testdapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.State != DappLoaded || st.ActiveVersion != "1.0.0" || strings.Join(st.Versions, ",") != "1.0.0,2.0.0,10.0.0" {
+		t.Errorf("Wrong status: %v", st)
+	}
+
+	// Installing did not touch the running version; activating does.
+	if rm.runtime("testdapp").Scripts() != "a1,b1.0.0" {
+		t.Errorf("Expected version 1.0.0 to be running, got: %s", rm.runtime("testdapp").Scripts())
+	}
+	if err := reg.SetActiveVersion("testdapp", "2.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	if rm.runtime("testdapp").Scripts() != "a2,b2.0.0" || readIndex(t, fileIO) != "<html>2.0.0</html>" {
+		t.Error("Expected version 2.0.0 to be running")
+	}
+
+	// Versions are checked before they are used in a path.
+	for _, v := range []string{"../../b", "1.0.0/../../x", "..", "1.0.0-a..b"} {
+		if err := reg.SetActiveVersion("testdapp", v); err == nil || err.Error() != "Invalid version: '"+v+"'" {
+			t.Errorf("Expected '%s' to be rejected, got: %v", v, err)
+		}
+	}
+
+	// A version that fails to load is not activated.
+	err = reg.SetActiveVersion("testdapp", "10.0.0")
+	if re, ok := err.(*ReloadError); !ok || re.RolledBackTo != "2.0.0" {
+		t.Fatalf("Expected a rollback to 2.0.0, got: %v", err)
+	}
+	st, _ = reg.DappStatus("testdapp")
+	if st.ActiveVersion != "2.0.0" || st.State != DappLoaded || len(st.Versions) != 3 {
+		t.Errorf("Wrong status: %v", st)
+	}
+	if rm.runtime("testdapp").Scripts() != "a2,b2.0.0" {
+		t.Error("Expected version 2.0.0 to be running")
+	}
+
+	rt := rm.runtime("testdapp")
+	if err := reg.UnloadDapp("testdapp"); err != nil {
+		t.Fatal(err)
+	}
+	if !rt.down || rm.runtime("testdapp") != nil || server.dapps["testdapp"] || reg.GetDapp("testdapp") != nil {
+		t.Error("Expected the runtime and routes to be released")
+	}
+	if st, _ := reg.DappStatus("testdapp"); st.State != DappStopped {
+		t.Errorf("Expected the dapp to be stopped, got: %s", st.State)
+	}
+	if err := reg.UnloadDapp("testdapp"); err == nil {
+		t.Error("Expected an error unloading twice")
+	}
+
+	// An inactive version can be activated while the dapp is stopped.
+	if err := reg.SetActiveVersion("testdapp", "1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	if readIndex(t, fileIO) != "<html>1.0.0</html>" {
+		t.Error("Expected the 1.0.0 files")
+	}
+}
+
+func TestRegistryFailedStatus(t *testing.T) {
+	fileIO := tempFileIO(t)
+	defer os.RemoveAll(fileIO.Root())
+	rm := newFakeRuntimeManager()
+	reg := NewRegistry(fileIO, rm)
+	writeVersion(t, fileIO, "1.0.0", "throw")
+	if err := reg.LoadDapp("testdapp"); err == nil {
+		t.Fatal("Expected an error")
+	}
+	st, err := reg.DappStatus("testdapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.State != DappFailed || !strings.Contains(st.Error, "Error in model 'a.js'") {
+		t.Errorf("Wrong status: %v", st)
+	}
+	if _, err := reg.DappStatus("nodapp"); err == nil {
+		t.Error("Expected an error for a dapp that is not installed")
+	}
+	if _, err := reg.DappStatus("../testdapp"); err == nil || err.Error() != "Invalid dapp id: '../testdapp'" {
+		t.Errorf("Expected an invalid id, got: %v", err)
+	}
+	if _, err := ActiveVersion(fileIO, "../dapps/testdapp"); err == nil || err.Error() != "Invalid dapp id: '../dapps/testdapp'" {
+		t.Errorf("Expected an invalid id, got: %v", err)
+	}
+}
+
+// A loaded dapp whose directory is deleted is still reported as loaded.
+func TestRegistryMissingDirStatus(t *testing.T) {
+	fileIO := tempFileIO(t)
+	defer os.RemoveAll(fileIO.Root())
+	rm := newFakeRuntimeManager()
+	reg := NewRegistry(fileIO, rm)
+	writeVersion(t, fileIO, "1.0.0", "a1")
+	if err := reg.LoadDapp("testdapp"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Join(fileIO.Dapps(), "testdapp")); err != nil {
+		t.Fatal(err)
+	}
+	st, err := reg.DappStatus("testdapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.State != DappLoaded || st.ActiveVersion != "1.0.0" || strings.Join(st.Versions, ",") != "1.0.0" {
+		t.Errorf("Wrong status: %v", st)
+	}
+	if !strings.Contains(st.Detail, "missing") {
+		t.Errorf("Expected the missing directory in the detail, got: '%s'", st.Detail)
+	}
+
+	if err := reg.UnloadDapp("testdapp"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reg.DappStatus("testdapp"); err == nil {
+		t.Error("Expected an error once the dapp is unloaded")
+	}
+}
@@ -0,0 +1,228 @@
+package dapps
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+var (
+	// Ids are directory names. They can not start with '.', so they are never
+	// hidden, and never '.', '..' or VERSIONS_DIR_NAME.
+	idRegexp      = regexp.MustCompile(`^[a-zA-Z0-9_-][a-zA-Z0-9_.-]*$`)
+	versionRegexp = regexp.MustCompile(`^v?\d+\.\d+\.\d+(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`)
+)
+
+// Dependency data that can check itself after it has been unmarshalled.
+type DataValidator interface {
+	Validate() error
+}
+
+var (
+	schemaMutex = &sync.Mutex{}
+	// Module name -> constructor for the dependency data of that module.
+	dataSchemas = make(map[string]func() interface{})
+)
+
+func init() {
+	RegisterDataSchema("monk", func() interface{} { return &MonkData{} })
+}
+
+// Register the type of the Data a dapp must give when it depends on the module.
+// newData returns a pointer to a new value of that type. The data is checked by
+// unmarshalling it into that value (unknown fields are errors), and then by calling
+// Validate if the value is a DataValidator. Data for modules without a schema is
+// not checked.
+func RegisterDataSchema(module string, newData func() interface{}) {
+	schemaMutex.Lock()
+	defer schemaMutex.Unlock()
+	dataSchemas[module] = newData
+}
+
+func UnregisterDataSchema(module string) {
+	schemaMutex.Lock()
+	defer schemaMutex.Unlock()
+	delete(dataSchemas, module)
+}
+
+// Checks the data of a module dependency against the schema of the module.
+func ValidateDependencyData(dep *ModuleDependency) error {
+	schemaMutex.Lock()
+	newData, ok := dataSchemas[dep.Name]
+	schemaMutex.Unlock()
+	if !ok {
+		return nil
+	}
+	data := []byte(dep.Data)
+	if len(bytes.TrimSpace(data)) == 0 || string(bytes.TrimSpace(data)) == "null" {
+		data = []byte("{}")
+	}
+	v := newData()
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dv, ok := v.(DataValidator); ok {
+		return dv.Validate()
+	}
+	return nil
+}
+
+func (md *MonkData) Validate() error {
+	if md.ChainId == "" {
+		return fmt.Errorf("Missing blockchain_id")
+	}
+	if md.RootContract != "" {
+		if _, err := hex.DecodeString(strings.TrimPrefix(md.RootContract, "0x")); err != nil {
+			return fmt.Errorf("root_contract is not hex: %s", md.RootContract)
+		}
+	}
+	return nil
+}
+
+// ValidationError has every problem found in a dapp.
+type ValidationError struct {
+	Dir      string
+	Problems []string
+}
+
+func (ve *ValidationError) Error() string {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "Dapp in '%s' is not valid:", ve.Dir)
+	for _, p := range ve.Problems {
+		fmt.Fprintf(buf, "\n  %s", p)
+	}
+	return buf.String()
+}
+
+// Validate checks the dapp in the given directory: the package file, the index
+// file, the models folder with its loading order file, the model files in the
+// loading order and the data of the module dependencies. It returns the package
+// file (if it could be read) and a *ValidationError with all problems found.
+func Validate(dir string) (*PackageFile, error) {
+	ve := &ValidationError{Dir: dir}
+	problem := func(format string, args ...interface{}) {
+		ve.Problems = append(ve.Problems, fmt.Sprintf(format, args...))
+	}
+
+	pf, err := readPackageFile(dir)
+	if err != nil {
+		problem("%s", err)
+	} else {
+		for _, p := range ValidatePackageFile(pf) {
+			problem("%s: %s", PACKAGE_FILE_NAME, p)
+		}
+	}
+
+	if fi, err := os.Stat(path.Join(dir, INDEX_FILE_NAME)); err != nil {
+		problem("Missing %s", INDEX_FILE_NAME)
+	} else if fi.IsDir() {
+		problem("%s is a directory", INDEX_FILE_NAME)
+	}
+
+	modelsDir := path.Join(dir, MODELS_FOLDER_NAME)
+	if fi, err := os.Stat(modelsDir); err != nil || !fi.IsDir() {
+		problem("Missing %s folder", MODELS_FOLDER_NAME)
+	} else {
+		for _, p := range validateLoadingOrder(modelsDir) {
+			problem("%s: %s", path.Join(MODELS_FOLDER_NAME, LOADING_ORDER_FILE_NAME), p)
+		}
+	}
+
+	if len(ve.Problems) > 0 {
+		return pf, ve
+	}
+	return pf, nil
+}
+
+func readPackageFile(dir string) (*PackageFile, error) {
+	b, err := ioutil.ReadFile(path.Join(dir, PACKAGE_FILE_NAME))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("Missing %s", PACKAGE_FILE_NAME)
+		}
+		return nil, fmt.Errorf("Can not read %s: %s", PACKAGE_FILE_NAME, err)
+	}
+	pf, err := NewPackageFileFromJson(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not valid json: %s", PACKAGE_FILE_NAME, err)
+	}
+	return pf, nil
+}
+
+// ValidatePackageFile checks the fields of a package file, and returns the problems.
+func ValidatePackageFile(pf *PackageFile) []string {
+	problems := make([]string, 0)
+	if pf.Name == "" {
+		problems = append(problems, "Missing name")
+	}
+	if pf.Id == "" {
+		problems = append(problems, "Missing id")
+	} else if !idRegexp.MatchString(pf.Id) {
+		problems = append(problems, fmt.Sprintf("Invalid id '%s': only letters, digits, '.', '-' and '_' are allowed, and it can not start with '.'", pf.Id))
+	}
+	if pf.Version == "" {
+		problems = append(problems, "Missing version")
+	} else if !versionRegexp.MatchString(pf.Version) {
+		problems = append(problems, fmt.Sprintf("Invalid version '%s': must be major.minor.patch", pf.Version))
+	}
+	seen := make(map[string]bool)
+	for i, dep := range pf.ModuleDependencies {
+		if dep == nil || dep.Name == "" {
+			problems = append(problems, fmt.Sprintf("Module dependency %d has no name", i))
+			continue
+		}
+		if seen[dep.Name] {
+			problems = append(problems, fmt.Sprintf("Module '%s' is listed more than once", dep.Name))
+		}
+		seen[dep.Name] = true
+		if err := ValidateDependencyData(dep); err != nil {
+			problems = append(problems, fmt.Sprintf("Invalid data for module '%s': %s", dep.Name, err))
+		}
+	}
+	return problems
+}
+
+func validateLoadingOrder(modelsDir string) []string {
+	b, err := ioutil.ReadFile(path.Join(modelsDir, LOADING_ORDER_FILE_NAME))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{"Missing"}
+		}
+		return []string{err.Error()}
+	}
+	lo := &LoadOrderConfig{}
+	if err := json.Unmarshal(b, lo); err != nil {
+		return []string{fmt.Sprintf("Not valid json: %s", err)}
+	}
+	problems := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, name := range lo.LoadingOrder {
+		clean := filepath.Clean(filepath.FromSlash(name))
+		if name == "" || filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+			problems = append(problems, fmt.Sprintf("Invalid file name '%s'", name))
+			continue
+		}
+		if seen[clean] {
+			problems = append(problems, fmt.Sprintf("'%s' is listed more than once", name))
+			continue
+		}
+		seen[clean] = true
+		fi, err := os.Stat(filepath.Join(modelsDir, clean))
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("Missing model file '%s'", name))
+		} else if fi.IsDir() {
+			problems = append(problems, fmt.Sprintf("'%s' is a directory", name))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,140 @@
+package dapps
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func writeDapp(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "dapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		p := path.Join(dir, name)
+		if err := os.MkdirAll(path.Dir(p), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+const goodPackage = `{
+	"name": "Test dapp",
+	"id": "testdapp",
+	"version": "0.1.0",
+	"module_dependencies": [
+		{"name": "monk", "version": "^0.8", "data": {"blockchain_id": "abc", "root_contract": "0x1f"}},
+		{"name": "ipfs", "version": "*"}
+	]
+}`
+
+func TestValidateGood(t *testing.T) {
+	dir := writeDapp(t, map[string]string{
+		PACKAGE_FILE_NAME: goodPackage,
+		INDEX_FILE_NAME:   "<html></html>",
+		path.Join(MODELS_FOLDER_NAME, LOADING_ORDER_FILE_NAME): `{"loading_order": ["a.js", "lib/b.js"]}`,
+		path.Join(MODELS_FOLDER_NAME, "a.js"):                  "",
+		path.Join(MODELS_FOLDER_NAME, "lib", "b.js"):           "",
+	})
+	defer os.RemoveAll(dir)
+	pf, err := Validate(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pf.Id != "testdapp" || string(pf.ModuleDependencies[0].Data) == "" {
+		t.Errorf("Package file not read: %v", pf)
+	}
+}
+
+func TestValidateProblems(t *testing.T) {
+	dir := writeDapp(t, map[string]string{
+		PACKAGE_FILE_NAME: `{
+			"name": "Bad dapp",
+			"id": "../bad",
+			"version": "1.0",
+			"module_dependencies": [
+				{"name": "monk", "data": {"blockchain_id": "abc", "root_contract": "xyz"}},
+				{"name": "monk"},
+				{"version": "1.0.0"}
+			]
+		}`,
+		path.Join(MODELS_FOLDER_NAME, LOADING_ORDER_FILE_NAME): `{"loading_order": ["a.js", "missing.js", "../escape.js", "a.js"]}`,
+		path.Join(MODELS_FOLDER_NAME, "a.js"):                  "",
+	})
+	defer os.RemoveAll(dir)
+	_, err := Validate(dir)
+	ve, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("Expected a *ValidationError, got: %v", err)
+	}
+	expected := []string{
+		"Invalid id '../bad'",
+		"Invalid version '1.0'",
+		"Invalid data for module 'monk': root_contract is not hex",
+		"Invalid data for module 'monk': Missing blockchain_id",
+		"Module 'monk' is listed more than once",
+		"Module dependency 2 has no name",
+		"Missing " + INDEX_FILE_NAME,
+		"Missing model file 'missing.js'",
+		"Invalid file name '../escape.js'",
+		"'a.js' is listed more than once",
+	}
+	if len(ve.Problems) != len(expected) {
+		t.Errorf("Expected %d problems, got %d:\n%s", len(expected), len(ve.Problems), ve)
+	}
+	for _, s := range expected {
+		if !strings.Contains(ve.Error(), s) {
+			t.Errorf("Expected '%s' in:\n%s", s, ve)
+		}
+	}
+}
+
+func TestValidateIds(t *testing.T) {
+	for _, id := range []string{".versions", ".hidden", ".", "..", "a/b", ""} {
+		if idRegexp.MatchString(id) {
+			t.Errorf("Expected id '%s' to be invalid", id)
+		}
+	}
+	for _, id := range []string{"dapp", "my.dapp", "_x", "-y", "v1.0"} {
+		if !idRegexp.MatchString(id) {
+			t.Errorf("Expected id '%s' to be valid", id)
+		}
+	}
+}
+
+func TestValidateMissingFiles(t *testing.T) {
+	dir := writeDapp(t, map[string]string{INDEX_FILE_NAME: ""})
+	defer os.RemoveAll(dir)
+	pf, err := Validate(dir)
+	if pf != nil {
+		t.Errorf("Expected no package file")
+	}
+	ve, ok := err.(*ValidationError)
+	if !ok || len(ve.Problems) != 2 {
+		t.Fatalf("Expected 2 problems, got: %v", err)
+	}
+}
+
+func TestDependencyDataSchema(t *testing.T) {
+	type testData struct {
+		Url string `json:"url"`
+	}
+	RegisterDataSchema("test", func() interface{} { return &testData{} })
+	defer UnregisterDataSchema("test")
+	if err := ValidateDependencyData(&ModuleDependency{Name: "test", Data: []byte(`{"url": "x"}`)}); err != nil {
+		t.Error(err)
+	}
+	if err := ValidateDependencyData(&ModuleDependency{Name: "test", Data: []byte(`{"uri": "x"}`)}); err == nil {
+		t.Error("Expected an error for an unknown field")
+	}
+	if err := ValidateDependencyData(&ModuleDependency{Name: "other", Data: []byte(`{"uri": "x"}`)}); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,133 @@
+package dapps
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/eris-ltd/decerver-interfaces/core"
+)
+
+// The active version of a dapp is in <dapps>/<id>. Other installed versions are
+// kept in <dapps>/VERSIONS_DIR_NAME/<id>/<version>.
+const VERSIONS_DIR_NAME = ".versions"
+
+// The directory of a version in the version store. The id and version are checked,
+// so they can not point outside of it.
+func versionDir(fileIO core.FileIO, dappId, version string) (string, error) {
+	if !idRegexp.MatchString(dappId) {
+		return "", fmt.Errorf("Invalid dapp id: '%s'", dappId)
+	}
+	if !versionRegexp.MatchString(version) || strings.ContainsAny(version, `/\`) || strings.Contains(version, "..") {
+		return "", fmt.Errorf("Invalid version: '%s'", version)
+	}
+	return filepath.Join(fileIO.Dapps(), VERSIONS_DIR_NAME, dappId, version), nil
+}
+
+// The active version of an installed dapp.
+func ActiveVersion(fileIO core.FileIO, dappId string) (string, error) {
+	if !idRegexp.MatchString(dappId) {
+		return "", fmt.Errorf("Invalid dapp id: '%s'", dappId)
+	}
+	pf, err := readPackageFile(filepath.Join(fileIO.Dapps(), dappId))
+	if err != nil {
+		return "", fmt.Errorf("Dapp '%s' is not installed", dappId)
+	}
+	return pf.Version, nil
+}
+
+// All installed versions of a dapp, the active one included, from low to high.
+func InstalledVersions(fileIO core.FileIO, dappId string) ([]string, error) {
+	active, err := ActiveVersion(fileIO, dappId)
+	if err != nil {
+		return nil, err
+	}
+	versions := []string{active}
+	fis, _ := ioutil.ReadDir(filepath.Join(fileIO.Dapps(), VERSIONS_DIR_NAME, dappId))
+	for _, fi := range fis {
+		if fi.IsDir() {
+			versions = append(versions, fi.Name())
+		}
+	}
+	sort.Sort(byVersion(versions))
+	return versions, nil
+}
+
+// Make an installed version the active one. The version that was active is moved
+// to the version store. Returns the version that was active.
+func activate(fileIO core.FileIO, dappId, version string) (string, error) {
+	active, err := ActiveVersion(fileIO, dappId)
+	if err != nil {
+		return "", err
+	}
+	if active == version {
+		return active, nil
+	}
+	src, err := versionDir(fileIO, dappId, version)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(src); err != nil {
+		return "", fmt.Errorf("Version %s of dapp '%s' is not installed", version, dappId)
+	}
+	dst, err := versionDir(fileIO, dappId, active)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(dst); err == nil {
+		return "", fmt.Errorf("Version %s of dapp '%s' is installed twice", active, dappId)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return "", err
+	}
+	dir := filepath.Join(fileIO.Dapps(), dappId)
+	if err := os.Rename(dir, dst); err != nil {
+		return "", err
+	}
+	if err := os.Rename(src, dir); err != nil {
+		os.Rename(dst, dir)
+		return "", err
+	}
+	return active, nil
+}
+
+// Sorts versions by major, minor and patch number. Pre-releases come before the
+// release, and are compared as strings.
+type byVersion []string
+
+func (vs byVersion) Len() int      { return len(vs) }
+func (vs byVersion) Swap(i, j int) { vs[i], vs[j] = vs[j], vs[i] }
+func (vs byVersion) Less(i, j int) bool {
+	ni, pi := splitVersion(vs[i])
+	nj, pj := splitVersion(vs[j])
+	for k := range ni {
+		if ni[k] != nj[k] {
+			return ni[k] < nj[k]
+		}
+	}
+	if pi == "" || pj == "" {
+		return pi != "" && pj == ""
+	}
+	return pi < pj
+}
+
+func splitVersion(v string) ([3]int, string) {
+	var nums [3]int
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.Index(v, "+"); i >= 0 {
+		v = v[:i]
+	}
+	pre := ""
+	if i := strings.Index(v, "-"); i >= 0 {
+		pre = v[i+1:]
+		v = v[:i]
+	}
+	for i, s := range strings.SplitN(v, ".", 3) {
+		nums[i], _ = strconv.Atoi(s)
+	}
+	return nums, pre
+}
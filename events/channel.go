@@ -0,0 +1,216 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// What to do when an event is sent on a full channel.
+type OverflowPolicy int
+
+const (
+	// Remove the oldest buffered event to make room for the new one.
+	DropOldest OverflowPolicy = iota
+	// Discard the new event.
+	DropNewest
+	// Wait up to ChannelConfig.Timeout for room, then discard the new event.
+	BlockWithTimeout
+	// Close the channel. The subscriber is considered dead.
+	Disconnect
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case DropOldest:
+		return "drop-oldest"
+	case DropNewest:
+		return "drop-newest"
+	case BlockWithTimeout:
+		return "block"
+	case Disconnect:
+		return "disconnect"
+	}
+	return "unknown"
+}
+
+// Buffering for a subscription channel.
+type ChannelConfig struct {
+	BufferSize int            `json:"buffer_size"`
+	Policy     OverflowPolicy `json:"overflow_policy"`
+	// Only used with BlockWithTimeout.
+	Timeout time.Duration `json:"timeout"`
+}
+
+var DefaultChannelConfig = &ChannelConfig{
+	BufferSize: 100,
+	Policy:     DropOldest,
+	Timeout:    time.Second,
+}
+
+// Subscribers that want something other than the registry default can
+// implement this as well.
+type ConfigurableSubscriber interface {
+	Subscriber
+	ChannelConfig() *ChannelConfig
+}
+
+// EventChannel is a buffered event channel with an overflow policy. Senders
+// (registries, module pollers and reactors) use Send instead of writing to the
+// channel directly, so that a consumer that stops reading can never hang them.
+// The receiving end is the channel returned by Channel().
+type EventChannel struct {
+	config  ChannelConfig
+	ch      chan Event
+	mutex   *sync.Mutex
+	dropped uint64
+	closed  bool
+	// Closed together with the channel, to wake up senders in SendWait.
+	done    chan struct{}
+	waiting *sync.WaitGroup
+}
+
+// Create a new event channel. A nil config means DefaultChannelConfig.
+func NewEventChannel(config *ChannelConfig) *EventChannel {
+	if config == nil {
+		config = DefaultChannelConfig
+	}
+	ec := &EventChannel{}
+	ec.config = *config
+	if ec.config.BufferSize < 0 {
+		ec.config.BufferSize = 0
+	}
+	ec.ch = make(chan Event, ec.config.BufferSize)
+	ec.mutex = &sync.Mutex{}
+	ec.done = make(chan struct{})
+	ec.waiting = &sync.WaitGroup{}
+	return ec
+}
+
+func (ec *EventChannel) Channel() chan Event {
+	return ec.ch
+}
+
+// Send an event, applying the overflow policy if the channel is full.
+// Returns false if the event was not delivered to the channel.
+func (ec *EventChannel) Send(e Event) bool {
+	ec.mutex.Lock()
+	if ec.closed {
+		ec.dropped++
+		ec.mutex.Unlock()
+		return false
+	}
+	select {
+	case ec.ch <- e:
+		ec.mutex.Unlock()
+		return true
+	default:
+	}
+	if ec.config.Policy == BlockWithTimeout {
+		// Wait without the mutex, like SendWait, so Close and Dropped do not
+		// have to wait for the timeout.
+		ec.waiting.Add(1)
+		ec.mutex.Unlock()
+		return ec.sendTimeout(e)
+	}
+	defer ec.mutex.Unlock()
+	switch ec.config.Policy {
+	case DropOldest:
+		for {
+			select {
+			case <-ec.ch:
+				ec.dropped++
+			default:
+			}
+			select {
+			case ec.ch <- e:
+				return true
+			default:
+			}
+			// Unbuffered channel with no reader. Nothing to evict.
+			if ec.config.BufferSize == 0 {
+				ec.dropped++
+				return false
+			}
+		}
+	case Disconnect:
+		ec.closeLocked()
+	}
+	ec.dropped++
+	return false
+}
+
+// Waits up to the timeout for room. Called with 'waiting' incremented.
+func (ec *EventChannel) sendTimeout(e Event) bool {
+	timer := time.NewTimer(ec.config.Timeout)
+	defer timer.Stop()
+	sent := false
+	select {
+	case ec.ch <- e:
+		sent = true
+	case <-timer.C:
+	case <-ec.done:
+	}
+	ec.waiting.Done()
+	if !sent {
+		ec.mutex.Lock()
+		ec.dropped++
+		ec.mutex.Unlock()
+	}
+	return sent
+}
+
+// SendWait sends an event without applying the overflow policy. It blocks until
+// the event is read or the channel is closed. Used for replays, where losing events
+// would defeat the purpose. Returns false if the channel was closed.
+func (ec *EventChannel) SendWait(e Event) bool {
+	ec.mutex.Lock()
+	if ec.closed {
+		ec.mutex.Unlock()
+		return false
+	}
+	ec.waiting.Add(1)
+	ec.mutex.Unlock()
+	defer ec.waiting.Done()
+	select {
+	case ec.ch <- e:
+		return true
+	case <-ec.done:
+		return false
+	}
+}
+
+// Close the channel. Later sends are dropped.
+func (ec *EventChannel) Close() {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	ec.closeLocked()
+}
+
+// Senders in SendWait return as soon as 'done' is closed, so waiting for
+// them while holding the mutex is safe (they do not need it).
+func (ec *EventChannel) closeLocked() {
+	if ec.closed {
+		return
+	}
+	ec.closed = true
+	close(ec.done)
+	ec.waiting.Wait()
+	close(ec.ch)
+}
+
+func (ec *EventChannel) IsClosed() bool {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	return ec.closed
+}
+
+// The number of events that were not delivered.
+func (ec *EventChannel) Dropped() uint64 {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+	return ec.dropped
+}
+
+func (ec *EventChannel) Config() ChannelConfig {
+	return ec.config
+}
@@ -0,0 +1,128 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+func fill(ec *EventChannel, n int) {
+	for i := 0; i < n; i++ {
+		ec.Send(Event{Resource: i})
+	}
+}
+
+func TestDropOldest(t *testing.T) {
+	ec := NewEventChannel(&ChannelConfig{BufferSize: 3, Policy: DropOldest})
+	fill(ec, 5)
+	if n := ec.Dropped(); n != 2 {
+		t.Fatalf("Expected: 2 dropped, Got: %d", n)
+	}
+	for i := 2; i < 5; i++ {
+		if e := <-ec.Channel(); e.Resource.(int) != i {
+			t.Fatalf("Expected: %d, Got: %v", i, e.Resource)
+		}
+	}
+}
+
+func TestDropNewest(t *testing.T) {
+	ec := NewEventChannel(&ChannelConfig{BufferSize: 3, Policy: DropNewest})
+	fill(ec, 5)
+	if n := ec.Dropped(); n != 2 {
+		t.Fatalf("Expected: 2 dropped, Got: %d", n)
+	}
+	for i := 0; i < 3; i++ {
+		if e := <-ec.Channel(); e.Resource.(int) != i {
+			t.Fatalf("Expected: %d, Got: %v", i, e.Resource)
+		}
+	}
+}
+
+func TestBlockWithTimeout(t *testing.T) {
+	ec := NewEventChannel(&ChannelConfig{BufferSize: 1, Policy: BlockWithTimeout, Timeout: 20 * time.Millisecond})
+	fill(ec, 1)
+
+	start := time.Now()
+	if ec.Send(Event{}) {
+		t.Fatal("Expected send on a full channel to fail")
+	}
+	if time.Since(start) < 20*time.Millisecond {
+		t.Fatal("Send returned before the timeout")
+	}
+
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		<-ec.Channel()
+	}()
+	if !ec.Send(Event{}) {
+		t.Fatal("Expected send to succeed once the reader caught up")
+	}
+	if n := ec.Dropped(); n != 1 {
+		t.Fatalf("Expected: 1 dropped, Got: %d", n)
+	}
+}
+
+// A waiting sender does not hold up Dropped and Close.
+func TestBlockWithTimeoutClose(t *testing.T) {
+	ec := NewEventChannel(&ChannelConfig{BufferSize: 1, Policy: BlockWithTimeout, Timeout: time.Minute})
+	fill(ec, 1)
+	sent := make(chan bool)
+	go func() {
+		sent <- ec.Send(Event{})
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	start := time.Now()
+	ec.Dropped()
+	ec.Close()
+	if ok := <-sent; ok {
+		t.Fatal("Expected the send to fail on close")
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("Close waited for the sender's timeout")
+	}
+	if n := ec.Dropped(); n != 1 {
+		t.Fatalf("Expected: 1 dropped, Got: %d", n)
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	ec := NewEventChannel(&ChannelConfig{BufferSize: 2, Policy: Disconnect})
+	fill(ec, 3)
+	if !ec.IsClosed() {
+		t.Fatal("Expected channel to be closed")
+	}
+	n := 0
+	for _ = range ec.Channel() {
+		n++
+	}
+	if n != 2 {
+		t.Fatalf("Expected: 2 buffered events, Got: %d", n)
+	}
+	// Sending on a disconnected channel must not panic.
+	ec.Send(Event{})
+	if d := ec.Dropped(); d != 2 {
+		t.Fatalf("Expected: 2 dropped, Got: %d", d)
+	}
+}
+
+func TestRegistryDisconnect(t *testing.T) {
+	ep := NewEventProcessor()
+	defer ep.Shutdown()
+	slow := NewSubscriber("slow", "", "", "")
+	slow.SetChannelConfig(&ChannelConfig{BufferSize: 1, Policy: Disconnect})
+	fast := NewSubscriber("fast", "", "", "")
+	ep.Subscribe(slow)
+	ep.Subscribe(fast)
+
+	for i := 0; i < 3; i++ {
+		ep.Post(Event{Event: "newBlock"})
+		recv(t, fast.Channel())
+	}
+	if n := ep.SubscriberCount(); n != 1 {
+		t.Fatalf("Expected: 1 subscriber, Got: %d", n)
+	}
+	dropped := ep.Dropped()
+	if dropped["slow"] != 1 || dropped["fast"] != 0 {
+		t.Fatalf("Unexpected drop counts: %v", dropped)
+	}
+}
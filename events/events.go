@@ -22,6 +22,9 @@ type Event struct {
 	Resource  interface{}
 	Source    string
 	TimeStamp time.Time
+	// Assigned by the event journal (if any). Starts at 1 and increases by
+	// one for every journaled event, so it can be used to resume a feed.
+	Sequence uint64
 }
 
 // A subscriber listens to events.
@@ -29,7 +32,8 @@ type Subscriber interface {
 	// Events will be passed on this channel
 	SetChannel(chan Event)
 	Channel() chan Event
-	// The subscriber only listen to events published by this source
+	// The subscriber only listen to events published by this source.
+	// Source, Event and Target may be patterns (see match.go).
 	Source() string
 	// The subscriber Id (must be unique).
 	Id() string
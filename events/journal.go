@@ -0,0 +1,352 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/eris-ltd/decerver-interfaces/core"
+)
+
+const JOURNAL_FILE_NAME = "events.journal"
+
+// Only every JOURNAL_INDEX_INTERVAL-th entry has its offset kept in memory.
+// Reads start at the closest of those and scan forward.
+const JOURNAL_INDEX_INTERVAL = 256
+
+// Journal is an append-only event log. Every appended event gets the next
+// sequence number, so that a subscriber that was gone for a while (a dapp
+// reconnecting its websocket, for example) can ask for everything it missed.
+//
+// The file holds one json encoded event per line. Resources of registered
+// event kinds are decoded into their canonical type when replayed; other
+// resources come back as generic maps, slices and strings.
+//
+// By default every append is synced to disk before it returns, so an event
+// that was handed to subscribers is never lost. SetSyncOnAppend(false) trades
+// that for speed; the journal is then only synced by Sync and Close.
+type Journal struct {
+	mutex    *sync.Mutex
+	filename string
+	file     *os.File
+	// Sequence number and offset of some of the entries, in order.
+	index   []journalMark
+	last    uint64
+	size    int64
+	corrupt int
+	// The last sequence number handed out, and the last one that has been
+	// written (or given up on). Entries are written in order, so a writer
+	// waits on 'turn' until the ones before it are done.
+	reserved uint64
+	done     uint64
+	turn     *sync.Cond
+
+	syncOnAppend bool
+	fsync        func(*os.File) error
+}
+
+type journalMark struct {
+	seq    uint64
+	offset int64
+}
+
+// Open the journal in the decerver system directory.
+func NewJournal(fileIO core.FileIO) (*Journal, error) {
+	return OpenJournal(path.Join(fileIO.System(), JOURNAL_FILE_NAME))
+}
+
+// Open (or create) a journal file. If the last line was only partly written
+// (the process died mid-write) it is cut off. Lines in the middle that can not
+// be read are skipped, and counted in Corrupt.
+func OpenJournal(filename string) (*Journal, error) {
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	j := &Journal{}
+	j.mutex = &sync.Mutex{}
+	j.turn = sync.NewCond(j.mutex)
+	j.filename = filename
+	j.file = file
+	j.syncOnAppend = true
+	j.fsync = (*os.File).Sync
+	if err := j.load(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// Index the entries. Only a trailing partial line is truncated; a complete line
+// that can not be read is skipped, so that the entries after it are kept.
+func (j *Journal) load() error {
+	reader := bufio.NewReader(j.file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		e := &journalEntry{}
+		if err := json.Unmarshal(line, e); err != nil || e.Sequence == 0 {
+			logger.Printf("Skipping corrupt entry at offset %d of journal %s\n", offset, j.filename)
+			j.corrupt++
+		} else if e.Sequence <= j.last {
+			return fmt.Errorf("Journal %s is corrupt: sequence %d follows %d", j.filename, e.Sequence, j.last)
+		} else {
+			j.mark(e.Sequence, offset)
+		}
+		offset += int64(len(line))
+	}
+	if err := j.file.Truncate(offset); err != nil {
+		return err
+	}
+	j.size = offset
+	j.reserved = j.last
+	j.done = j.last
+	return nil
+}
+
+func (j *Journal) mark(seq uint64, offset int64) {
+	if len(j.index) == 0 || seq >= j.index[len(j.index)-1].seq+JOURNAL_INDEX_INTERVAL {
+		j.index = append(j.index, journalMark{seq, offset})
+	}
+	j.last = seq
+}
+
+// Whether every append is synced to disk before it returns. On by default.
+func (j *Journal) SetSyncOnAppend(sync bool) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.syncOnAppend = sync
+}
+
+// The number of corrupt entries that were skipped when the journal was opened.
+func (j *Journal) Corrupt() int {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.corrupt
+}
+
+// Append an event to the journal. The sequence number is set on the event. If
+// the resource can not be json encoded, the event is journaled without it.
+func (j *Journal) Append(e *Event) error {
+	if err := j.reserve(e); err != nil {
+		return err
+	}
+	return j.write(e)
+}
+
+// Set the next sequence number on the event. It must be passed to write
+// afterwards, or the writers after it wait forever.
+func (j *Journal) reserve(e *Event) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if j.file == nil {
+		return fmt.Errorf("Journal %s is closed", j.filename)
+	}
+	j.reserved++
+	e.Sequence = j.reserved
+	return nil
+}
+
+// Write an event that has a reserved sequence number, once the events reserved
+// before it are written. If that fails the sequence number is set to 0, and it
+// is either handed out again or skipped.
+func (j *Journal) write(e *Event) error {
+	seq := e.Sequence
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	for j.done+1 < seq {
+		j.turn.Wait()
+	}
+	defer j.turn.Broadcast()
+	if err := j.writeEntry(e); err != nil {
+		e.Sequence = 0
+		if j.reserved == seq {
+			j.reserved--
+		} else {
+			j.done = seq
+		}
+		return err
+	}
+	j.done = seq
+	return nil
+}
+
+// Must be called with the mutex held, by the writer whose turn it is. The mutex
+// is released while syncing, so that sequence numbers can be handed out and
+// the journal read in the meantime; the entry only becomes visible after that.
+func (j *Journal) writeEntry(e *Event) error {
+	if j.file == nil {
+		return fmt.Errorf("Journal %s is closed", j.filename)
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		stripped := *e
+		stripped.Resource = nil
+		if b, err = json.Marshal(&stripped); err != nil {
+			return err
+		}
+	}
+	b = append(b, '\n')
+	file, fsync := j.file, j.fsync
+	if _, err = file.WriteAt(b, j.size); err == nil && j.syncOnAppend {
+		j.mutex.Unlock()
+		err = fsync(file)
+		j.mutex.Lock()
+	}
+	if err != nil {
+		// Do not leave half an entry behind.
+		if j.file != nil {
+			j.file.Truncate(j.size)
+		}
+		return err
+	}
+	j.mark(e.Sequence, j.size)
+	j.size += int64(len(b))
+	return nil
+}
+
+// The last sequence number that has been handed out.
+func (j *Journal) reservedSequence() uint64 {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.reserved
+}
+
+// Wait until the entry after 'seq' has been written or given up on, or until
+// nothing after 'seq' is reserved. Returns the last sequence number that is
+// done: every journaled event up to it can be read.
+func (j *Journal) waitAfter(seq uint64) uint64 {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	for j.done <= seq && j.reserved > seq {
+		j.turn.Wait()
+	}
+	return j.done
+}
+
+// The sequence number of the last journaled event (0 if there are none).
+func (j *Journal) LastSequence() uint64 {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.last
+}
+
+// Since returns up to 'max' events with a sequence number greater than 'seq',
+// in order. If max <= 0 there is no limit.
+func (j *Journal) Since(seq uint64, max int) ([]Event, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if j.file == nil {
+		return nil, fmt.Errorf("Journal %s is closed", j.filename)
+	}
+	if seq >= j.last {
+		return []Event{}, nil
+	}
+	// Start at the last indexed entry that is not after the first one wanted.
+	i := sort.Search(len(j.index), func(i int) bool { return j.index[i].seq > seq+1 })
+	var start int64
+	if i > 0 {
+		start = j.index[i-1].offset
+	}
+	reader := bufio.NewReader(io.NewSectionReader(j.file, start, j.size-start))
+	evts := make([]Event, 0)
+	for max <= 0 || len(evts) < max {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		je := &journalEntry{}
+		if err := json.Unmarshal(line, je); err != nil || je.Sequence <= seq {
+			// Corrupt entries were counted when the journal was opened.
+			continue
+		}
+		e, err := je.event()
+		if err != nil {
+			return nil, err
+		}
+		evts = append(evts, e)
+	}
+	return evts, nil
+}
+
+// SequenceBefore returns the sequence number of the last event that happened
+// before 't', so that Since(SequenceBefore(t)) gives everything from 't' on.
+func (j *Journal) SequenceBefore(t time.Time) (uint64, error) {
+	var seq uint64
+	for {
+		evts, err := j.Since(seq, 1000)
+		if err != nil {
+			return 0, err
+		}
+		if len(evts) == 0 {
+			return seq, nil
+		}
+		for _, e := range evts {
+			if !e.TimeStamp.Before(t) {
+				return seq, nil
+			}
+			seq = e.Sequence
+		}
+	}
+}
+
+// Flush the journal to disk.
+func (j *Journal) Sync() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	return j.file.Sync()
+}
+
+func (j *Journal) Close() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+// An event as it is stored in the journal.
+type journalEntry struct {
+	Event     string
+	Target    string
+	Resource  json.RawMessage
+	Source    string
+	TimeStamp time.Time
+	Sequence  uint64
+}
+
+func (je *journalEntry) event() (Event, error) {
+	resource, err := DecodeResource(je.Event, je.Resource)
+	if err != nil {
+		return Event{}, err
+	}
+	return Event{
+		Event:     je.Event,
+		Target:    je.Target,
+		Resource:  resource,
+		Source:    je.Source,
+		TimeStamp: je.TimeStamp,
+		Sequence:  je.Sequence,
+	}, nil
+}
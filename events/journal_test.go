@@ -0,0 +1,295 @@
+package events
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func tempJournal(t *testing.T) (*Journal, string) {
+	dir, err := ioutil.TempDir("", "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := OpenJournal(path.Join(dir, JOURNAL_FILE_NAME))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return j, dir
+}
+
+func TestJournalAppend(t *testing.T) {
+	j, dir := tempJournal(t)
+	defer os.RemoveAll(dir)
+
+	for i := 1; i <= 10; i++ {
+		e := &Event{Event: "newBlock", Source: "eth", Resource: map[string]interface{}{"Number": i}}
+		if err := j.Append(e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Sequence != uint64(i) {
+			t.Fatalf("Expected sequence: %d, Got: %d", i, e.Sequence)
+		}
+	}
+	evts, err := j.Since(7, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evts) != 3 || evts[0].Sequence != 8 || evts[2].Sequence != 10 {
+		t.Fatalf("Unexpected events: %v", evts)
+	}
+	if n := evts[0].Resource.(map[string]interface{})["Number"]; n != float64(8) {
+		t.Fatalf("Expected resource number 8, Got: %v", n)
+	}
+	evts, _ = j.Since(0, 4)
+	if len(evts) != 4 || evts[3].Sequence != 4 {
+		t.Fatalf("Unexpected events: %v", evts)
+	}
+
+	// Reopen, with a torn write at the end.
+	j.Close()
+	f, _ := os.OpenFile(path.Join(dir, JOURNAL_FILE_NAME), os.O_WRONLY|os.O_APPEND, 0600)
+	f.Write([]byte(`{"Event":"newBl`))
+	f.Close()
+
+	j, err = OpenJournal(path.Join(dir, JOURNAL_FILE_NAME))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if seq := j.LastSequence(); seq != 10 {
+		t.Fatalf("Expected last sequence: 10, Got: %d", seq)
+	}
+	e := &Event{Event: "newBlock"}
+	j.Append(e)
+	if e.Sequence != 11 {
+		t.Fatalf("Expected sequence: 11, Got: %d", e.Sequence)
+	}
+	evts, _ = j.Since(10, 0)
+	if len(evts) != 1 {
+		t.Fatalf("Expected 1 event, Got: %d", len(evts))
+	}
+}
+
+func TestJournalCorruptEntry(t *testing.T) {
+	j, dir := tempJournal(t)
+	defer os.RemoveAll(dir)
+	for i := 0; i < 3; i++ {
+		j.Append(&Event{Event: "newBlock"})
+	}
+	j.Close()
+
+	// A damaged entry in the middle does not take the ones after it along.
+	filename := path.Join(dir, JOURNAL_FILE_NAME)
+	b, _ := ioutil.ReadFile(filename)
+	lines := strings.SplitAfter(string(b), "\n")
+	lines[1] = "garbage\n"
+	ioutil.WriteFile(filename, []byte(strings.Join(lines, "")), 0600)
+
+	j, err := OpenJournal(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if j.Corrupt() != 1 || j.LastSequence() != 3 {
+		t.Fatalf("Expected 1 corrupt entry and last sequence 3, Got: %d, %d", j.Corrupt(), j.LastSequence())
+	}
+	evts, err := j.Since(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evts) != 2 || evts[0].Sequence != 1 || evts[1].Sequence != 3 {
+		t.Fatalf("Unexpected events: %v", evts)
+	}
+	e := &Event{}
+	j.Append(e)
+	if e.Sequence != 4 {
+		t.Fatalf("Expected sequence: 4, Got: %d", e.Sequence)
+	}
+}
+
+func TestJournalSync(t *testing.T) {
+	j, dir := tempJournal(t)
+	defer os.RemoveAll(dir)
+	defer j.Close()
+	syncs := 0
+	j.fsync = func(f *os.File) error {
+		syncs++
+		return f.Sync()
+	}
+
+	j.Append(&Event{})
+	if syncs != 1 {
+		t.Fatalf("Expected the append to be synced, Got: %d syncs", syncs)
+	}
+	j.SetSyncOnAppend(false)
+	j.Append(&Event{})
+	if syncs != 1 {
+		t.Fatalf("Expected no sync, Got: %d syncs", syncs)
+	}
+
+	j.fsync = func(f *os.File) error {
+		return fmt.Errorf("Disk on fire")
+	}
+	j.SetSyncOnAppend(true)
+	e := &Event{}
+	if err := j.Append(e); err == nil || e.Sequence != 0 || j.LastSequence() != 2 {
+		t.Fatalf("Expected a failed append, Got: %v, %d", err, j.LastSequence())
+	}
+}
+
+// Reads past the indexed entries.
+func TestJournalIndex(t *testing.T) {
+	j, dir := tempJournal(t)
+	defer os.RemoveAll(dir)
+	defer j.Close()
+	j.SetSyncOnAppend(false)
+
+	n := 3*JOURNAL_INDEX_INTERVAL + 10
+	for i := 0; i < n; i++ {
+		j.Append(&Event{})
+	}
+	if len(j.index) != 4 {
+		t.Fatalf("Expected 4 indexed entries, Got: %d", len(j.index))
+	}
+	for _, seq := range []uint64{0, 255, 256, 300, 767, 768, uint64(n) - 1} {
+		evts, err := j.Since(seq, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(evts) == 0 || evts[0].Sequence != seq+1 {
+			t.Fatalf("Since(%d): unexpected events: %v", seq, evts)
+		}
+	}
+}
+
+func TestJournalSequenceBefore(t *testing.T) {
+	j, dir := tempJournal(t)
+	defer os.RemoveAll(dir)
+	defer j.Close()
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		j.Append(&Event{TimeStamp: start.Add(time.Duration(i) * time.Minute)})
+	}
+	seq, err := j.SequenceBefore(start.Add(2 * time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq != 2 {
+		t.Fatalf("Expected: 2, Got: %d", seq)
+	}
+}
+
+func TestSubscribeFrom(t *testing.T) {
+	j, dir := tempJournal(t)
+	defer os.RemoveAll(dir)
+	defer j.Close()
+	ReplayBatchSize = 3
+
+	ep := NewEventProcessor()
+	defer ep.Shutdown()
+	ep.SetJournal(j)
+
+	for i := 1; i <= 10; i++ {
+		ep.Post(Event{Event: "newBlock", Source: "eth", Resource: i})
+		ep.Post(Event{Event: "newTx", Source: "eth", Resource: i})
+	}
+
+	sub := NewSubscriber("dapp", "eth", "newBlock", "")
+	sub.SetChannelConfig(&ChannelConfig{BufferSize: 1, Policy: BlockWithTimeout, Timeout: time.Second})
+	// Blocks 1-4 are seq 1, 3, 5, 7.
+	if err := ep.SubscribeFrom(sub, 7); err != nil {
+		t.Fatal(err)
+	}
+	// Live events posted while replaying.
+	go func() {
+		for i := 11; i <= 15; i++ {
+			ep.Post(Event{Event: "newBlock", Source: "eth", Resource: i})
+		}
+	}()
+	for i := 5; i <= 15; i++ {
+		e := recv(t, sub.Channel())
+		n, ok := e.Resource.(int)
+		if !ok {
+			// Replayed from json.
+			n = int(e.Resource.(float64))
+		}
+		if n != i {
+			t.Fatalf("Expected block: %d, Got: %d", i, n)
+		}
+	}
+	noRecv(t, sub.Channel())
+}
+
+func TestSubscribeFromWithoutJournal(t *testing.T) {
+	ep := NewEventProcessor()
+	if err := ep.SubscribeFrom(NewSubscriber("dapp", "", "", ""), 0); err == nil {
+		t.Fatal("Expected an error")
+	}
+}
+
+// A post that waits on the disk does not hold up subscribing, other posts or
+// a replay, and the events still come out in order.
+func TestPostSyncOutsideLock(t *testing.T) {
+	j, dir := tempJournal(t)
+	defer os.RemoveAll(dir)
+	defer j.Close()
+
+	ep := NewEventProcessor()
+	defer ep.Shutdown()
+	ep.SetJournal(j)
+
+	syncing := make(chan bool)
+	release := make(chan bool)
+	j.fsync = func(f *os.File) error {
+		syncing <- true
+		<-release
+		return f.Sync()
+	}
+	live := NewSubscriber("live", "eth", "newBlock", "")
+	ep.Subscribe(live)
+
+	go ep.Post(Event{Event: "newBlock", Source: "eth", Resource: 1})
+	<-syncing
+
+	posted := make(chan bool)
+	subscribed := make(chan bool)
+	go func() {
+		ep.Post(Event{Event: "newBlock", Source: "eth", Resource: 2})
+		posted <- true
+	}()
+	replayed := NewSubscriber("replayed", "eth", "newBlock", "")
+	go func() {
+		ep.Subscribe(NewSubscriber("other", "eth", "", ""))
+		ep.SubscribeFrom(replayed, 0)
+		subscribed <- true
+	}()
+	select {
+	case <-subscribed:
+	case <-time.After(time.Second):
+		t.Fatal("Subscribe waited for the journal sync")
+	}
+	select {
+	case <-posted:
+		t.Fatal("The second event was journaled before the first")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	<-syncing
+	<-posted
+	for _, sub := range []*DefaultSubscriber{live, replayed} {
+		for i := 1; i <= 2; i++ {
+			e := recv(t, sub.Channel())
+			if e.Sequence != uint64(i) {
+				t.Fatalf("%s: expected sequence: %d, Got: %d", sub.Id(), i, e.Sequence)
+			}
+		}
+		noRecv(t, sub.Channel())
+	}
+}
@@ -0,0 +1,198 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// Names of the standard events. The payload type of each is registered by
+// the modules package (see modules/events.go), since that is where the
+// blockchain and filesystem types live.
+const (
+	EVENT_NEW_BLOCK       = "newBlock"
+	EVENT_NEW_TX          = "newTx"
+	EVENT_TX_FAILED       = "txFailed"
+	EVENT_STORAGE_CHANGED = "storageChanged"
+	EVENT_ADDRESS_CHANGED = "addressChanged"
+	EVENT_FILE_ADDED      = "fileAdded"
+
+	// Posted by the module supervisor (see modules.Supervisor).
+	EVENT_MODULE_READY          = "moduleReady"
+	EVENT_MODULE_DEGRADED       = "moduleDegraded"
+	EVENT_MODULE_FAILED         = "moduleFailed"
+	EVENT_MODULE_RESTARTING     = "moduleRestarting"
+	EVENT_MODULE_RESTARTED      = "moduleRestarted"
+	EVENT_MODULE_RESTART_FAILED = "moduleRestartFailed"
+)
+
+// An event kind ties an event name to the Go type of its resource, so that dapp code
+// gets the same shape no matter which module posted the event.
+type EventKind struct {
+	Name        string
+	Description string
+	// The canonical resource type (always a pointer to a struct).
+	Type reflect.Type
+}
+
+var (
+	kindsMutex = &sync.RWMutex{}
+	kinds      = make(map[string]*EventKind)
+)
+
+// Register an event kind. 'payload' is a value of the canonical resource type,
+// for example '&modules.Block{}'. Registering a name twice replaces the kind.
+func RegisterKind(name, description string, payload interface{}) {
+	t := reflect.TypeOf(payload)
+	if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("Payload of event kind %s must be a pointer to a struct, got %v", name, t))
+	}
+	kindsMutex.Lock()
+	defer kindsMutex.Unlock()
+	kinds[name] = &EventKind{Name: name, Description: description, Type: t}
+}
+
+// Get the kind with the given event name, or nil.
+func Kind(name string) *EventKind {
+	kindsMutex.RLock()
+	defer kindsMutex.RUnlock()
+	return kinds[name]
+}
+
+// Names of all registered kinds, sorted.
+func KindNames() []string {
+	kindsMutex.RLock()
+	defer kindsMutex.RUnlock()
+	names := make([]string, 0, len(kinds))
+	for name := range kinds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Validate checks that the resource of an event has the canonical type of its kind.
+// Events with names that are not registered are always valid. A nil resource is not.
+func Validate(e Event) error {
+	k := Kind(e.Event)
+	if k == nil {
+		return nil
+	}
+	if e.Resource == nil {
+		return fmt.Errorf("Event %s from %s has no resource, expected %v", e.Event, e.Source, k.Type)
+	}
+	t := reflect.TypeOf(e.Resource)
+	if t != k.Type && t != k.Type.Elem() {
+		return fmt.Errorf("Event %s from %s has resource of type %v, expected %v", e.Event, e.Source, t, k.Type)
+	}
+	return nil
+}
+
+// Encode the resource of an event as json.
+func EncodeResource(e Event) ([]byte, error) {
+	if err := Validate(e); err != nil {
+		return nil, err
+	}
+	return json.Marshal(e.Resource)
+}
+
+// Decode a json encoded resource. For registered kinds the result has the
+// canonical type, otherwise it is whatever encoding/json makes of it.
+func DecodeResource(name string, data []byte) (interface{}, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+	if k := Kind(name); k != nil {
+		v := reflect.New(k.Type.Elem())
+		if err := json.Unmarshal(data, v.Interface()); err != nil {
+			return nil, fmt.Errorf("Could not decode %s resource: %s", name, err)
+		}
+		return v.Interface(), nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Schema returns a json schema describing the json encoding of the kind's resource.
+func (k *EventKind) Schema() map[string]interface{} {
+	schema := typeSchema(k.Type, make(map[reflect.Type]bool))
+	schema["title"] = k.Name
+	if k.Description != "" {
+		schema["description"] = k.Description
+	}
+	return schema
+}
+
+// Schema of the kind with the given name, or nil.
+func Schema(name string) map[string]interface{} {
+	k := Kind(name)
+	if k == nil {
+		return nil
+	}
+	return k.Schema()
+}
+
+func typeSchema(t reflect.Type, seen map[reflect.Type]bool) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// []byte is base64 encoded
+			return map[string]interface{}{"type": "string"}
+		}
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem(), seen)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem(), seen)}
+	case reflect.Struct:
+		// Recursive types (like FsNode) are not expanded a second time.
+		if seen[t] {
+			return map[string]interface{}{"type": "object"}
+		}
+		seen[t] = true
+		defer delete(seen, t)
+		props := make(map[string]interface{})
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			name := f.Name
+			if tag := f.Tag.Get("json"); tag != "" {
+				if tag == "-" {
+					continue
+				}
+				if n := jsonTagName(tag); n != "" {
+					name = n
+				}
+			}
+			props[name] = typeSchema(f.Type, seen)
+		}
+		return map[string]interface{}{"type": "object", "properties": props}
+	}
+	return map[string]interface{}{}
+}
+
+func jsonTagName(tag string) string {
+	for i := 0; i < len(tag); i++ {
+		if tag[i] == ',' {
+			return tag[:i]
+		}
+	}
+	return tag
+}
@@ -0,0 +1,90 @@
+package events
+
+import (
+	"testing"
+)
+
+type testBlock struct {
+	Number string
+	Hash   string `json:"hash"`
+	Txs    []*testTx
+}
+
+type testTx struct {
+	Value int
+}
+
+func init() {
+	RegisterKind("testBlock", "A test block.", &testBlock{})
+}
+
+func TestValidate(t *testing.T) {
+	if err := Validate(Event{Event: "testBlock", Resource: &testBlock{}}); err != nil {
+		t.Error(err)
+	}
+	if err := Validate(Event{Event: "testBlock", Resource: testBlock{}}); err != nil {
+		t.Error(err)
+	}
+	if err := Validate(Event{Event: "testBlock", Resource: "0xabcd"}); err == nil {
+		t.Error("Expected a string resource to be invalid")
+	}
+	if err := Validate(Event{Event: "testBlock"}); err == nil {
+		t.Error("Expected a nil resource to be invalid")
+	}
+	if err := Validate(Event{Event: "unknown", Resource: 5}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDecodeResource(t *testing.T) {
+	b, err := EncodeResource(Event{Event: "testBlock", Resource: &testBlock{Number: "5", Hash: "abcd", Txs: []*testTx{{3}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := DecodeResource("testBlock", b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, ok := r.(*testBlock)
+	if !ok {
+		t.Fatalf("Expected *testBlock, Got: %T", r)
+	}
+	if block.Number != "5" || block.Hash != "abcd" || block.Txs[0].Value != 3 {
+		t.Fatalf("Unexpected block: %v", block)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	s := Schema("testBlock")
+	if s["title"] != "testBlock" || s["type"] != "object" {
+		t.Fatalf("Unexpected schema: %v", s)
+	}
+	props := s["properties"].(map[string]interface{})
+	if props["hash"].(map[string]interface{})["type"] != "string" {
+		t.Fatalf("Expected json tag name 'hash' in schema: %v", props)
+	}
+	txs := props["Txs"].(map[string]interface{})
+	if txs["type"] != "array" {
+		t.Fatalf("Expected array, Got: %v", txs)
+	}
+	item := txs["items"].(map[string]interface{})["properties"].(map[string]interface{})
+	if item["Value"].(map[string]interface{})["type"] != "integer" {
+		t.Fatalf("Expected integer, Got: %v", item)
+	}
+	if Schema("unknown") != nil {
+		t.Fatal("Expected no schema for unknown kind")
+	}
+}
+
+func TestStrictPost(t *testing.T) {
+	ep := NewEventProcessor()
+	defer ep.Shutdown()
+	ep.Strict = true
+	sub := NewSubscriber("sub", "", "", "")
+	ep.Subscribe(sub)
+
+	ep.Post(Event{Event: "testBlock", Resource: "not a block"})
+	noRecv(t, sub.Channel())
+	ep.Post(Event{Event: "testBlock", Resource: &testBlock{}})
+	recv(t, sub.Channel())
+}
@@ -0,0 +1,72 @@
+package events
+
+// Matching rules for subscriptions. These are shared by every registry
+// implementation and should also be used by modules that filter events
+// in their own 'Subscribe(name, event, target)', so that a pattern means
+// the same thing everywhere.
+//
+// A pattern is matched against the whole source, event or target string:
+//
+//	""          matches anything (same as "*")
+//	"*"         matches anything
+//	"newBlock"  matches exactly "newBlock"
+//	"newTx:*"   matches anything starting with "newTx:"
+//	"*:fail"    matches anything ending with ":fail"
+//	"a?cd"      '?' matches any single character
+//
+// There is no escaping; '*' and '?' are always wildcards.
+
+// Matches reports whether the subscriber should receive the event.
+func Matches(sub Subscriber, e Event) bool {
+	return Match(sub.Source(), e.Source) &&
+		Match(sub.Event(), e.Event) &&
+		Match(sub.Target(), e.Target)
+}
+
+// Match reports whether the value matches the pattern.
+func Match(pattern, value string) bool {
+	if pattern == "" || pattern == "*" {
+		return true
+	}
+	return glob(pattern, value)
+}
+
+// IsPattern reports whether the string contains wildcards (or is empty),
+// i.e. whether it can match more than one value.
+func IsPattern(s string) bool {
+	if s == "" {
+		return true
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] == '*' || s[i] == '?' {
+			return true
+		}
+	}
+	return false
+}
+
+// Iterative glob with backtracking to the last star.
+func glob(pattern, value string) bool {
+	p, v := 0, 0
+	star, mark := -1, 0
+	for v < len(value) {
+		if p < len(pattern) && (pattern[p] == '?' || pattern[p] == value[v]) {
+			p++
+			v++
+		} else if p < len(pattern) && pattern[p] == '*' {
+			star = p
+			mark = v
+			p++
+		} else if star != -1 {
+			p = star + 1
+			mark++
+			v = mark
+		} else {
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
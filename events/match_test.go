@@ -0,0 +1,65 @@
+package events
+
+import (
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		pattern string
+		value   string
+		match   bool
+	}{
+		{"", "anything", true},
+		{"", "", true},
+		{"*", "newBlock", true},
+		{"*", "", true},
+		{"newBlock", "newBlock", true},
+		{"newBlock", "newBlocks", false},
+		{"newBlock", "new", false},
+		{"newTx:*", "newTx:pre", true},
+		{"newTx:*", "newTx:post:fail", true},
+		{"newTx:*", "newTx", false},
+		{"newTx*", "newTx", true},
+		{"*:fail", "newTx:pre:fail", true},
+		{"*:fail", "newTx:pre", false},
+		{"newTx:*:fail", "newTx:post:fail", true},
+		{"newTx:*:fail", "newTx:post", false},
+		{"a?cd", "abcd", true},
+		{"a?cd", "acd", false},
+		{"ab*ab*c", "abababc", true},
+		{"ab*ab*c", "ababab", false},
+		{"0x1234*", "0x12345678", true},
+		{"0x1234*", "0x1233", false},
+	}
+	for _, c := range cases {
+		if m := Match(c.pattern, c.value); m != c.match {
+			t.Errorf("Match(%q, %q): Expected: %v, Got: %v", c.pattern, c.value, c.match, m)
+		}
+	}
+}
+
+func TestPatternSubscription(t *testing.T) {
+	ep := NewEventProcessor()
+	defer ep.Shutdown()
+
+	eth := NewSubscriber("eth", "eth", "*", "")
+	txs := NewSubscriber("txs", "*", "newTx:*", "")
+	contract := NewSubscriber("contract", "monk", "storageChanged", "abcd*")
+	ep.Subscribe(eth)
+	ep.Subscribe(txs)
+	ep.Subscribe(contract)
+
+	ep.Post(Event{Source: "eth", Event: "newBlock"})
+	recv(t, eth.Channel())
+	noRecv(t, txs.Channel())
+
+	ep.Post(Event{Source: "monk", Event: "newTx:post"})
+	noRecv(t, eth.Channel())
+	recv(t, txs.Channel())
+
+	ep.Post(Event{Source: "monk", Event: "storageChanged", Target: "abcd0001"})
+	recv(t, contract.Channel())
+	ep.Post(Event{Source: "monk", Event: "storageChanged", Target: "ffff0001"})
+	noRecv(t, contract.Channel())
+}
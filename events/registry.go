@@ -0,0 +1,308 @@
+package events
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/eris-ltd/decerver-interfaces/core"
+)
+
+// Number of journaled events read at a time when replaying.
+var ReplayBatchSize = 100
+
+var logger = core.NewLogger("Events")
+
+// EventProcessor is an in-memory, goroutine-safe implementation of EventRegistry.
+// Every subscriber gets its own buffered EventChannel, so a slow subscriber can only
+// lose its own events (according to its overflow policy) and never hangs Post or the
+// other subscribers. The channel is closed when the subscriber is removed.
+type EventProcessor struct {
+	// Used for subscribers that do not implement ConfigurableSubscriber.
+	Config *ChannelConfig
+	// If true, events with a resource that does not match the registered
+	// kind are dropped. Otherwise they are only logged.
+	Strict bool
+
+	mutex *sync.Mutex
+	subs  map[string]*subscription
+	// Dropped events of subscribers that have been removed.
+	dropped map[string]uint64
+	journal *Journal
+}
+
+func NewEventProcessor() *EventProcessor {
+	ep := &EventProcessor{}
+	ep.Config = DefaultChannelConfig
+	ep.mutex = &sync.Mutex{}
+	ep.subs = make(map[string]*subscription)
+	ep.dropped = make(map[string]uint64)
+	return ep
+}
+
+// Use a journal. From then on every posted event is journaled (and gets a
+// sequence number), and subscribers can be resumed with SubscribeFrom and
+// SubscribeSince. The journal is not closed by the processor.
+func (ep *EventProcessor) SetJournal(j *Journal) {
+	ep.mutex.Lock()
+	defer ep.mutex.Unlock()
+	ep.journal = j
+}
+
+func (ep *EventProcessor) Journal() *Journal {
+	ep.mutex.Lock()
+	defer ep.mutex.Unlock()
+	return ep.journal
+}
+
+// Post passes the event on to every subscriber that matches it. Subscribers that
+// are disconnected by their overflow policy are removed. Subscribers that are still
+// replaying get the event from the journal once they catch up. Only the sequence
+// number is taken under the processor lock; the event is written to the journal
+// (and synced) after it is released, so Subscribe and other posts do not wait on
+// the disk.
+func (ep *EventProcessor) Post(e Event) {
+	if err := Validate(e); err != nil {
+		logger.Println(err)
+		if ep.Strict {
+			return
+		}
+	}
+	ep.mutex.Lock()
+	j := ep.journal
+	if j != nil {
+		if err := j.reserve(&e); err != nil {
+			logger.Println("Could not journal event:", err)
+			j = nil
+		}
+	}
+	matches := make([]*subscription, 0, len(ep.subs))
+	for _, s := range ep.subs {
+		if s.live && Matches(s.sub, e) {
+			matches = append(matches, s)
+		}
+	}
+	ep.mutex.Unlock()
+
+	if j != nil {
+		if err := j.write(&e); err != nil {
+			logger.Println("Could not journal event:", err)
+		}
+	}
+	for _, s := range matches {
+		if !s.ec.Send(e) && s.ec.IsClosed() {
+			ep.remove(s)
+		}
+	}
+}
+
+// Subscribe adds a subscriber. The registry owns the subscriber channel: a new one
+// is set on every call, and it is closed when the subscriber is removed. A subscriber
+// with the same id as an existing one replaces it.
+func (ep *EventProcessor) Subscribe(sub Subscriber) {
+	ep.mutex.Lock()
+	s := ep.add(sub)
+	s.live = true
+	ep.mutex.Unlock()
+}
+
+// SubscribeFrom adds a subscriber, and first passes it every journaled event with a
+// sequence number greater than 'seq' that it matches. Live events follow once the
+// subscriber has caught up, without gaps or duplicates. Replayed events are never
+// dropped by the overflow policy; the replay simply waits for the subscriber.
+func (ep *EventProcessor) SubscribeFrom(sub Subscriber, seq uint64) error {
+	ep.mutex.Lock()
+	defer ep.mutex.Unlock()
+	if ep.journal == nil {
+		return fmt.Errorf("Can not resume subscriber %s: there is no event journal", sub.Id())
+	}
+	s := ep.add(sub)
+	go ep.replay(s, seq)
+	return nil
+}
+
+// SubscribeSince is like SubscribeFrom, but replays every event posted at or after 't'.
+func (ep *EventProcessor) SubscribeSince(sub Subscriber, t time.Time) error {
+	j := ep.Journal()
+	if j == nil {
+		return fmt.Errorf("Can not resume subscriber %s: there is no event journal", sub.Id())
+	}
+	seq, err := j.SequenceBefore(t)
+	if err != nil {
+		return err
+	}
+	return ep.SubscribeFrom(sub, seq)
+}
+
+// Must be called with the mutex held.
+func (ep *EventProcessor) add(sub Subscriber) *subscription {
+	config := ep.Config
+	if cs, ok := sub.(ConfigurableSubscriber); ok && cs.ChannelConfig() != nil {
+		config = cs.ChannelConfig()
+	}
+	s := &subscription{sub: sub, ec: NewEventChannel(config)}
+	sub.SetChannel(s.ec.Channel())
+
+	old := ep.subs[sub.Id()]
+	ep.subs[sub.Id()] = s
+	if old != nil {
+		ep.dropped[sub.Id()] += old.ec.Dropped()
+		old.ec.Close()
+	}
+	return s
+}
+
+// Pass journaled events to a subscriber until it has caught up, then switch it
+// to live delivery. Post takes a sequence number and picks live subscribers under
+// the mutex, so checking the last sequence number handed out under the mutex
+// leaves no gap. Events that have a number but are not written yet are waited for.
+func (ep *EventProcessor) replay(s *subscription, seq uint64) {
+	for {
+		ep.mutex.Lock()
+		if ep.subs[s.sub.Id()] != s {
+			ep.mutex.Unlock()
+			return
+		}
+		if ep.journal == nil || ep.journal.reservedSequence() <= seq {
+			s.live = true
+			ep.mutex.Unlock()
+			return
+		}
+		j := ep.journal
+		ep.mutex.Unlock()
+
+		done := j.waitAfter(seq)
+		evts, err := j.Since(seq, ReplayBatchSize)
+		if err != nil {
+			logger.Printf("Replay to %s failed: %s\n", s.sub.Id(), err)
+			ep.remove(s)
+			return
+		}
+		for _, e := range evts {
+			if Matches(s.sub, e) && !s.ec.SendWait(e) {
+				return
+			}
+			seq = e.Sequence
+		}
+		// Everything up to 'done' has been read, including numbers that
+		// were skipped because the write failed.
+		if (ReplayBatchSize <= 0 || len(evts) < ReplayBatchSize) && done > seq {
+			seq = done
+		}
+	}
+}
+
+// Unsubscribe removes the subscriber with the given id. Events that have not yet been
+// read are dropped, and the subscriber channel is closed.
+func (ep *EventProcessor) Unsubscribe(id string) {
+	ep.mutex.Lock()
+	s := ep.subs[id]
+	ep.mutex.Unlock()
+	if s != nil {
+		ep.remove(s)
+	}
+}
+
+// Shutdown removes all subscribers.
+func (ep *EventProcessor) Shutdown() {
+	ep.mutex.Lock()
+	subs := ep.subs
+	ep.subs = make(map[string]*subscription)
+	for id, s := range subs {
+		ep.dropped[id] += s.ec.Dropped()
+	}
+	ep.mutex.Unlock()
+	for _, s := range subs {
+		s.ec.Close()
+	}
+}
+
+// The number of active subscribers.
+func (ep *EventProcessor) SubscriberCount() int {
+	ep.mutex.Lock()
+	defer ep.mutex.Unlock()
+	return len(ep.subs)
+}
+
+// Dropped returns the number of events that could not be delivered, by subscriber
+// id. Counts are kept after a subscriber is removed, so that a dapp which keeps
+// getting disconnected still shows up.
+func (ep *EventProcessor) Dropped() map[string]uint64 {
+	ep.mutex.Lock()
+	defer ep.mutex.Unlock()
+	ret := make(map[string]uint64)
+	for id, n := range ep.dropped {
+		ret[id] = n
+	}
+	for id, s := range ep.subs {
+		ret[id] += s.ec.Dropped()
+	}
+	return ret
+}
+
+// Remove a subscription, unless it has already been replaced.
+func (ep *EventProcessor) remove(s *subscription) {
+	id := s.sub.Id()
+	ep.mutex.Lock()
+	if ep.subs[id] == s {
+		delete(ep.subs, id)
+		ep.dropped[id] += s.ec.Dropped()
+	}
+	ep.mutex.Unlock()
+	s.ec.Close()
+}
+
+type subscription struct {
+	sub Subscriber
+	ec  *EventChannel
+	// False while journaled events are being replayed.
+	live bool
+}
+
+// A default object that implements 'Subscriber' and 'ConfigurableSubscriber'.
+type DefaultSubscriber struct {
+	id     string
+	source string
+	event  string
+	target string
+	ch     chan Event
+	config *ChannelConfig
+}
+
+func NewSubscriber(id, source, event, target string) *DefaultSubscriber {
+	return &DefaultSubscriber{id: id, source: source, event: event, target: target}
+}
+
+func (ds *DefaultSubscriber) SetChannel(ch chan Event) {
+	ds.ch = ch
+}
+
+func (ds *DefaultSubscriber) Channel() chan Event {
+	return ds.ch
+}
+
+func (ds *DefaultSubscriber) Source() string {
+	return ds.source
+}
+
+func (ds *DefaultSubscriber) Id() string {
+	return ds.id
+}
+
+func (ds *DefaultSubscriber) Event() string {
+	return ds.event
+}
+
+func (ds *DefaultSubscriber) Target() string {
+	return ds.target
+}
+
+// Set the buffering for this subscriber. Must be called before subscribing.
+// A nil config means the registry default.
+func (ds *DefaultSubscriber) SetChannelConfig(config *ChannelConfig) {
+	ds.config = config
+}
+
+func (ds *DefaultSubscriber) ChannelConfig() *ChannelConfig {
+	return ds.config
+}
@@ -0,0 +1,120 @@
+package events
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func recv(t *testing.T, ch chan Event) Event {
+	select {
+	case e := <-ch:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+	return Event{}
+}
+
+func noRecv(t *testing.T, ch chan Event) {
+	select {
+	case e, ok := <-ch:
+		if ok {
+			t.Fatalf("Expected no event, Got: %v", e)
+		}
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestPostMatching(t *testing.T) {
+	ep := NewEventProcessor()
+	defer ep.Shutdown()
+
+	all := NewSubscriber("all", "", "", "")
+	blocks := NewSubscriber("blocks", "eth", "newBlock", "")
+	addr := NewSubscriber("addr", "eth", "addressChanged", "abcd")
+	ep.Subscribe(all)
+	ep.Subscribe(blocks)
+	ep.Subscribe(addr)
+
+	ep.Post(Event{Event: "newBlock", Source: "eth"})
+	if e := recv(t, all.Channel()); e.Event != "newBlock" {
+		t.Errorf("Expected: newBlock, Got: %s", e.Event)
+	}
+	recv(t, blocks.Channel())
+	noRecv(t, addr.Channel())
+
+	ep.Post(Event{Event: "addressChanged", Source: "eth", Target: "ffff"})
+	recv(t, all.Channel())
+	noRecv(t, blocks.Channel())
+	noRecv(t, addr.Channel())
+
+	ep.Post(Event{Event: "addressChanged", Source: "eth", Target: "abcd"})
+	recv(t, all.Channel())
+	if e := recv(t, addr.Channel()); e.Target != "abcd" {
+		t.Errorf("Expected: abcd, Got: %s", e.Target)
+	}
+}
+
+func TestPostOrder(t *testing.T) {
+	ep := NewEventProcessor()
+	defer ep.Shutdown()
+	sub := NewSubscriber("sub", "", "", "")
+	ep.Subscribe(sub)
+
+	// Post must not block, even though nobody is reading yet.
+	for i := 0; i < 100; i++ {
+		ep.Post(Event{Event: "newBlock", Resource: i})
+	}
+	for i := 0; i < 100; i++ {
+		e := recv(t, sub.Channel())
+		if e.Resource.(int) != i {
+			t.Fatalf("Expected: %d, Got: %v", i, e.Resource)
+		}
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	ep := NewEventProcessor()
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 50; i++ {
+		sub := NewSubscriber("sub", "", "", "")
+		ep.Subscribe(sub)
+		// Leave an undelivered event in the queue.
+		ep.Post(Event{Event: "newBlock"})
+		ep.Post(Event{Event: "newBlock"})
+		ep.Unsubscribe("sub")
+		for _ = range sub.Channel() {
+		}
+	}
+	if n := ep.SubscriberCount(); n != 0 {
+		t.Fatalf("Expected: 0 subscribers, Got: %d", n)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Fatalf("Leaked goroutines. Before: %d, After: %d", before, after)
+	}
+}
+
+func TestResubscribe(t *testing.T) {
+	ep := NewEventProcessor()
+	defer ep.Shutdown()
+	first := NewSubscriber("sub", "", "newBlock", "")
+	ep.Subscribe(first)
+	second := NewSubscriber("sub", "", "newTx", "")
+	ep.Subscribe(second)
+
+	if _, ok := <-first.Channel(); ok {
+		t.Fatal("Expected the replaced subscriber channel to be closed")
+	}
+	ep.Post(Event{Event: "newTx"})
+	recv(t, second.Channel())
+	if n := ep.SubscriberCount(); n != 1 {
+		t.Fatalf("Expected: 1 subscriber, Got: %d", n)
+	}
+}
@@ -1,13 +1,12 @@
 package blockchaininfo
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"path"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/eris-ltd/decerver-interfaces/core"
@@ -21,18 +20,30 @@ import (
 type BlkChainInfo struct {
 	BciApi    *blockchain.BlockChain
 	Addresses *modules.Addresses
+	Config    *BciConfig
+	// Buffering and overflow policy for subscription channels
+	EventConfig *events.ChannelConfig
 
 	pollBlocks      chan bool
 	mostRecentBlock string
 	pollAddresses   chan bool
 	addressesPolled map[string]string
-	config          string
-	chans           map[string]chan events.Event
+	configFile      string
+	chans           map[string]*events.EventChannel
+	subs            map[string]*bciSub
+	mutex           *sync.Mutex
 }
 
-// NewBlkChainInfo simply returns a pointer to a blank struct
+// What a subscription asked for. Both are patterns, see events.Match.
+type bciSub struct {
+	event  string
+	target string
+}
+
+// NewBlkChainInfo returns a pointer to a blank struct with the default event channel config
 func NewBlkChainInfo() *BlkChainInfo {
-	return &BlkChainInfo{}
+	cfg := *DefaultConfig
+	return &BlkChainInfo{Config: &cfg, EventConfig: events.DefaultChannelConfig, mutex: &sync.Mutex{}}
 }
 
 /*
@@ -44,7 +55,7 @@ func NewBlkChainInfo() *BlkChainInfo {
 
 // Register sets the module config settings and returns nile
 func (b *BlkChainInfo) Register(fileIO core.FileIO, rm core.RuntimeManager, eReg events.EventRegistry) error {
-	b.config = path.Join(fileIO.Modules(), "blockchain", "config")
+	b.configFile = path.Join(fileIO.Modules(), "blockchain", "config")
 	return nil
 }
 
@@ -57,38 +68,31 @@ func (b *BlkChainInfo) Init() error {
 	// set default values
 	b.BciApi = blockchain.New(http.DefaultClient)
 	b.Addresses = &modules.Addresses{}
-	b.chans = make(map[string]chan events.Event)
+	b.mutex.Lock()
+	b.chans = make(map[string]*events.EventChannel)
+	b.subs = make(map[string]*bciSub)
 	b.addressesPolled = make(map[string]string)
+	b.mutex.Unlock()
 
-	// read the config file
-	cfg, err := ioutil.ReadFile(b.config)
-	if err != nil {
+	// read the config file (see ReadConfig)
+	if err := b.ReadConfig(b.configFile); err != nil {
 		return err
 	}
 
-	// use the config file to establish the right settings for the API wrapper
-	bciCfg := make(map[string]string)
-	err = json.Unmarshal(cfg, bciCfg)
-	if err != nil {
-		return err
-	}
-	b.BciApi.GUID = bciCfg["guid"]
-	b.BciApi.Password = bciCfg["password"]
-	b.BciApi.SecondPassword = bciCfg["second_password"]
-	b.BciApi.APICode = bciCfg["api_code"]
+	// use the config to establish the right settings for the API wrapper
+	b.BciApi.GUID = b.Config.GUID
+	b.BciApi.Password = b.Config.Password
+	b.BciApi.SecondPassword = b.Config.SecondPassword
+	b.BciApi.APICode = b.Config.APICode
 
 	// sets the address list.
-	var a1 *blockchain.AddressList
 	if b.BciApi.GUID != "" {
-		a1 = &blockchain.AddressList{}
+		a1 := &blockchain.AddressList{}
 		if err := b.BciApi.Request(a1); err != nil {
 			return err
 		}
+		bciAccountListToDecerverAccountList(a1, b.Addresses)
 	}
-	bciAccountListToDecerverAccountList(a1, b.Addresses)
-
-	// sets the channels map
-	b.chans = make(map[string]chan events.Event)
 	return nil
 }
 
@@ -97,13 +101,16 @@ func (b *BlkChainInfo) Start() error {
 	return nil
 }
 
-// Shutdown simply stops any pollers
+// Shutdown simply stops any pollers and closes the subscription channels
 func (b *BlkChainInfo) Shutdown() error {
-	b.stopPollBlocks()
-
-	for addr := range b.addressesPolled {
-		b.stopPollAddresses(addr)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for name, ch := range b.chans {
+		ch.Close()
+		delete(b.chans, name)
+		delete(b.subs, name)
 	}
+	b.updatePollers()
 
 	return nil
 }
@@ -113,6 +120,17 @@ func (b *BlkChainInfo) Name() string {
 	return "blockchaininfo"
 }
 
+// Info returns the module info, with the version from package.json.
+func (b *BlkChainInfo) Info() *modules.ModuleInfo {
+	return &modules.ModuleInfo{
+		Name:       "blockchaininfo",
+		Version:    "0.8.0",
+		Author:     &modules.AuthorInfo{Name: "Eris Industries, Ltd.", EMail: "contact@erisindustries.com"},
+		Licence:    "MIT",
+		Repository: "git://github.com/eris-ltd/decerver-interfaces",
+	}
+}
+
 /*
 
    blockchain functions to satisfy interface. see:
@@ -218,33 +236,55 @@ func (b *BlkChainInfo) Script(file, lang string) (string, error) {
 	return "", nil
 }
 
-// Subscribe establishes long polling functions for either "newBlock" or "addr", "tx"
-// Either call will return a channel of events which the decerver can consume. The
-// former Subscribe function will return a block object whenever a new block is found
-// by long polling the API. The latter Subscribe function will return a transaction struct
-// whenever the watched address sends or receives a transaction.
+// Subscribe starts long polling for the events that match 'event' (see events.Match).
+// New blocks are found by polling the latest block, and address changes by polling
+// the transaction count of the target address. If the target is a pattern, every
+// address of the module that matches it is polled.
+// The old form, Subscribe("addr", "tx", address), is kept under the address, so
+// that UnSubscribe(address) ends it.
+// The channels are buffered according to EventConfig, so a consumer that stops reading
+// loses events instead of hanging the pollers.
 func (b *BlkChainInfo) Subscribe(name, event, target string) chan events.Event {
-	ch := make(chan events.Event)
-	switch name {
-	case "newBlock":
-		ch = b.startPollBlocks()
-	case "addr":
+	if name == "addr" {
+		name = target
 		if event == "tx" {
-			ch = b.startPollAddresses(target)
+			event = events.EVENT_ADDRESS_CHANGED
 		}
 	}
-	return ch
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if ch, ok := b.chans[name]; ok {
+		ch.Close()
+	}
+	ch := events.NewEventChannel(b.EventConfig)
+	b.chans[name] = ch
+	b.subs[name] = &bciSub{event: event, target: target}
+	b.updatePollers()
+	return ch.Channel()
 }
 
-// UnSubscribe either turns off the long polling for the new block or deletes the
-// address passed to it from the address transaction poller.
+// UnSubscribe ends the subscription, and stops the pollers that are no longer needed.
 func (b *BlkChainInfo) UnSubscribe(name string) {
-	switch name {
-	case "newBlock":
-		b.stopPollBlocks()
-	default:
-		b.stopPollAddresses(name)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if ch, ok := b.chans[name]; ok {
+		ch.Close()
+		delete(b.chans, name)
+	}
+	delete(b.subs, name)
+	b.updatePollers()
+}
+
+// Dropped returns the number of events that subscribers did not read in time,
+// by subscription name.
+func (b *BlkChainInfo) Dropped() map[string]uint64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	ret := make(map[string]uint64)
+	for name, ch := range b.chans {
+		ret[name] = ch.Dropped()
 	}
+	return ret
 }
 
 // Commit not supported by this module which is an API Wrapper around Blockchain.info
@@ -370,22 +410,86 @@ func bciAccountToDecerverAccount(a1 *blockchain.Address, a2 *modules.Account) {
 	a2.IsScript = false
 }
 
-func (b *BlkChainInfo) startPollBlocks() chan events.Event {
-	interval, _ := time.ParseDuration("2m")
-	ticker := time.NewTicker(interval)
-	b.pollBlocks = make(chan bool)
-	ch := make(chan events.Event)
-	b.chans["newBlock"] = ch
-	go b.pollBlock(ticker)
-	return ch
+// Starts and stops the pollers, and sets the addresses to poll, to fit the
+// subscriptions. Called with the mutex held.
+func (b *BlkChainInfo) updatePollers() {
+	blocks := false
+	addrs := make(map[string]bool)
+	for _, sub := range b.subs {
+		if events.Match(sub.event, events.EVENT_NEW_BLOCK) {
+			blocks = true
+		}
+		if events.Match(sub.event, events.EVENT_ADDRESS_CHANGED) {
+			for _, addr := range b.watched(sub.target) {
+				addrs[addr] = true
+			}
+		}
+	}
+
+	if blocks && b.pollBlocks == nil {
+		b.pollBlocks = make(chan bool)
+		go b.pollBlock(b.pollBlocks)
+	} else if !blocks && b.pollBlocks != nil {
+		close(b.pollBlocks)
+		b.pollBlocks = nil
+	}
+
+	for addr := range b.addressesPolled {
+		if !addrs[addr] {
+			delete(b.addressesPolled, addr)
+		}
+	}
+	for addr := range addrs {
+		if _, ok := b.addressesPolled[addr]; !ok {
+			// the nonce is set on the first poll
+			b.addressesPolled[addr] = ""
+		}
+	}
+	if len(addrs) > 0 && b.pollAddresses == nil {
+		b.pollAddresses = make(chan bool)
+		go b.pollAddress(b.pollAddresses)
+	} else if len(addrs) == 0 && b.pollAddresses != nil {
+		close(b.pollAddresses)
+		b.pollAddresses = nil
+	}
+}
+
+// The addresses a target stands for. A pattern stands for the addresses of
+// the module that match it.
+func (b *BlkChainInfo) watched(target string) []string {
+	if !events.IsPattern(target) {
+		return []string{target}
+	}
+	addrs := []string{}
+	for _, addr := range b.Addresses.AddressList {
+		if events.Match(target, addr) {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
 }
 
-func (b *BlkChainInfo) stopPollBlocks() {
-	b.pollBlocks <- true
+// Sends the event to every subscription that matches it.
+func (b *BlkChainInfo) post(eve events.Event) {
+	b.mutex.Lock()
+	chans := []*events.EventChannel{}
+	for name, sub := range b.subs {
+		if events.Match(sub.event, eve.Event) && events.Match(sub.target, eve.Target) {
+			chans = append(chans, b.chans[name])
+		}
+	}
+	b.mutex.Unlock()
+	// Sending can wait, depending on EventConfig, so it is done without the lock.
+	for _, ch := range chans {
+		ch.Send(eve)
+	}
 }
 
-func (b *BlkChainInfo) pollBlock(ticker *time.Ticker) {
+func (b *BlkChainInfo) pollBlock(stop chan bool) {
 	fmt.Println("[blockchain.info mod] Starting New Block Poller.")
+	interval, _ := time.ParseDuration("2m")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 	b.mostRecentBlock = b.LatestBlock()
 	var rec string
 	for {
@@ -396,89 +500,83 @@ func (b *BlkChainInfo) pollBlock(ticker *time.Ticker) {
 			if rec != b.mostRecentBlock {
 				b.mostRecentBlock = rec
 				b2 := b.Block(rec)
-				eve := events.Event{
-					Event:     "newBlock",
+				b.post(events.Event{
+					Event:     events.EVENT_NEW_BLOCK,
 					Resource:  b2,
 					Source:    b.Name(),
 					TimeStamp: time.Now(),
-				}
-				b.chans["newBlock"] <- eve
+				})
 				fmt.Printf("[blockchain.info mod] New Block: %s.\n", rec)
 			} else {
 				fmt.Println("[blockchain.info mod] No New Block.")
 			}
-		case <-b.pollBlocks:
+		case <-stop:
 			fmt.Println("[blockchain.info mod] Stopping New Block Poller.")
-			ticker.Stop()
-			break
+			return
 		}
 	}
 }
 
-func (b *BlkChainInfo) startPollAddresses(addr string) chan events.Event {
+func (b *BlkChainInfo) pollAddress(stop chan bool) {
+	fmt.Println("[blockchain.info mod] Starting New Address Poller.")
 	interval, _ := time.ParseDuration("1m")
 	ticker := time.NewTicker(interval)
-	if b.pollAddresses == nil {
-		b.pollAddresses = make(chan bool)
-	}
-	ch := make(chan events.Event)
-	b.chans[addr] = ch
-	b.addressesPolled[addr] = ""
-	if len(b.addressesPolled) == 1 {
-		go b.pollAddress(ticker)
-	}
-	return ch
-}
-
-func (b *BlkChainInfo) stopPollAddresses(addr string) {
-	delete(b.addressesPolled, addr)
-	if len(b.addressesPolled) == 0 {
-		b.pollAddresses <- true
-	}
-}
-
-func (b *BlkChainInfo) pollAddress(ticker *time.Ticker) {
-	fmt.Println("[blockchain.info mod] Starting New Address Poller.")
-	for addr := range b.addressesPolled {
-		b.addressesPolled[addr] = b.Account(addr).Nonce
-	}
-	rec := make(map[string]string)
+	defer ticker.Stop()
+	b.pollAddressesOnce()
 	for {
 		select {
 		case <-ticker.C:
 			fmt.Println("[blockchain.info mod] Polling Address(es).")
-			for addr := range b.addressesPolled {
-				rec[addr] = b.Account(addr).Nonce
-			}
-			for addr := range b.addressesPolled {
-				if rec[addr] != b.addressesPolled[addr] {
-					b.addressesPolled[addr] = rec[addr]
-
-					// get the tx object so we can send that over the Events
-					t1 := &blockchain.Address{Address: addr}
-					if err := b.BciApi.Request(t1); err != nil {
-						fmt.Println(err)
-					}
-					t2 := &modules.Transaction{}
-					bciTxToDecerverTx(&t1.Transactions[len(t1.Transactions)-1], t2)
-
-					// set and send the event
-					eve := events.Event{
-						Event:     "addressChanged",
-						Resource:  t2,
-						Source:    b.Name(),
-						TimeStamp: time.Now(),
-					}
-					b.chans[addr] <- eve
-					fmt.Printf("[blockchain.info mod] New transaction found for address: %s (New Nonce: %s)\n", addr, b.addressesPolled[addr])
-				} else {
-					fmt.Println("[blockchain.info mod] No New transactions found for address: ", addr)
-				}
-			}
-		case <-b.pollAddresses:
+			b.pollAddressesOnce()
+		case <-stop:
 			fmt.Println("[blockchain.info mod] Stopping Address Poller.")
-			ticker.Stop()
-			break
+			return
+		}
+	}
+}
+
+// Polls every address once. An address that is new to the poller only gets its
+// nonce recorded.
+func (b *BlkChainInfo) pollAddressesOnce() {
+	b.mutex.Lock()
+	polled := make(map[string]string, len(b.addressesPolled))
+	for addr, nonce := range b.addressesPolled {
+		polled[addr] = nonce
+	}
+	b.mutex.Unlock()
+
+	for addr, nonce := range polled {
+		rec := b.Account(addr).Nonce
+		b.mutex.Lock()
+		if _, ok := b.addressesPolled[addr]; ok {
+			b.addressesPolled[addr] = rec
+		}
+		b.mutex.Unlock()
+		if nonce == "" || rec == nonce {
+			fmt.Println("[blockchain.info mod] No New transactions found for address: ", addr)
+			continue
+		}
+
+		// get the tx object so we can send that over the Events
+		t1 := &blockchain.Address{Address: addr}
+		if err := b.BciApi.Request(t1); err != nil {
+			fmt.Println(err)
+			continue
+		}
+		if len(t1.Transactions) == 0 {
+			continue
 		}
+		t2 := &modules.Transaction{}
+		bciTxToDecerverTx(&t1.Transactions[len(t1.Transactions)-1], t2)
+
+		// set and send the event
+		b.post(events.Event{
+			Event:     events.EVENT_ADDRESS_CHANGED,
+			Target:    addr,
+			Resource:  t2,
+			Source:    b.Name(),
+			TimeStamp: time.Now(),
+		})
+		fmt.Printf("[blockchain.info mod] New transaction found for address: %s (New Nonce: %s)\n", addr, rec)
 	}
 }
@@ -0,0 +1,38 @@
+package blockchaininfo
+
+import (
+	"github.com/eris-ltd/decerver-interfaces/config"
+)
+
+// Environment variables like DECERVER_BLOCKCHAININFO_GUID override the config file.
+const ENV_PREFIX = "DECERVER_BLOCKCHAININFO"
+
+// The wallet credentials. Without a guid only the query functions work.
+type BciConfig struct {
+	GUID           string `json:"guid"`
+	Password       string `json:"password"`
+	SecondPassword string `json:"second_password"`
+	APICode        string `json:"api_code"`
+}
+
+var DefaultConfig = &BciConfig{}
+
+func (b *BlkChainInfo) WriteConfig(config_file string) error {
+	return config.Write(b.Config, config_file)
+}
+
+// Load defaults, the config file and the environment (see config.Loader).
+// The file is created if it does not exist.
+func (b *BlkChainInfo) ReadConfig(config_file string) error {
+	loader := &config.Loader{
+		Defaults:       DefaultConfig,
+		File:           config_file,
+		WriteIfMissing: true,
+		EnvPrefix:      ENV_PREFIX,
+	}
+	return loader.Load(b.Config)
+}
+
+func (b *BlkChainInfo) SetConfig(field string, value interface{}) error {
+	return config.Set(b.Config, field, value)
+}
@@ -8,6 +8,9 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/eris-ltd/decerver-interfaces/core"
@@ -20,6 +23,8 @@ import (
 )
 
 type BTC struct {
+	Config *BtcdConfig
+
 	btcdConfig   *rpc.ConnConfig
 	walletConfig *rpc.ConnConfig
 	// btcrpcclient does not allow for new subscriptions
@@ -27,29 +32,45 @@ type BTC struct {
 	// so we make a new client (websocket connection) for each subscription
 	// and a corresponding channel to push things back up to the decerver
 	// we also use a single client for get and push calls
-	client   *rpc.Client
+	client *rpc.Client
+	// Guards notifies, chans and commits.
+	mutex    *sync.Mutex
 	notifies map[string]*rpc.Client
-	chans    map[string]chan events.Event
+	chans    map[string]*events.EventChannel
+	// Numbers the subscriptions Commit makes to wait for its block.
+	commits int
+
+	// Buffering and overflow policy for subscription channels
+	EventConfig *events.ChannelConfig
 
 	btcproc    *os.Process
 	walletproc *os.Process
 }
 
-func (b *BTC) Register(fileIO core.FileIO, runtime core.Runtime, eReg events.EventRegistry) error {
+// BTC is a module, so it can be supervised (see modules.Supervisor).
+var _ modules.Module = (*BTC)(nil)
+
+func (b *BTC) Register(fileIO core.FileIO, rm core.RuntimeManager, eReg events.EventRegistry) error {
 	return nil
 }
 
 func NewBtcd() *BTC {
-	return &BTC{}
+	cfg := *DefaultConfig
+	b := &BTC{Config: &cfg, EventConfig: events.DefaultChannelConfig}
+	b.mutex = &sync.Mutex{}
+	b.chans = make(map[string]*events.EventChannel)
+	b.notifies = make(map[string]*rpc.Client)
+	return b
 }
 
 func (b *BTC) Init() error {
 	// hack to get rpc.cert
-	cmd := exec.Command("btcd", "--nodnsseed", "-u", "rpcuser", "-P", "rpcpass")
+	cfg := b.Config
+	cmd := exec.Command("btcd", "--nodnsseed", "-u", cfg.RpcUser, "-P", cfg.RpcPass)
 	go cmd.Run()
 	time.Sleep(2 * time.Second)
 	cmd.Process.Kill()
-	cmd = exec.Command("btcwallet", "-u", "rpcuser", "-P", "rpcpass")
+	cmd = exec.Command("btcwallet", "-u", cfg.RpcUser, "-P", cfg.RpcPass)
 	go cmd.Run()
 	time.Sleep(2 * time.Second)
 	cmd.Process.Kill()
@@ -61,10 +82,10 @@ func (b *BTC) Init() error {
 		log.Fatal(err)
 	}
 	connCfg := &rpc.ConnConfig{
-		Host:         "localhost:18556",
+		Host:         cfg.BtcdHost,
 		Endpoint:     "ws",
-		User:         "rpcuser", // load these from file ya
-		Pass:         "rpcpass",
+		User:         cfg.RpcUser,
+		Pass:         cfg.RpcPass,
 		Certificates: certs,
 	}
 	b.btcdConfig = connCfg
@@ -75,17 +96,14 @@ func (b *BTC) Init() error {
 		log.Fatal(err)
 	}
 	connCfg = &rpc.ConnConfig{
-		Host:         "localhost:18554",
+		Host:         cfg.WalletHost,
 		Endpoint:     "ws",
-		User:         "rpcuser", // load these from file ya
-		Pass:         "rpcpass",
+		User:         cfg.RpcUser,
+		Pass:         cfg.RpcPass,
 		Certificates: certs,
 	}
 	b.walletConfig = connCfg
 
-	b.chans = make(map[string]chan events.Event)
-	b.notifies = make(map[string]*rpc.Client)
-
 	return nil
 }
 
@@ -110,12 +128,17 @@ func startProc(cmd *exec.Cmd, config *rpc.ConnConfig) error {
 }
 
 func (b *BTC) Start() error {
+	cfg := b.Config
+	net := []string{}
+	if cfg.Simnet {
+		net = append(net, "--simnet")
+	}
 	// start up btcd
-	cmd := exec.Command("btcd", "--simnet", "--nodnsseed", "-u", "rpcuser", "-P", "rpcpass")
+	cmd := exec.Command("btcd", append(net, "--nodnsseed", "-u", cfg.RpcUser, "-P", cfg.RpcPass)...)
 	startProc(cmd, b.btcdConfig)
 	b.btcproc = cmd.Process
 	// start the wallet server
-	cmd = exec.Command("btcwallet", "--simnet", "-u", "rpcuser", "-P", "rpcpass")
+	cmd = exec.Command("btcwallet", append(net, "-u", cfg.RpcUser, "-P", cfg.RpcPass)...)
 	startProc(cmd, b.walletConfig)
 	b.walletproc = cmd.Process
 
@@ -133,54 +156,120 @@ func (b *BTC) Start() error {
 }
 
 func (b *BTC) Shutdown() error {
-	b.client.Shutdown()
-	for _, c := range b.notifies {
+	if b.client != nil {
+		b.client.Shutdown()
+		b.client = nil
+	}
+	b.mutex.Lock()
+	notifies, chans := b.notifies, b.chans
+	b.notifies = make(map[string]*rpc.Client)
+	b.chans = make(map[string]*events.EventChannel)
+	b.mutex.Unlock()
+	for _, c := range notifies {
 		c.Shutdown()
 	}
-	//TODO: close channels
+	for _, ch := range chans {
+		ch.Close()
+	}
 
-	// shutdown wallet and btcd
-	b.walletproc.Signal(os.Interrupt)
-	b.walletproc.Wait()
-	b.btcproc.Signal(os.Interrupt)
-	b.btcproc.Wait()
+	// shutdown wallet and btcd. They may have exited already.
+	for _, proc := range []*os.Process{b.walletproc, b.btcproc} {
+		if proc != nil {
+			proc.Signal(os.Interrupt)
+			proc.Wait()
+		}
+	}
+	b.walletproc = nil
+	b.btcproc = nil
 	return nil
 }
 
-func (b *BTC) ReadConfig(config_file string) {
+// Restart stops btcd and the wallet and starts them again. Subscriptions
+// are ended.
+func (b *BTC) Restart() error {
+	if err := b.Shutdown(); err != nil {
+		return err
+	}
+	return b.Start()
+}
 
+func (b *BTC) SetProperty(name string, data interface{}) error {
+	return fmt.Errorf("No property named: %s", name)
 }
 
-func (b *BTC) WriteConfig(config_file string) {
+func (b *BTC) Property(name string) interface{} {
+	return nil
+}
 
+// Health implements modules.HealthChecker. Without btcd nothing works, without
+// the wallet we can still read the chain.
+func (b *BTC) Health() *modules.Health {
+	if b.btcproc == nil {
+		return &modules.Health{State: modules.HealthFailed, Details: "btcd is not running"}
+	}
+	if err := b.btcproc.Signal(syscall.Signal(0)); err != nil {
+		return &modules.Health{State: modules.HealthFailed, Details: "btcd exited: " + err.Error()}
+	}
+	if b.walletproc == nil {
+		return &modules.Health{State: modules.HealthDegraded, Details: "btcwallet is not running"}
+	}
+	if err := b.walletproc.Signal(syscall.Signal(0)); err != nil {
+		return &modules.Health{State: modules.HealthDegraded, Details: "btcwallet exited: " + err.Error()}
+	}
+	return &modules.Health{State: modules.HealthReady, Details: ""}
 }
 
+// ReadConfig and WriteConfig implemented in config.go
+
 func (b *BTC) Name() string {
 	return "btcd"
 }
 
+// Info implements modules.Versioned, so dapps can depend on a version range.
+func (b *BTC) Info() *modules.ModuleInfo {
+	return &modules.ModuleInfo{
+		Name:       "btcd",
+		Version:    "0.8.0",
+		Author:     &modules.AuthorInfo{Name: "Eris Industries, Ltd.", EMail: "contact@erisindustries.com"},
+		Licence:    "MIT",
+		Repository: "git://github.com/eris-ltd/decerver-interfaces",
+	}
+}
+
+// Subscribe follows the rules of events.Match: blocks are pushed if 'event'
+// matches newBlock, transactions if it matches newTx, and only those whose
+// hash matches 'target'.
 func (b *BTC) Subscribe(name string, event string, target string) chan events.Event {
 	// for each subscription we create a new websocket connection client
 	// with a set of handlers (the callbacks)
 	// and a corresponding channel to push the event up
+	// the handlers must never block the rpc client, so we send on
+	// a buffered channel with an overflow policy
 	handlers := rpc.NotificationHandlers{}
-	ch := make(chan events.Event)
-	eve := events.Event{
-		Event:     event,
-		Target:    target,
-		Source:    b.Name(),
-		TimeStamp: time.Now(),
-	}
-	switch name {
-	case "newBlock":
-		handlers.OnBlockConnected = func(hash *btcwire.ShaHash, height int32) {
-			eve.Resource = hash
-			ch <- eve
+	ch := events.NewEventChannel(b.EventConfig)
+	send := func(kind, hash string, resource interface{}) {
+		if !events.Match(target, hash) {
+			return
 		}
-	case "tx":
+		ch.Send(events.Event{
+			Event:     kind,
+			Target:    hash,
+			Resource:  resource,
+			Source:    b.Name(),
+			TimeStamp: time.Now(),
+		})
+	}
+	// resources must have the canonical type for the event (see modules/events.go)
+	blocks := events.Match(event, events.EVENT_NEW_BLOCK)
+	if blocks {
 		handlers.OnBlockConnected = func(hash *btcwire.ShaHash, height int32) {
-			eve.Resource = hash
-			ch <- eve
+			send(events.EVENT_NEW_BLOCK, hash.String(), convertBlockConnected(hash, height))
+		}
+	}
+	txs := events.Match(event, events.EVENT_NEW_TX)
+	if txs {
+		handlers.OnTxAccepted = func(hash *btcwire.ShaHash, amount btcutil.Amount) {
+			send(events.EVENT_NEW_TX, hash.String(), &modules.Transaction{Hash: hash.String()})
 		}
 	}
 	client, err := rpc.New(b.walletConfig, &handlers)
@@ -188,15 +277,52 @@ func (b *BTC) Subscribe(name string, event string, target string) chan events.Ev
 		log.Println("cmah!!!", err)
 		return nil
 	}
-	switch name {
-	case "newBlock":
+	if blocks {
 		client.NotifyBlocks()
-	case "tx":
-
 	}
+	if txs {
+		client.NotifyNewTransactions(false)
+	}
+	// A subscription with the same name replaces the old one.
+	b.mutex.Lock()
+	oldClient, oldCh := b.notifies[name], b.chans[name]
 	b.chans[name] = ch
 	b.notifies[name] = client
-	return ch
+	b.mutex.Unlock()
+	if oldClient != nil {
+		oldClient.Shutdown()
+	}
+	if oldCh != nil {
+		oldCh.Close()
+	}
+	return ch.Channel()
+}
+
+func (b *BTC) UnSubscribe(name string) {
+	b.mutex.Lock()
+	c, cok := b.notifies[name]
+	ch, chok := b.chans[name]
+	delete(b.notifies, name)
+	delete(b.chans, name)
+	b.mutex.Unlock()
+	if cok {
+		c.Shutdown()
+	}
+	if chok {
+		ch.Close()
+	}
+}
+
+// Dropped returns the number of events that subscribers did not read in time,
+// by subscription name.
+func (b *BTC) Dropped() map[string]uint64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	ret := make(map[string]uint64)
+	for name, ch := range b.chans {
+		ret[name] = ch.Dropped()
+	}
+	return ret
 }
 
 /*
@@ -258,9 +384,25 @@ func (b *BTC) Push(cmd string, params ...string) (ret string, err error) {
 	return
 }
 
+// Commit mines a block. It waits for the block on a subscription of its own, so
+// the events of other subscribers are left alone.
 func (b *BTC) Commit() {
+	if b.client == nil {
+		log.Println("btcd: can not commit, the module is not started")
+		return
+	}
+	b.mutex.Lock()
+	b.commits++
+	name := fmt.Sprintf("commit-%d", b.commits)
+	b.mutex.Unlock()
+	ch := b.Subscribe(name, events.EVENT_NEW_BLOCK, "")
+	if ch == nil {
+		log.Println("btcd: can not commit, no block notifications")
+		return
+	}
+	defer b.UnSubscribe(name)
 	b.client.SetGenerate(true, 1) // num cpus
-	_ = <-b.chans["newBlock"]
+	<-ch
 	b.client.SetGenerate(false, 1)
 }
 
@@ -286,3 +428,11 @@ func (b *BTC) Storage(target string) modules.Storage {
 	// not currently supported for btcd
 	return modules.Storage{}
 }
+
+// block notifications only carry the hash and height
+func convertBlockConnected(hash *btcwire.ShaHash, height int32) *modules.Block {
+	return &modules.Block{
+		Hash:   hash.String(),
+		Number: strconv.Itoa(int(height)),
+	}
+}
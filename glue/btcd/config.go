@@ -0,0 +1,45 @@
+package btcdglue
+
+import (
+	"github.com/eris-ltd/decerver-interfaces/config"
+)
+
+// Environment variables like DECERVER_BTCD_RPC_USER override the config file.
+const ENV_PREFIX = "DECERVER_BTCD"
+
+type BtcdConfig struct {
+	BtcdHost   string `json:"btcd_host" validate:"required"`
+	WalletHost string `json:"wallet_host" validate:"required"`
+	RpcUser    string `json:"rpc_user" validate:"required"`
+	RpcPass    string `json:"rpc_pass" validate:"required"`
+	// Run btcd and the wallet on the simulation network.
+	Simnet bool `json:"simnet"`
+}
+
+var DefaultConfig = &BtcdConfig{
+	BtcdHost:   "localhost:18556",
+	WalletHost: "localhost:18554",
+	RpcUser:    "rpcuser",
+	RpcPass:    "rpcpass",
+	Simnet:     true,
+}
+
+func (b *BTC) WriteConfig(config_file string) error {
+	return config.Write(b.Config, config_file)
+}
+
+// Load defaults, the config file and the environment (see config.Loader).
+// The file is created if it does not exist.
+func (b *BTC) ReadConfig(config_file string) error {
+	loader := &config.Loader{
+		Defaults:       DefaultConfig,
+		File:           config_file,
+		WriteIfMissing: true,
+		EnvPrefix:      ENV_PREFIX,
+	}
+	return loader.Load(b.Config)
+}
+
+func (b *BTC) SetConfig(field string, value interface{}) error {
+	return config.Set(b.Config, field, value)
+}
@@ -1,26 +1,26 @@
 package eth
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
+	"github.com/eris-ltd/decerver-interfaces/config"
 	"github.com/eris-ltd/decerver-interfaces/glue/utils"
 	"github.com/eris-ltd/go-ethereum/ethutil"
 	"io"
-	"io/ioutil"
 	"os"
 	"path"
-	"reflect"
 )
 
 var ErisLtd = utils.ErisLtd
 
+// Config fields can be set with DECERVER_ETH_<JSON NAME>, e.g. DECERVER_ETH_PORT.
+const ENV_PREFIX = "DECERVER_ETH"
+
 type ChainConfig struct {
-	Port             int    `json:"port"`
+	Port             int    `json:"port" validate:"port"`
 	Mining           bool   `json:"mining"`
-	MaxPeers         int    `json:"max_peers"`
+	MaxPeers         int    `json:"max_peers" validate:"min=0"`
 	ConfigFile       string `json:"config_file"`
-	RootDir          string `json:"root_dir"`
+	RootDir          string `json:"root_dir" validate:"required"`
 	LogFile          string `json:"log_file"`
 	DbName           string `json:"db_name"`
 	LLLPath          string `json:"lll_path"`
@@ -30,10 +30,10 @@ type ChainConfig struct {
 	Identifier       string `json:"id"`
 	KeySession       string `json:"key_session"`
 	KeyStore         string `json:"key_store"`
-	KeyCursor        int    `json:"key_cursor"`
+	KeyCursor        int    `json:"key_cursor" validate:"min=0"`
 	KeyFile          string `json:"key_file"`
 	Difficulty       string `json:"difficulty"`
-	LogLevel         int    `json:"log_level"`
+	LogLevel         int    `json:"log_level" validate:"min=0,max=5"`
 	Adversary        int    `json:"adversary"`
 }
 
@@ -61,53 +61,27 @@ var DefaultConfig = &ChainConfig{
 }
 
 // can these methods be functions in decerver that take the modules as argument?
-func (mod *EthModule) WriteConfig(config_file string) {
-	b, err := json.Marshal(mod.eth.config)
-	if err != nil {
-		fmt.Println("error marshalling config:", err)
-		return
-	}
-	var out bytes.Buffer
-	json.Indent(&out, b, "", "\t")
-	ioutil.WriteFile(config_file, out.Bytes(), 0600)
+func (mod *EthModule) WriteConfig(config_file string) error {
+	return config.Write(mod.eth.config, config_file)
 }
-func (mod *EthModule) ReadConfig(config_file string) {
-	b, err := ioutil.ReadFile(config_file)
-	if err != nil {
-		fmt.Println("could not read config", err)
-		fmt.Println("resorting to defaults")
-		mod.WriteConfig(config_file)
-		return
-	}
-	var config ChainConfig
-	err = json.Unmarshal(b, &config)
-	if err != nil {
-		fmt.Println("error unmarshalling config from file:", err)
-		fmt.Println("resorting to defaults")
-		//mod.eth.config = DefaultConfig
-		return
+
+// Load the configuration: defaults, then the file, then DECERVER_ETH_* environment
+// variables. If the file does not exist it is created. If it can not be read, or
+// a field is invalid, the config is left as it was and an error is returned.
+func (mod *EthModule) ReadConfig(config_file string) error {
+	loader := &config.Loader{
+		Defaults:       DefaultConfig,
+		File:           config_file,
+		WriteIfMissing: true,
+		EnvPrefix:      ENV_PREFIX,
 	}
-	*(mod.Config) = config
+	return loader.Load(mod.eth.config)
 }
 
+// Set a field in the config struct. The value is converted to the type
+// of the field (so a float64 from javascript can set an int) and validated.
 func (mod *EthModule) SetConfig(field string, value interface{}) error {
-	cv := reflect.ValueOf(mod.eth.config).Elem()
-	f := cv.FieldByName(field)
-	kind := f.Kind()
-
-	k := reflect.ValueOf(value).Kind()
-	if kind != k {
-		return fmt.Errorf("Invalid kind. Expected %s, received %s", kind, k)
-	}
-
-	if kind == reflect.String {
-		f.SetString(value.(string))
-	} else if kind == reflect.Int {
-		f.SetInt(int64(value.(int)))
-	} else if kind == reflect.Bool {
-		f.SetBool(value.(bool))
-	}
-	return nil
+	return config.Set(mod.eth.config, field, value)
 }
 
 // this will probably never be used
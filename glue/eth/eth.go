@@ -68,7 +68,8 @@ func NewEth(th *eth.Ethereum) *EthModule {
 	m := new(Eth)
 	// Here we load default config and leave it to caller
 	// to read a config file to overwrite
-	mm.Config = DefaultConfig
+	cfg := *DefaultConfig
+	mm.Config = &cfg
 	m.config = mm.Config
 	if th != nil {
 		m.ethereum = th
@@ -91,7 +92,8 @@ func (mod *EthModule) Init() error {
 	m := mod.eth
 	// if didn't call NewEth
 	if m.config == nil {
-		m.config = DefaultConfig
+		cfg := *DefaultConfig
+		m.config = &cfg
 	}
 
 	//ethdoug.Adversary = mod.Config.Adversary
@@ -148,6 +150,17 @@ func (mod *EthModule) Name() string {
 	return "eth"
 }
 
+// Info implements modules.Versioned, so dapps can depend on a version range.
+func (mod *EthModule) Info() *modules.ModuleInfo {
+	return &modules.ModuleInfo{
+		Name:       "eth",
+		Version:    "0.8.0",
+		Author:     &modules.AuthorInfo{Name: "Eris Industries, Ltd.", EMail: "contact@erisindustries.com"},
+		Licence:    "MIT",
+		Repository: "git://github.com/eris-ltd/decerver-interfaces",
+	}
+}
+
 /*
    Wrapper so module satisfies Blockchain
 */
@@ -1,31 +1,31 @@
 package genblock
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
+	"github.com/eris-ltd/decerver-interfaces/config"
 	"github.com/eris-ltd/decerver-interfaces/glue/utils"
 	"github.com/eris-ltd/thelonious/monkutil"
-	"io/ioutil"
 	"os"
 	"path"
-	"reflect"
 )
 
 var ErisLtd = utils.ErisLtd
 
+// Prefix of the environment variables that override the config file.
+const ENV_PREFIX = "DECERVER_GENBLOCK"
+
 type ChainConfig struct {
 	ConfigFile   string `json:"config_file"`
-	RootDir      string `json:"root_dir"`
+	RootDir      string `json:"root_dir" validate:"required"`
 	LogFile      string `json:"log_file"`
 	DbName       string `json:"db_name"`
 	LLLPath      string `json:"lll_path"`
 	ContractPath string `json:"contract_path"`
 	KeySession   string `json:"key_session"`
 	KeyStore     string `json:"key_store"`
-	KeyCursor    int    `json:"key_cursor"`
+	KeyCursor    int    `json:"key_cursor" validate:"min=0"`
 	KeyFile      string `json:"key_file"`
-	LogLevel     int    `json:"log_level"`
+	LogLevel     int    `json:"log_level" validate:"min=0,max=5"`
 	Unique       bool   `json:"unique"`
 	PrivateKey   string `json:"private_key"`
 }
@@ -47,51 +47,24 @@ var DefaultConfig = &ChainConfig{
 }
 
 // can these methods be functions in decerver that take the modules as argument?
-func (mod *GenBlockModule) WriteConfig(config_file string) {
-	b, err := json.Marshal(mod.Config)
-	if err != nil {
-		fmt.Println("error marshalling config:", err)
-		return
-	}
-	var out bytes.Buffer
-	json.Indent(&out, b, "", "\t")
-	ioutil.WriteFile(config_file, out.Bytes(), 0600)
+func (mod *GenBlockModule) WriteConfig(config_file string) error {
+	return config.Write(mod.Config, config_file)
 }
-func (mod *GenBlockModule) ReadConfig(config_file string) {
-	b, err := ioutil.ReadFile(config_file)
-	if err != nil {
-		fmt.Println("could not read config", err)
-		fmt.Println("resorting to defaults")
-		mod.WriteConfig(config_file)
-		return
-	}
-	var config ChainConfig
-	err = json.Unmarshal(b, &config)
-	if err != nil {
-		fmt.Println("error unmarshalling config from file:", err)
-		return
+
+// Load defaults, the config file and the environment (see config.Loader).
+// The file is created if it does not exist.
+func (mod *GenBlockModule) ReadConfig(config_file string) error {
+	loader := &config.Loader{
+		Defaults:       DefaultConfig,
+		File:           config_file,
+		WriteIfMissing: true,
+		EnvPrefix:      ENV_PREFIX,
 	}
-	*(mod.Config) = config
+	return loader.Load(mod.Config)
 }
 
 func (mod *GenBlockModule) SetConfig(field string, value interface{}) error {
-	cv := reflect.ValueOf(mod.Config).Elem()
-	f := cv.FieldByName(field)
-	kind := f.Kind()
-
-	k := reflect.ValueOf(value).Kind()
-	if kind != k {
-		return fmt.Errorf("Invalid kind. Expected %s, received %s", kind, k)
-	}
-
-	if kind == reflect.String {
-		f.SetString(value.(string))
-	} else if kind == reflect.Int {
-		f.SetInt(int64(value.(int)))
-	} else if kind == reflect.Bool {
-		f.SetBool(value.(bool))
-	}
-	return nil
+	return config.Set(mod.Config, field, value)
 }
 
 // this will probably never be used
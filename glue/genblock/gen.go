@@ -46,7 +46,8 @@ type GenBlockModule struct {
 // Create a new genesis block module
 func NewGenBlockModule(block *monkchain.Block) *GenBlockModule {
 	g := new(GenBlockModule)
-	g.Config = DefaultConfig
+	cfg := *DefaultConfig
+	g.Config = &cfg
 	// TODO: if block is nil, get a good one
 	if block == nil {
 		block = monkchain.NewBlockFromBytes(monkutil.Encode(monkchain.Genesis))
@@ -64,7 +65,8 @@ func (mod *GenBlockModule) Register(fileIO core.FileIO, rm core.RuntimeManager,
 func (mod *GenBlockModule) Init() error {
 	// if didn't call NewGenBlockModule
 	if mod.Config == nil {
-		mod.Config = DefaultConfig
+		cfg := *DefaultConfig
+		mod.Config = &cfg
 	}
 
 	mod.gConfig()
@@ -105,6 +107,17 @@ func (mod *GenBlockModule) Name() string {
 	return "genblock"
 }
 
+// Info implements modules.Versioned, so dapps can depend on a version range.
+func (mod *GenBlockModule) Info() *modules.ModuleInfo {
+	return &modules.ModuleInfo{
+		Name:       "genblock",
+		Version:    "0.8.0",
+		Author:     &modules.AuthorInfo{Name: "Eris Industries, Ltd.", EMail: "contact@erisindustries.com"},
+		Licence:    "MIT",
+		Repository: "git://github.com/eris-ltd/decerver-interfaces",
+	}
+}
+
 /*
    Implement Blockchain
 */
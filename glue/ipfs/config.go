@@ -11,10 +11,13 @@ func homeDir() string {
 	return usr.HomeDir
 }
 
+// Environment variables like DECERVER_IPFS_ONLINE override the config file.
+const ENV_PREFIX = "DECERVER_IPFS"
+
 type FSConfig struct {
-	RootDir  string // its a lie, this is just for the datastore. no way to configure two different ipfs processes right now..
-	LogLevel int
-	Online   bool
+	RootDir  string `json:"root_dir" validate:"required"` // its a lie, this is just for the datastore. no way to configure two different ipfs processes right now..
+	LogLevel int    `json:"log_level" validate:"min=0,max=5"`
+	Online   bool   `json:"online"`
 }
 
 var DefaultConfig = &FSConfig{
@@ -0,0 +1,93 @@
+package ipfs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/eris-ltd/decerver-interfaces/dapps"
+	mdag "github.com/eris-ltd/go-ipfs/merkledag"
+	uio "github.com/eris-ltd/go-ipfs/unixfs/io"
+	ftpb "github.com/eris-ltd/go-ipfs/unixfs/pb"
+	util "github.com/eris-ltd/go-ipfs/util"
+
+	proto "github.com/eris-ltd/go-ipfs/Godeps/_workspace/src/code.google.com/p/goprotobuf/proto"
+)
+
+// DappStore is a dapps.ContentStore on top of the ipfs module. It is kept apart
+// from IpfsModule because it reads and writes local directories, and the module
+// is bound to the javascript runtime.
+type DappStore struct {
+	ipfs *Ipfs
+}
+
+func NewDappStore(mod *IpfsModule) *DappStore {
+	return &DappStore{mod.ipfs}
+}
+
+var _ dapps.ContentStore = (*DappStore)(nil)
+
+func (ds *DappStore) PushTree(dir string) (string, error) {
+	return ds.ipfs.PushTree(dir, -1)
+}
+
+func (ds *DappStore) FetchTree(hash, dir string) error {
+	fpath, err := hexPath2B58(hash)
+	if err != nil {
+		return err
+	}
+	nd, err := ds.ipfs.node.Resolver.ResolvePath(fpath)
+	if err != nil {
+		return err
+	}
+	if !isDir(nd) {
+		return fmt.Errorf("%s is not a directory", hash)
+	}
+	return ds.fetch(nd, dir)
+}
+
+func isDir(nd *mdag.Node) bool {
+	pb := new(ftpb.Data)
+	if err := proto.Unmarshal(nd.Data, pb); err != nil {
+		return false
+	}
+	return pb.GetType() == ftpb.Data_Directory
+}
+
+func (ds *DappStore) fetch(nd *mdag.Node, target string) error {
+	if !isDir(nd) {
+		return ds.writeFile(nd, target)
+	}
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+	for _, link := range nd.Links {
+		if link.Name == "" || link.Name == "." || link.Name == ".." || strings.ContainsAny(link.Name, `/\`) {
+			return fmt.Errorf("Invalid name in tree: '%s'", link.Name)
+		}
+		child, err := ds.ipfs.node.DAG.Get(util.Key(link.Hash))
+		if err != nil {
+			return err
+		}
+		if err := ds.fetch(child, filepath.Join(target, link.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ds *DappStore) writeFile(nd *mdag.Node, target string) error {
+	r, err := uio.NewDagReader(nd, ds.ipfs.node.DAG)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, r)
+	return err
+}
@@ -11,6 +11,7 @@ import (
 	"strings"
 	"time"
 
+	deconfig "github.com/eris-ltd/decerver-interfaces/config"
 	decore "github.com/eris-ltd/decerver-interfaces/core"
 	events "github.com/eris-ltd/decerver-interfaces/events"
 	"github.com/eris-ltd/decerver-interfaces/modules"
@@ -54,7 +55,8 @@ func (mod *IpfsModule) Register(fileIO decore.FileIO, rm decore.RuntimeManager,
 func NewIpfs() *IpfsModule {
 	ii := new(IpfsModule)
 	i := new(Ipfs)
-	ii.Config = DefaultConfig
+	cfg := *DefaultConfig
+	ii.Config = &cfg
 	ii.ipfs = i
 	return ii
 }
@@ -121,23 +123,61 @@ func (mod *IpfsModule) Restart() error {
 	return mod.Start()
 }
 
-func (mod *IpfsModule) SetProperty(name string, data interface{}) {
+// Health implements modules.HealthChecker.
+func (mod *IpfsModule) Health() *modules.Health {
+	n := mod.ipfs.node
+	if n == nil {
+		return &modules.Health{State: modules.HealthFailed, Details: "ipfs node is not running"}
+	}
+	if mod.Config.Online && n.Network == nil {
+		return &modules.Health{State: modules.HealthDegraded, Details: "ipfs node has no network"}
+	}
+	return &modules.Health{State: modules.HealthReady, Details: ""}
+}
+
+func (mod *IpfsModule) SetProperty(name string, data interface{}) error {
+	return fmt.Errorf("No property named: %s", name)
+}
+
+// Set a field of the module config (not the go-ipfs config).
+func (mod *IpfsModule) SetConfig(field string, value interface{}) error {
+	return deconfig.Set(mod.Config, field, value)
 }
 
 func (mod *IpfsModule) Property(name string) interface{} {
 	return nil
 }
 
-func (mod *IpfsModule) ReadConfig(config_file string) {
+// Read the module config. The go-ipfs config in RootDir is loaded by Init.
+func (mod *IpfsModule) ReadConfig(config_file string) error {
+	loader := &deconfig.Loader{
+		Defaults:       DefaultConfig,
+		File:           config_file,
+		WriteIfMissing: true,
+		EnvPrefix:      ENV_PREFIX,
+	}
+	return loader.Load(mod.Config)
 }
 
-func (mod *IpfsModule) WriteConfig(config_file string) {
+func (mod *IpfsModule) WriteConfig(config_file string) error {
+	return deconfig.Write(mod.Config, config_file)
 }
 
 func (mod *IpfsModule) Name() string {
 	return "ipfs"
 }
 
+// Same as package.json. Dapps depend on this version.
+func (mod *IpfsModule) Info() *modules.ModuleInfo {
+	return &modules.ModuleInfo{
+		Name:       "ipfs",
+		Version:    "0.8.0",
+		Author:     &modules.AuthorInfo{Name: "Eris Industries, Ltd.", EMail: "contact@erisindustries.com"},
+		Licence:    "MIT",
+		Repository: "git://github.com/eris-ltd/decerver-interfaces",
+	}
+}
+
 func (mod *IpfsModule) Subscribe(name string, event string, target string) chan events.Event {
 	return mod.ipfs.Subscribe(name, event, target)
 }
@@ -531,7 +571,7 @@ func grabRefs(n *core.IpfsNode, nd *mdag.Node, tree modules.JsObject) error {
 			return err
 		}
 		nds := tree["Nodes"].([]modules.JsObject)
-		nds = append(nds, newNode)
+		tree["Nodes"] = append(nds, newNode)
 	}
 	return nil
 }
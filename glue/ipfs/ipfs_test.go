@@ -8,11 +8,12 @@ import (
 	"time"
 	//"path"
 	modules "github.com/eris-ltd/decerver-interfaces/modules"
+	mh "github.com/eris-ltd/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multihash"
 	"testing"
 )
 
 var (
-	IPFS = start(true) // offline
+	IPFS = start(false) // offline
 
 	block = `here is a block of data to push. it is a modest size amount.
     not too much data, but not too little.
@@ -185,6 +186,35 @@ func TestTree(t *testing.T) {
 	cmpTree(t, tr, &tree)
 }
 
+func TestGetTreeNested(t *testing.T) {
+	mkTree(t, &tree, ".")
+	defer rmTree(t, tree.Name)
+	h, err := IPFS.ipfs.PushTree(tree.Name, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr, err := IPFS.ipfs.GetTree(h, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirs := tr["Nodes"].([]modules.JsObject)
+	if len(dirs) != len(tree.Nodes) {
+		t.Fatalf("Expected %d nodes, got %d", len(tree.Nodes), len(dirs))
+	}
+	for i, dir := range dirs {
+		files := dir["Nodes"].([]modules.JsObject)
+		if dir["Name"] != tree.Nodes[i].Name || len(files) != 1 || files[0]["Name"] != tree.Nodes[i].Nodes[0].Name {
+			t.Fatalf("Bad node %d: %v", i, dir)
+		}
+		// Child hashes are base58, as they always were.
+		for _, nd := range []modules.JsObject{dir, files[0]} {
+			if _, err := mh.FromB58String(nd["Hash"].(string)); err != nil {
+				t.Errorf("Hash of %s is not base58: %v", nd["Name"], nd["Hash"])
+			}
+		}
+	}
+}
+
 func TestModule(t *testing.T) {
 	// test IpfsModule satisfies DecerverModule
 	f := func(b modules.Module) {}
@@ -196,6 +226,31 @@ func TestModule(t *testing.T) {
 	g(IPFS.ipfs)
 }
 
+func TestDappStore(t *testing.T) {
+	mkTree(t, &tree, ".")
+	defer rmTree(t, tree.Name)
+	store := NewDappStore(IPFS)
+	h, err := store.PushTree(tree.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "dappstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rmTree(t, dir)
+	if err := store.FetchTree(h, dir); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(dir + "/bar/block.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != block {
+		t.Errorf("Expected: %s, Got: %s", block, string(b))
+	}
+}
+
 func TestShutdown(t *testing.T) {
 	IPFS.Shutdown()
 	time.Sleep(time.Second * 5)
@@ -0,0 +1,35 @@
+package legalmarkdown
+
+import (
+	"github.com/eris-ltd/decerver-interfaces/config"
+)
+
+// Environment variables like DECERVER_LMD_VERBOSE override the config file.
+const ENV_PREFIX = "DECERVER_LMD"
+
+type LmdConfig struct {
+	// Print the templates and the results of compiling them.
+	Verbose bool `json:"verbose"`
+}
+
+var DefaultConfig = &LmdConfig{}
+
+func (mod *LmdModule) WriteConfig(config_file string) error {
+	return config.Write(mod.Config, config_file)
+}
+
+// Load defaults, the config file and the environment (see config.Loader).
+// The file is created if it does not exist.
+func (mod *LmdModule) ReadConfig(config_file string) error {
+	loader := &config.Loader{
+		Defaults:       DefaultConfig,
+		File:           config_file,
+		WriteIfMissing: true,
+		EnvPrefix:      ENV_PREFIX,
+	}
+	return loader.Load(mod.Config)
+}
+
+func (mod *LmdModule) SetConfig(field string, value interface{}) error {
+	return config.Set(mod.Config, field, value)
+}
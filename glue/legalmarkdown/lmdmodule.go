@@ -9,7 +9,8 @@ import (
 )
 
 type LmdApi struct {
-	name string
+	name   string
+	config *LmdConfig
 }
 
 // pass two strings after reading a template file and an optional
@@ -20,10 +21,15 @@ type LmdApi struct {
 // the returned string will be a PDF which can be written or
 // displayed by an PDF reader.
 func (lmda *LmdApi) Compile(contents, params string) modules.JsObject {
-	fmt.Println("Contents: " + contents)
-	fmt.Println("Params: " + params)
+	verbose := lmda.config != nil && lmda.config.Verbose
+	if verbose {
+		fmt.Println("Contents: " + contents)
+		fmt.Println("Params: " + params)
+	}
 	res := lmd.RawMarkdownToPDF(contents, params)
-	fmt.Println("Nice... " + res)
+	if verbose {
+		fmt.Println("Nice... " + res)
+	}
 
 	//return modules.JsReturnValNoErr(res);
 	return nil
@@ -31,12 +37,14 @@ func (lmda *LmdApi) Compile(contents, params string) modules.JsObject {
 
 // implements decerver-interface module
 type LmdModule struct {
-	api *LmdApi
+	Config *LmdConfig
+	api    *LmdApi
 }
 
 func NewLmdModule() *LmdModule {
-	lmdApi := &LmdApi{}
-	return &LmdModule{lmdApi}
+	cfg := *DefaultConfig
+	lmdApi := &LmdApi{config: &cfg}
+	return &LmdModule{&cfg, lmdApi}
 }
 
 func (mod *LmdModule) Register(fileIO core.FileIO, rm core.RuntimeManager, eReg events.EventRegistry) error {
@@ -60,23 +68,31 @@ func (mod *LmdModule) Restart() error {
 	return nil
 }
 
-func (mod *LmdModule) SetProperty(name string, data interface{}) {
+func (mod *LmdModule) SetProperty(name string, data interface{}) error {
+	return fmt.Errorf("No property named: %s", name)
 }
 
 func (mod *LmdModule) Property(name string) interface{} {
 	return nil
 }
 
-func (mod *LmdModule) ReadConfig(config_file string) {
-}
-
-func (mod *LmdModule) WriteConfig(config_file string) {
-}
+// ReadConfig and WriteConfig implemented in config.go
 
 func (mod *LmdModule) Name() string {
 	return "lmd"
 }
 
+// Info implements modules.Versioned, so dapps can depend on a version range.
+func (mod *LmdModule) Info() *modules.ModuleInfo {
+	return &modules.ModuleInfo{
+		Name:       "lmd",
+		Version:    "0.8.0",
+		Author:     &modules.AuthorInfo{Name: "Eris Industries, Ltd.", EMail: "contact@erisindustries.com"},
+		Licence:    "MIT",
+		Repository: "git://github.com/eris-ltd/decerver-interfaces",
+	}
+}
+
 func (mod *LmdModule) Subscribe(name string, event string, target string) chan events.Event {
 	return nil
 }
@@ -2,27 +2,31 @@ package monkjs
 
 import (
 	"fmt"
+	"github.com/eris-ltd/decerver-interfaces/blockchain"
 	"github.com/eris-ltd/decerver-interfaces/core"
 	"github.com/eris-ltd/decerver-interfaces/events"
 	"github.com/eris-ltd/decerver-interfaces/modules"
 	"github.com/eris-ltd/thelonious/monk"
 )
 
-type TempProps struct {
-	ChainId    string
-	RemoteHost string
-	RemotePort int
+// Properties are injected into the monk module when it is restarted.
+var propertySpecs = []*modules.PropertySpec{
+	{Name: "ChainId", Type: modules.PropString, Description: "Id of the chain to run.", Restart: true},
+	{Name: "RemoteHost", Type: modules.PropString, Description: "Host of the peer server.", Restart: true},
+	{Name: "RemotePort", Type: modules.PropInt, Description: "Port of the peer server.", Restart: true, Validate: modules.ValidatePort},
 }
 
+var _ modules.StatePager = (*MonkJs)(nil)
+
 // implements decerver-interfaces Module
 type MonkJs struct {
-	mm   *monk.MonkModule
-	temp *TempProps
+	mm    *monk.MonkModule
+	props *modules.Properties
 }
 
 func NewMonkJs() *MonkJs {
 	monkModule := monk.NewMonk(nil)
-	return &MonkJs{monkModule, &TempProps{}}
+	return &MonkJs{monkModule, modules.NewProperties(propertySpecs...)}
 }
 
 // register the module with the decerver javascript vm
@@ -57,51 +61,27 @@ func (mjs *MonkJs) Restart() error {
 	mjs.mm = monk.NewMonk(nil)
 
 	// Inject the config:
-	mjs.mm.SetProperty("ChainId", mjs.temp.ChainId)
-	mjs.mm.SetProperty("RemoteHost", mjs.temp.RemoteHost)
-	mjs.mm.SetProperty("RemotePort", mjs.temp.RemotePort)
+	mjs.mm.SetProperty("ChainId", mjs.props.String("ChainId"))
+	mjs.mm.SetProperty("RemoteHost", mjs.props.String("RemoteHost"))
+	mjs.mm.SetProperty("RemotePort", mjs.props.Int("RemotePort"))
 
 	mjs.mm.Init()
 
-	err2 := mjs.mm.Start()
-
-	mjs.temp.ChainId = ""
-	mjs.temp.RemoteHost = ""
-	mjs.temp.RemotePort = 0
-
-	return err2
+	return mjs.mm.Start()
 }
 
-func (mjs *MonkJs) SetProperty(name string, data interface{}) {
-	if name == "ChainId" {
-		dt, dtok := data.(string)
-		if !dtok {
-			fmt.Println("Setting property 'ChainId' to an undefined value. Should be string")
-			return
-		}
-		mjs.temp.ChainId = dt
-	} else if name == "RemoteHost" {
-		dt2, dtok2 := data.(string)
-		if !dtok2 {
-			fmt.Println("Setting property 'RemoteHost' to an undefined value. Should be string")
-			return
-		}
-		mjs.temp.RemoteHost = dt2
-	} else if name == "RemotePort" {
-		dt3, dtok3 := data.(int)
-		if !dtok3 {
-			fmt.Println("Setting property 'RemotePort' to an undefined value. Should be int")
-			return
-		}
-		mjs.temp.RemotePort = dt3
-	} else {
-		fmt.Println("Setting undefined property.")
-	}
-
+// Values from javascript are coerced, so RemotePort can be a float64.
+// They take effect on Restart.
+func (mjs *MonkJs) SetProperty(name string, data interface{}) error {
+	return mjs.props.Set(name, data)
 }
 
 func (mjs *MonkJs) Property(name string) interface{} {
-	return nil
+	return mjs.props.Get(name)
+}
+
+func (mjs *MonkJs) PropertySpecs() []*modules.PropertySpec {
+	return mjs.props.Specs()
 }
 
 // ReadConfig and WriteConfig implemented in config.go
@@ -111,6 +91,17 @@ func (mjs *MonkJs) Name() string {
 	return "monk"
 }
 
+// Info implements modules.Versioned, so dapps can depend on a version range.
+func (mjs *MonkJs) Info() *modules.ModuleInfo {
+	return &modules.ModuleInfo{
+		Name:       "monk",
+		Version:    "0.8.0",
+		Author:     &modules.AuthorInfo{Name: "Eris Industries, Ltd.", EMail: "contact@erisindustries.com"},
+		Licence:    "MIT",
+		Repository: "git://github.com/eris-ltd/decerver-interfaces",
+	}
+}
+
 func (mjs *MonkJs) Subscribe(name, event, target string) chan events.Event {
 	return mjs.mm.Subscribe(name, event, target)
 }
@@ -270,6 +261,35 @@ func (mjs *MonkJs) AddressCount() modules.JsObject {
 	return modules.JsReturnValNoErr(mjs.mm.AddressCount())
 }
 
+/*
+   Implement StatePager. The pages are cut from the state of the monk module, so
+   only one page is converted to a JsObject (and sent to javascript).
+*/
+
+func (mjs *MonkJs) WorldStatePage(cursor string, limit int) modules.JsObject {
+	page, err := blockchain.PageWorldState(mjs.mm.WorldState(), cursor, limit)
+	if err != nil {
+		return modules.JsReturnValErr(err)
+	}
+	return modules.JsReturnVal(modules.ToMap(page), nil)
+}
+
+func (mjs *MonkJs) StatePage(cursor string, limit int) modules.JsObject {
+	page, err := blockchain.PageState(mjs.mm.State(), cursor, limit)
+	if err != nil {
+		return modules.JsReturnValErr(err)
+	}
+	return modules.JsReturnVal(modules.ToMap(page), nil)
+}
+
+func (mjs *MonkJs) StoragePage(target, cursor string, limit int) modules.JsObject {
+	page, err := blockchain.PageStorage(target, mjs.mm.Storage(target), cursor, limit)
+	if err != nil {
+		return modules.JsReturnValErr(err)
+	}
+	return modules.JsReturnVal(modules.ToMap(page), nil)
+}
+
 var eslScript string = `
 
 var StdVarOffset = "0x1";
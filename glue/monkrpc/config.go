@@ -1,24 +1,24 @@
 package monkrpc
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
+	"github.com/eris-ltd/decerver-interfaces/config"
 	mutils "github.com/eris-ltd/decerver-interfaces/glue/monkutils"
 	"github.com/eris-ltd/decerver-interfaces/glue/utils"
 	"github.com/eris-ltd/thelonious/monkutil"
-	"io/ioutil"
 	"os"
 	"path"
-	"reflect"
 )
 
 var ErisLtd = utils.ErisLtd
 
+// e.g. DECERVER_MONKRPC_RPC_PORT=30305
+const ENV_PREFIX = "DECERVER_MONKRPC"
+
 type RpcConfig struct {
 	// Networking
 	RpcHost string `json:"rpc_host"`
-	RpcPort int    `json:"rpc_port"`
+	RpcPort int    `json:"rpc_port" validate:"port"`
 
 	// If true, key management is handled
 	// by the server (presumably on a local machine)
@@ -28,11 +28,11 @@ type RpcConfig struct {
 	// Only relevant if Local is false
 	KeySession string `json:"key_session"`
 	KeyStore   string `json:"key_store"`
-	KeyCursor  int    `json:"key_cursor"`
+	KeyCursor  int    `json:"key_cursor" validate:"min=0"`
 	KeyFile    string `json:"key_file"`
 
 	// Paths
-	RootDir      string `json:"root_dir"`
+	RootDir      string `json:"root_dir" validate:"required"`
 	DbName       string `json:"db_name"`
 	LLLPath      string `json:"lll_path"`
 	ContractPath string `json:"contract_path"`
@@ -40,7 +40,7 @@ type RpcConfig struct {
 	// Logs
 	LogFile   string `json:"log_file"`
 	DebugFile string `json:"debug_file"`
-	LogLevel  int    `json:"log_level"`
+	LogLevel  int    `json:"log_level" validate:"min=0,max=5"`
 }
 
 // set default config object
@@ -70,55 +70,25 @@ var DefaultConfig = &RpcConfig{
 }
 
 // Marshal the current configuration to file in pretty json.
-func (mod *MonkRpcModule) WriteConfig(config_file string) {
-	b, err := json.Marshal(mod.Config)
-	if err != nil {
-		fmt.Println("error marshalling config:", err)
-		return
-	}
-	var out bytes.Buffer
-	json.Indent(&out, b, "", "\t")
-	ioutil.WriteFile(config_file, out.Bytes(), 0600)
+func (mod *MonkRpcModule) WriteConfig(config_file string) error {
+	return config.Write(mod.Config, config_file)
 }
 
-// Unmarshal the configuration file into module's config struct.
-func (mod *MonkRpcModule) ReadConfig(config_file string) {
-	b, err := ioutil.ReadFile(config_file)
-	if err != nil {
-		fmt.Println("could not read config", err)
-		fmt.Println("resorting to defaults")
-		mod.WriteConfig(config_file)
-		return
-	}
-	var config RpcConfig
-	err = json.Unmarshal(b, &config)
-	if err != nil {
-		fmt.Println("error unmarshalling config from file:", err)
-		fmt.Println("resorting to defaults")
-		return
+// Layer the configuration file and the environment over the defaults. A file that
+// can not be read or has invalid fields is an error, and is left alone.
+func (mod *MonkRpcModule) ReadConfig(config_file string) error {
+	loader := &config.Loader{
+		Defaults:       DefaultConfig,
+		File:           config_file,
+		WriteIfMissing: true,
+		EnvPrefix:      ENV_PREFIX,
 	}
-	*(mod.Config) = config
+	return loader.Load(mod.Config)
 }
 
-// Set a field in the config struct.
+// Set a field in the config struct (converted and validated, see config.Set).
 func (mod *MonkRpcModule) SetConfig(field string, value interface{}) error {
-	cv := reflect.ValueOf(mod.Config).Elem()
-	f := cv.FieldByName(field)
-	kind := f.Kind()
-
-	k := reflect.ValueOf(value).Kind()
-	if kind != k {
-		return fmt.Errorf("Invalid kind. Expected %s, received %s", kind, k)
-	}
-
-	if kind == reflect.String {
-		f.SetString(value.(string))
-	} else if kind == reflect.Int {
-		f.SetInt(int64(value.(int)))
-	} else if kind == reflect.Bool {
-		f.SetBool(value.(bool))
-	}
-	return nil
+	return config.Set(mod.Config, field, value)
 }
 
 // Set the config object directly
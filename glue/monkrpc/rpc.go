@@ -45,7 +45,8 @@ type MonkRpcModule struct {
 // Create a new rpc module
 func NewMonkRpcModule() *MonkRpcModule {
 	g := new(MonkRpcModule)
-	g.Config = DefaultConfig
+	cfg := *DefaultConfig
+	g.Config = &cfg
 	return g
 }
 
@@ -58,7 +59,8 @@ func (mod *MonkRpcModule) Register(fileIO core.FileIO, rm core.RuntimeManager, e
 func (mod *MonkRpcModule) Init() error {
 	// if didn't call NewMonkRpcModule
 	if mod.Config == nil {
-		mod.Config = DefaultConfig
+		cfg := *DefaultConfig
+		mod.Config = &cfg
 	}
 
 	mod.rConfig()
@@ -100,6 +102,17 @@ func (mod *MonkRpcModule) Name() string {
 	return "genblock"
 }
 
+// Info implements modules.Versioned, so dapps can depend on a version range.
+func (mod *MonkRpcModule) Info() *modules.ModuleInfo {
+	return &modules.ModuleInfo{
+		Name:       "monkrpc",
+		Version:    "0.8.0",
+		Author:     &modules.AuthorInfo{Name: "Eris Industries, Ltd.", EMail: "contact@erisindustries.com"},
+		Licence:    "MIT",
+		Repository: "git://github.com/eris-ltd/decerver-interfaces",
+	}
+}
+
 /*
    Implement Blockchain
 */
@@ -12,6 +12,7 @@ import (
 	"path"
 	"strings"
 
+	"github.com/eris-ltd/decerver-interfaces/core"
 	"github.com/eris-ltd/decerver-interfaces/dapps"
 	"github.com/eris-ltd/thelonious/monklog"
 )
@@ -131,6 +132,7 @@ func InitDecerverDir() error {
 	return err
 }
 
+// Write a value as indented json. The file is replaced atomically.
 func WriteJson(config interface{}, config_file string) error {
 	b, err := json.Marshal(config)
 	if err != nil {
@@ -141,8 +143,7 @@ func WriteJson(config interface{}, config_file string) error {
 	if err != nil {
 		return err
 	}
-	err = ioutil.WriteFile(config_file, out.Bytes(), 0600)
-	return err
+	return core.WriteFileAtomic(config_file, out.Bytes(), 0600)
 }
 
 func ChainIdFromName(name string) string {
@@ -55,6 +55,26 @@ type (
 		Order    []string
 	}
 
+	// Pages of WorldState, State and Storage. Next is the cursor of the next
+	// page, and empty on the last one.
+	WorldStatePage struct {
+		Accounts map[string]*Account
+		Order    []string
+		Next     string
+	}
+
+	StatePage struct {
+		State map[string]*Storage
+		Order []string
+		Next  string
+	}
+
+	StoragePage struct {
+		Storage map[string]string
+		Order   []string
+		Next    string
+	}
+
 	BlockMini struct {
 		Number           string
 		Hash             string
@@ -139,6 +159,38 @@ type (
 		Error    string
 	}
 
+	// Changes to the world state between two blocks. Accounts are in the order
+	// of the world state they are in.
+	StateDiff struct {
+		FromBlock string
+		ToBlock   string
+		Created   []*AccountDiff
+		Deleted   []*AccountDiff
+		Modified  []*AccountDiff
+	}
+
+	// Changes to an account. Old values are empty for created accounts, and new
+	// values are empty for deleted ones. Deltas are new minus old, in decimal, and
+	// empty if the values are not numbers.
+	AccountDiff struct {
+		Address      string
+		Contract     bool
+		OldBalance   string
+		Balance      string
+		BalanceDelta string
+		OldNonce     string
+		Nonce        string
+		NonceDelta   string
+		Storage      []*StorageDelta
+	}
+
+	// A storage slot that was added (empty OldValue), removed (empty Value) or changed.
+	StorageDelta struct {
+		Key      string
+		OldValue string
+		Value    string
+	}
+
 	AccountMini struct {
 		// Modified (0), Added (1), Deleted(2)
 		Flag     int
@@ -185,6 +237,29 @@ func ToMap(obj interface{}) map[string]interface{} {
 		}
 		mp["Accounts"] = stmp
 		break
+	case *WorldStatePage:
+		mp["Order"] = o.Order
+		mp["Next"] = o.Next
+		stmp := make(map[string]map[string]interface{})
+		for k, v := range o.Accounts {
+			stmp[k] = ToMap(v)
+		}
+		mp["Accounts"] = stmp
+		break
+	case *StatePage:
+		mp["Order"] = o.Order
+		mp["Next"] = o.Next
+		stmp := make(map[string]map[string]interface{})
+		for k, v := range o.State {
+			stmp[k] = ToMap(v)
+		}
+		mp["State"] = stmp
+		break
+	case *StoragePage:
+		mp["Order"] = o.Order
+		mp["Next"] = o.Next
+		mp["Storage"] = o.Storage
+		break
 	case *AccountMini:
 		mp["Address"] = o.Address
 		mp["Balance"] = o.Balance
@@ -223,6 +298,12 @@ func ToMap(obj interface{}) map[string]interface{} {
 			txmp[k] = ToMap(v)
 		}
 		break
+	case *StorageChange:
+		mp["Address"] = o.Address
+		mp["Storage"] = o.Storage
+		mp["OldValue"] = o.OldValue
+		mp["Value"] = o.Value
+		break
 	case *Transaction:
 		mp["BlockHash"] = o.BlockHash
 		mp["ContractCreation"] = o.ContractCreation
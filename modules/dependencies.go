@@ -0,0 +1,122 @@
+package modules
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/eris-ltd/decerver-interfaces/dapps"
+)
+
+// Modules implement this to tell dapps which version they are. A module that
+// does not only satisfies dependencies that accept any version.
+type Versioned interface {
+	Info() *ModuleInfo
+}
+
+// The version of a module, or "" if it does not have one.
+func ModuleVersion(m Module) string {
+	if vm, ok := m.(Versioned); ok {
+		if info := vm.Info(); info != nil {
+			return info.Version
+		}
+	}
+	return ""
+}
+
+// A module dependency that is not met.
+type DependencyProblem struct {
+	Module string `json:"module"`
+	// The version range from the package file.
+	Range string `json:"range"`
+	// The version of the installed module, if there is one.
+	Installed string `json:"installed"`
+	Reason    string `json:"reason"`
+}
+
+// DependencyError lists every module dependency of a dapp that is not met.
+type DependencyError struct {
+	Dapp     string
+	Problems []*DependencyProblem
+}
+
+func (de *DependencyError) Error() string {
+	buf := &bytes.Buffer{}
+	if de.Dapp != "" {
+		fmt.Fprintf(buf, "Dapp '%s' has unmet module dependencies:", de.Dapp)
+	} else {
+		buf.WriteString("Unmet module dependencies:")
+	}
+	for _, p := range de.Problems {
+		fmt.Fprintf(buf, "\n  %s %s: %s", p.Module, p.Range, p.Reason)
+	}
+	return buf.String()
+}
+
+// ResolveDependencies checks module dependencies against the given modules. If any
+// are missing or have a version outside the range, a *DependencyError with all of
+// them is returned.
+func ResolveDependencies(mods map[string]Module, deps []*dapps.ModuleDependency) error {
+	problems := make([]*DependencyProblem, 0)
+	for _, dep := range deps {
+		if dep == nil {
+			continue
+		}
+		p := &DependencyProblem{Module: dep.Name, Range: dep.Version}
+		if p.Range == "" {
+			p.Range = "*"
+		}
+		if dep.Name == "" {
+			p.Module = "<unnamed>"
+			p.Reason = "dependency has no module name"
+			problems = append(problems, p)
+			continue
+		}
+		vr, err := ParseVersionRange(dep.Version)
+		if err != nil {
+			p.Reason = err.Error()
+			problems = append(problems, p)
+			continue
+		}
+		m, ok := mods[dep.Name]
+		if !ok || m == nil {
+			p.Reason = "not installed"
+			problems = append(problems, p)
+			continue
+		}
+		p.Installed = ModuleVersion(m)
+		if p.Installed == "" {
+			if !vr.Any() {
+				p.Reason = "installed module has no version"
+				problems = append(problems, p)
+			}
+			continue
+		}
+		v, err := ParseVersion(p.Installed)
+		if err != nil {
+			p.Reason = fmt.Sprintf("installed module has an invalid version: %s", err)
+			problems = append(problems, p)
+			continue
+		}
+		if !vr.Contains(v) {
+			p.Reason = fmt.Sprintf("version %s is installed", v)
+			problems = append(problems, p)
+		}
+	}
+	if len(problems) > 0 {
+		return &DependencyError{Problems: problems}
+	}
+	return nil
+}
+
+// CheckDapp resolves the module dependencies of a dapp. Dapp registries call this
+// from LoadDapp, before anything is loaded.
+func CheckDapp(reg ModuleRegistry, pf *dapps.PackageFile) error {
+	err := reg.ResolveDependencies(pf.ModuleDependencies)
+	if de, ok := err.(*DependencyError); ok {
+		de.Dapp = pf.Id
+		if de.Dapp == "" {
+			de.Dapp = pf.Name
+		}
+	}
+	return err
+}
@@ -0,0 +1,64 @@
+package modules
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/eris-ltd/decerver-interfaces/dapps"
+)
+
+type versionedModule struct {
+	testModule
+	version string
+}
+
+func (vm *versionedModule) Info() *ModuleInfo {
+	return &ModuleInfo{Name: vm.name, Version: vm.version}
+}
+
+func TestResolveDependencies(t *testing.T) {
+	log := &callLog{}
+	lm := NewLifecycleManager()
+	lm.Add(&versionedModule{testModule{name: "monk", log: log}, "0.8.2"})
+	lm.Add(&versionedModule{testModule{name: "ipfs", log: log}, "1.1.0"})
+	lm.Add(&testModule{name: "lmd", log: log})
+
+	ok := []*dapps.ModuleDependency{
+		{Name: "monk", Version: "^0.8"},
+		{Name: "ipfs", Version: ">=1 <2"},
+		{Name: "lmd"},
+	}
+	if err := lm.ResolveDependencies(ok); err != nil {
+		t.Fatal(err)
+	}
+
+	pf := &dapps.PackageFile{Id: "mydapp", ModuleDependencies: []*dapps.ModuleDependency{
+		{Name: "monk", Version: "^0.9"},
+		{Name: "ipfs", Version: ">=1.2 <2"},
+		{Name: "eth", Version: "^1"},
+		{Name: "lmd", Version: "1.x"},
+		{Name: "monk", Version: "^^1"},
+	}}
+	err := CheckDapp(lm, pf)
+	de, isDe := err.(*DependencyError)
+	if !isDe {
+		t.Fatalf("Expected a *DependencyError, got: %v", err)
+	}
+	if de.Dapp != "mydapp" || len(de.Problems) != 5 {
+		t.Fatalf("Wrong report: %s", de)
+	}
+	expected := []string{
+		"Dapp 'mydapp' has unmet module dependencies:",
+		"monk ^0.9: version 0.8.2 is installed",
+		"ipfs >=1.2 <2: version 1.1.0 is installed",
+		"eth ^1: not installed",
+		"lmd 1.x: installed module has no version",
+		"monk ^^1: Invalid version range",
+	}
+	report := de.Error()
+	for _, s := range expected {
+		if !strings.Contains(report, s) {
+			t.Errorf("Expected '%s' in report:\n%s", s, report)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package modules
+
+import (
+	"github.com/eris-ltd/decerver-interfaces/events"
+)
+
+// The canonical resources of the standard events. Modules must post these
+// types (see events.Validate), so that dapps get the same shape from every
+// blockchain and filesystem.
+//
+//	newBlock       *Block
+//	newTx          *Transaction
+//	txFailed       *Transaction (with Error set)
+//	storageChanged *StorageChange
+//	addressChanged *Transaction (the tx that touched the address)
+//	fileAdded      *FsNode
+//
+// The module supervisor posts a *ModuleEvent with moduleReady, moduleDegraded,
+// moduleFailed, moduleRestarting, moduleRestarted and moduleRestartFailed.
+func init() {
+	events.RegisterKind(events.EVENT_NEW_BLOCK, "A block was added to the chain.", &Block{})
+	events.RegisterKind(events.EVENT_NEW_TX, "A transaction was accepted.", &Transaction{})
+	events.RegisterKind(events.EVENT_TX_FAILED, "A transaction failed. The reason is in 'Error'.", &Transaction{})
+	events.RegisterKind(events.EVENT_STORAGE_CHANGED, "A storage slot of an account was changed.", &StorageChange{})
+	events.RegisterKind(events.EVENT_ADDRESS_CHANGED, "A transaction touched the target address.", &Transaction{})
+	events.RegisterKind(events.EVENT_FILE_ADDED, "A file or directory tree was added to the filesystem.", &FsNode{})
+
+	events.RegisterKind(events.EVENT_MODULE_READY, "A module is healthy again.", &ModuleEvent{})
+	events.RegisterKind(events.EVENT_MODULE_DEGRADED, "A module works, but not fully.", &ModuleEvent{})
+	events.RegisterKind(events.EVENT_MODULE_FAILED, "A module stopped working.", &ModuleEvent{})
+	events.RegisterKind(events.EVENT_MODULE_RESTARTING, "A failed module is about to be restarted.", &ModuleEvent{})
+	events.RegisterKind(events.EVENT_MODULE_RESTARTED, "A failed module was restarted.", &ModuleEvent{})
+	events.RegisterKind(events.EVENT_MODULE_RESTART_FAILED, "Restarting a failed module returned an error.", &ModuleEvent{})
+}
+
+// Resource of a 'storageChanged' event.
+type StorageChange struct {
+	Address string
+	Storage string
+	// Empty if the slot was not set before.
+	OldValue string
+	// Empty if the slot was cleared.
+	Value string
+}
@@ -0,0 +1,276 @@
+package modules
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/eris-ltd/decerver-interfaces/events"
+)
+
+// Source of the events posted by the supervisor.
+const SUPERVISOR_SOURCE = "supervisor"
+
+type HealthState int
+
+const (
+	HealthReady HealthState = iota
+	// Running, but not fully working (e.g. no peers, or a helper process died).
+	HealthDegraded
+	// Not working. The supervisor restarts modules that are in this state.
+	HealthFailed
+)
+
+func (s HealthState) String() string {
+	switch s {
+	case HealthReady:
+		return "ready"
+	case HealthDegraded:
+		return "degraded"
+	case HealthFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+func (s HealthState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+type Health struct {
+	State   HealthState `json:"state"`
+	Details string      `json:"details"`
+}
+
+// Modules that can tell whether they still work implement this. Health should
+// be cheap, it is called periodically.
+type HealthChecker interface {
+	Health() *Health
+}
+
+// Resource of the supervisor events.
+type ModuleEvent struct {
+	Module  string
+	State   HealthState
+	Details string
+	// Restart attempt, starting at 1. 0 for health changes.
+	Attempt int
+	// Delay before the restart, for moduleRestarting.
+	Backoff time.Duration
+	// For moduleRestartFailed.
+	Error string
+}
+
+// Supervisor checks the health of modules periodically. When a module has failed it
+// is restarted, with exponential backoff between attempts (MinBackoff, 2*MinBackoff,
+// and so on up to MaxBackoff). The backoff is reset once the module is ready again.
+// Health changes and restarts are posted as events on the event registry.
+type Supervisor struct {
+	// Time between health checks.
+	Interval   time.Duration
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Give up on a module after this many restarts in a row. 0 means never.
+	MaxRestarts int
+
+	eReg    events.EventRegistry
+	mutex   *sync.Mutex
+	watched map[string]*supervised
+}
+
+type supervised struct {
+	module Module
+	health *Health
+	// Restart attempts since the module was last ready.
+	attempts int
+	// Set when the supervisor gave up on the module (see MaxRestarts).
+	gaveUp bool
+	quit   chan struct{}
+	done   chan struct{}
+}
+
+// eReg may be nil, in which case no events are posted.
+func NewSupervisor(eReg events.EventRegistry) *Supervisor {
+	s := &Supervisor{}
+	s.Interval = 5 * time.Second
+	s.MinBackoff = time.Second
+	s.MaxBackoff = 2 * time.Minute
+	s.eReg = eReg
+	s.mutex = &sync.Mutex{}
+	s.watched = make(map[string]*supervised)
+	return s
+}
+
+// Start supervising a module. It must implement HealthChecker. A module that
+// the supervisor gave up on can be watched again.
+func (s *Supervisor) Watch(m Module) error {
+	if _, ok := m.(HealthChecker); !ok {
+		return fmt.Errorf("Module '%s' does not report its health", m.Name())
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	name := m.Name()
+	if old, ok := s.watched[name]; ok && !old.gaveUp {
+		return fmt.Errorf("Module '%s' is already supervised", name)
+	}
+	w := &supervised{module: m, quit: make(chan struct{}), done: make(chan struct{})}
+	s.watched[name] = w
+	go s.supervise(w)
+	return nil
+}
+
+// Stop supervising a module. Waits for a restart that is in progress.
+func (s *Supervisor) Unwatch(name string) {
+	s.mutex.Lock()
+	w, ok := s.watched[name]
+	delete(s.watched, name)
+	s.mutex.Unlock()
+	if ok {
+		close(w.quit)
+		<-w.done
+	}
+}
+
+// Stop supervising all modules.
+func (s *Supervisor) Stop() {
+	s.mutex.Lock()
+	names := make([]string, 0, len(s.watched))
+	for name := range s.watched {
+		names = append(names, name)
+	}
+	s.mutex.Unlock()
+	for _, name := range names {
+		s.Unwatch(name)
+	}
+}
+
+// The result of the last health check of every supervised module, including
+// those the supervisor gave up on. Modules that have not been checked yet are
+// left out.
+func (s *Supervisor) Health() map[string]*Health {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ret := make(map[string]*Health)
+	for name, w := range s.watched {
+		if w.health != nil {
+			h := *w.health
+			ret[name] = &h
+		}
+	}
+	return ret
+}
+
+func (s *Supervisor) supervise(w *supervised) {
+	defer close(w.done)
+	name := w.module.Name()
+	for {
+		h := checkHealth(w.module.(HealthChecker))
+		s.mutex.Lock()
+		last := w.health
+		w.health = h
+		if h.State == HealthReady {
+			w.attempts = 0
+		}
+		s.mutex.Unlock()
+
+		if last == nil || last.State != h.State {
+			if last != nil || h.State != HealthReady {
+				s.post(healthEvent(h.State), &ModuleEvent{Module: name, State: h.State, Details: h.Details})
+			}
+		}
+
+		if h.State == HealthFailed && !s.restart(w, h) {
+			return
+		}
+
+		select {
+		case <-w.quit:
+			return
+		case <-time.After(s.Interval):
+		}
+	}
+}
+
+// Restart a failed module after the backoff. Returns false if the supervisor
+// should stop (it was told to, or it gave up).
+func (s *Supervisor) restart(w *supervised, h *Health) bool {
+	name := w.module.Name()
+	s.mutex.Lock()
+	w.attempts++
+	attempt := w.attempts
+	s.mutex.Unlock()
+	if s.MaxRestarts > 0 && attempt > s.MaxRestarts {
+		logger.Printf("Giving up on module '%s' after %d restarts\n", name, s.MaxRestarts)
+		s.mutex.Lock()
+		w.gaveUp = true
+		s.mutex.Unlock()
+		return false
+	}
+	backoff := s.backoff(attempt)
+	s.post(events.EVENT_MODULE_RESTARTING, &ModuleEvent{Module: name, State: h.State, Details: h.Details, Attempt: attempt, Backoff: backoff})
+	select {
+	case <-w.quit:
+		return false
+	case <-time.After(backoff):
+	}
+	logger.Printf("Restarting module '%s' (attempt %d)\n", name, attempt)
+	if err := safeCall(w.module.Restart); err != nil {
+		logger.Printf("Module '%s' failed to restart: %s\n", name, err)
+		s.post(events.EVENT_MODULE_RESTART_FAILED, &ModuleEvent{Module: name, State: HealthFailed, Attempt: attempt, Error: err.Error()})
+	} else {
+		s.post(events.EVENT_MODULE_RESTARTED, &ModuleEvent{Module: name, Attempt: attempt})
+	}
+	return true
+}
+
+// The delay before restart attempt n (starting at 1).
+func (s *Supervisor) backoff(n int) time.Duration {
+	d := s.MinBackoff
+	for i := 1; i < n; i++ {
+		d *= 2
+		if d >= s.MaxBackoff {
+			return s.MaxBackoff
+		}
+	}
+	if d > s.MaxBackoff {
+		return s.MaxBackoff
+	}
+	return d
+}
+
+func (s *Supervisor) post(name string, me *ModuleEvent) {
+	if s.eReg == nil {
+		return
+	}
+	s.eReg.Post(events.Event{
+		Event:     name,
+		Target:    me.Module,
+		Resource:  me,
+		Source:    SUPERVISOR_SOURCE,
+		TimeStamp: time.Now(),
+	})
+}
+
+func healthEvent(state HealthState) string {
+	switch state {
+	case HealthDegraded:
+		return events.EVENT_MODULE_DEGRADED
+	case HealthFailed:
+		return events.EVENT_MODULE_FAILED
+	}
+	return events.EVENT_MODULE_READY
+}
+
+// A panicking or silent health check counts as failed.
+func checkHealth(hc HealthChecker) (h *Health) {
+	defer func() {
+		if r := recover(); r != nil {
+			h = &Health{HealthFailed, fmt.Sprintf("Health check panicked: %v", r)}
+		}
+	}()
+	h = hc.Health()
+	if h == nil {
+		h = &Health{HealthFailed, "No health reported"}
+	}
+	return h
+}
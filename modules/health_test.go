@@ -0,0 +1,171 @@
+package modules
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eris-ltd/decerver-interfaces/events"
+)
+
+type healthModule struct {
+	testModule
+	mutex    *sync.Mutex
+	health   *Health
+	restarts int
+	// Health after a restart.
+	afterRestart *Health
+}
+
+func newHealthModule(name string) *healthModule {
+	return &healthModule{
+		testModule: testModule{name: name, log: &callLog{}},
+		mutex:      &sync.Mutex{},
+		health:     &Health{HealthReady, ""},
+	}
+}
+
+func (hm *healthModule) Health() *Health {
+	hm.mutex.Lock()
+	defer hm.mutex.Unlock()
+	return hm.health
+}
+
+func (hm *healthModule) set(h *Health) {
+	hm.mutex.Lock()
+	defer hm.mutex.Unlock()
+	hm.health = h
+}
+
+func (hm *healthModule) Restart() error {
+	hm.mutex.Lock()
+	defer hm.mutex.Unlock()
+	hm.restarts++
+	if hm.afterRestart != nil {
+		hm.health = hm.afterRestart
+	}
+	return nil
+}
+
+func nextEvent(t *testing.T, ch chan events.Event) events.Event {
+	select {
+	case e := <-ch:
+		return e
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for an event")
+	}
+	return events.Event{}
+}
+
+func expectEvents(t *testing.T, ch chan events.Event, names ...string) []*ModuleEvent {
+	mes := make([]*ModuleEvent, 0)
+	for _, name := range names {
+		e := nextEvent(t, ch)
+		if e.Event != name {
+			t.Fatalf("Expected event %s, Got: %s", name, e.Event)
+		}
+		if e.Source != SUPERVISOR_SOURCE {
+			t.Fatalf("Unexpected source: %s", e.Source)
+		}
+		mes = append(mes, e.Resource.(*ModuleEvent))
+	}
+	return mes
+}
+
+func testSupervisor() (*Supervisor, chan events.Event) {
+	ep := events.NewEventProcessor()
+	ep.Strict = true
+	sub := events.NewSubscriber("test", SUPERVISOR_SOURCE, "module*", "")
+	ep.Subscribe(sub)
+	s := NewSupervisor(ep)
+	s.Interval = 10 * time.Millisecond
+	s.MinBackoff = 5 * time.Millisecond
+	s.MaxBackoff = 20 * time.Millisecond
+	return s, sub.Channel()
+}
+
+func TestSupervisorRestart(t *testing.T) {
+	s, ch := testSupervisor()
+	defer s.Stop()
+	hm := newHealthModule("btc")
+	if err := s.Watch(hm); err != nil {
+		t.Fatal(err)
+	}
+	hm.set(&Health{HealthDegraded, "wallet exited"})
+	mes := expectEvents(t, ch, events.EVENT_MODULE_DEGRADED)
+	if mes[0].Details != "wallet exited" || mes[0].Module != "btc" {
+		t.Fatalf("Unexpected event: %v", mes[0])
+	}
+
+	// Stays failed after the first restart, then comes back.
+	hm.set(&Health{HealthFailed, "btcd exited"})
+	mes = expectEvents(t, ch,
+		events.EVENT_MODULE_FAILED,
+		events.EVENT_MODULE_RESTARTING, events.EVENT_MODULE_RESTARTED)
+	if mes[1].Attempt != 1 || mes[1].Backoff != 5*time.Millisecond {
+		t.Fatalf("Unexpected first attempt: %v", mes[1])
+	}
+	hm.mutex.Lock()
+	hm.afterRestart = &Health{HealthReady, ""}
+	hm.mutex.Unlock()
+	mes = expectEvents(t, ch,
+		events.EVENT_MODULE_RESTARTING, events.EVENT_MODULE_RESTARTED,
+		events.EVENT_MODULE_READY)
+	if mes[0].Attempt != 2 || mes[0].Backoff != 10*time.Millisecond {
+		t.Fatalf("Unexpected second attempt: %v", mes[0])
+	}
+	if h := s.Health()["btc"]; h == nil || h.State != HealthReady {
+		t.Fatalf("Expected btc to be ready, Got: %v", h)
+	}
+}
+
+func TestSupervisorGiveUp(t *testing.T) {
+	s, ch := testSupervisor()
+	defer s.Stop()
+	s.MaxRestarts = 2
+	hm := newHealthModule("ipfs")
+	hm.set(&Health{HealthFailed, "no node"})
+	s.Watch(hm)
+	expectEvents(t, ch,
+		events.EVENT_MODULE_FAILED,
+		events.EVENT_MODULE_RESTARTING, events.EVENT_MODULE_RESTARTED,
+		events.EVENT_MODULE_RESTARTING, events.EVENT_MODULE_RESTARTED)
+	time.Sleep(50 * time.Millisecond)
+	hm.mutex.Lock()
+	restarts := hm.restarts
+	hm.mutex.Unlock()
+	if restarts != 2 {
+		t.Fatalf("Expected 2 restarts, Got: %d", restarts)
+	}
+
+	// It can be watched again.
+	if h := s.Health()["ipfs"]; h == nil || h.State != HealthFailed {
+		t.Fatalf("Expected ipfs to be failed, Got: %v", h)
+	}
+	hm.set(&Health{HealthReady, ""})
+	if err := s.Watch(hm); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Watch(hm); err == nil {
+		t.Fatal("Expected an error for a module that is supervised")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	s := NewSupervisor(nil)
+	s.MinBackoff = time.Second
+	s.MaxBackoff = 5 * time.Second
+	exp := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, e := range exp {
+		if b := s.backoff(i + 1); b != e {
+			t.Errorf("Attempt %d: expected %v, Got: %v", i+1, e, b)
+		}
+	}
+}
+
+func TestWatchNeedsHealthChecker(t *testing.T) {
+	s := NewSupervisor(nil)
+	if err := s.Watch(&testModule{name: "plain"}); err == nil {
+		t.Fatal("Expected an error for a module without health checks")
+	}
+}
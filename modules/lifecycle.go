@@ -0,0 +1,359 @@
+package modules
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/eris-ltd/decerver-interfaces/core"
+	"github.com/eris-ltd/decerver-interfaces/dapps"
+	"github.com/eris-ltd/decerver-interfaces/events"
+)
+
+var logger = core.NewLogger("Modules")
+
+// Modules that depend on other modules can implement this, as an alternative
+// to passing the dependencies to LifecycleManager.Add.
+type Dependent interface {
+	// Names of the modules that must be started first.
+	Dependencies() []string
+}
+
+type ModuleState int
+
+const (
+	StatePending ModuleState = iota
+	StateRegistered
+	StateInitialized
+	StateStarted
+	StateStopped
+	StateFailed
+)
+
+func (s ModuleState) String() string {
+	switch s {
+	case StatePending:
+		return "pending"
+	case StateRegistered:
+		return "registered"
+	case StateInitialized:
+		return "initialized"
+	case StateStarted:
+		return "started"
+	case StateStopped:
+		return "stopped"
+	case StateFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+func (s ModuleState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// The state of a module, and the error that put it there (if any).
+type ModuleStatus struct {
+	Name  string      `json:"name"`
+	State ModuleState `json:"state"`
+	Error string      `json:"error"`
+}
+
+// LifecycleError is returned when starting the modules fails. It has the
+// state of every module after the rollback.
+type LifecycleError struct {
+	Module string
+	// Register, Init or Start.
+	Phase    string
+	Err      error
+	Statuses []*ModuleStatus
+}
+
+func (le *LifecycleError) Error() string {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "Module '%s' failed to %s: %s. Module states:", le.Module, le.Phase, le.Err)
+	for _, st := range le.Statuses {
+		fmt.Fprintf(buf, "\n  %s: %s", st.Name, st.State)
+		if st.Error != "" {
+			fmt.Fprintf(buf, " (%s)", st.Error)
+		}
+	}
+	return buf.String()
+}
+
+// LifecycleManager registers, initializes and starts modules in dependency order,
+// and shuts them down in reverse order. If a module fails, the modules that were
+// already started are shut down again. It is also a ModuleRegistry.
+type LifecycleManager struct {
+	mutex   *sync.Mutex
+	entries map[string]*moduleEntry
+	// Start order of the last call to Start.
+	order []string
+}
+
+type moduleEntry struct {
+	module Module
+	deps   []string
+	state  ModuleState
+	err    error
+}
+
+func NewLifecycleManager() *LifecycleManager {
+	lm := &LifecycleManager{}
+	lm.mutex = &sync.Mutex{}
+	lm.entries = make(map[string]*moduleEntry)
+	return lm
+}
+
+// Add a module, with the names of the modules it depends on. Those are added
+// to the ones it declares itself (see Dependent).
+func (lm *LifecycleManager) Add(m Module, deps ...string) error {
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
+	name := m.Name()
+	if _, ok := lm.entries[name]; ok {
+		return fmt.Errorf("Module already added: %s", name)
+	}
+	all := make([]string, 0, len(deps))
+	all = append(all, deps...)
+	if d, ok := m.(Dependent); ok {
+		all = append(all, d.Dependencies()...)
+	}
+	lm.entries[name] = &moduleEntry{module: m, deps: all}
+	return nil
+}
+
+// Order returns the module names in the order they are started: every module
+// comes after its dependencies. Modules that do not depend on each other are
+// sorted by name. Missing dependencies and cycles are errors.
+func (lm *LifecycleManager) Order() ([]string, error) {
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
+	return lm.sort()
+}
+
+func (lm *LifecycleManager) sort() ([]string, error) {
+	// Kahn's algorithm.
+	indegree := make(map[string]int)
+	dependents := make(map[string][]string)
+	for name, e := range lm.entries {
+		if _, ok := indegree[name]; !ok {
+			indegree[name] = 0
+		}
+		seen := make(map[string]bool)
+		for _, dep := range e.deps {
+			if _, ok := lm.entries[dep]; !ok {
+				return nil, fmt.Errorf("Module '%s' depends on '%s', which has not been added", name, dep)
+			}
+			if dep == name {
+				return nil, fmt.Errorf("Module '%s' depends on itself", name)
+			}
+			if seen[dep] {
+				continue
+			}
+			seen[dep] = true
+			indegree[name]++
+			dependents[dep] = append(dependents[dep], name)
+		}
+	}
+	ready := make([]string, 0)
+	for name, n := range indegree {
+		if n == 0 {
+			ready = append(ready, name)
+		}
+	}
+	order := make([]string, 0, len(lm.entries))
+	for len(ready) > 0 {
+		sort.Strings(ready)
+		name := ready[0]
+		ready = ready[1:]
+		order = append(order, name)
+		for _, dependent := range dependents[name] {
+			indegree[dependent]--
+			if indegree[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+	if len(order) != len(lm.entries) {
+		cycle := make([]string, 0)
+		for name, n := range indegree {
+			if n > 0 {
+				cycle = append(cycle, name)
+			}
+		}
+		sort.Strings(cycle)
+		return nil, fmt.Errorf("Circular module dependencies between: %v", cycle)
+	}
+	return order, nil
+}
+
+// Start registers, initializes and starts every module (that is not already started)
+// in dependency order. If one fails, every module before it that was initialized or
+// started is shut down again in reverse order, and a *LifecycleError is returned. The
+// failed module is shut down first if it was initialized (its Start failed), and is
+// left in StateFailed.
+func (lm *LifecycleManager) Start(fileIO core.FileIO, rm core.RuntimeManager, eReg events.EventRegistry) error {
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
+	order, err := lm.sort()
+	if err != nil {
+		return err
+	}
+	lm.order = order
+	for i, name := range order {
+		e := lm.entries[name]
+		if e.state == StateStarted {
+			continue
+		}
+		e.err = nil
+		phases := []struct {
+			name  string
+			f     func() error
+			state ModuleState
+		}{
+			{"Register", func() error { return e.module.Register(fileIO, rm, eReg) }, StateRegistered},
+			{"Init", e.module.Init, StateInitialized},
+			{"Start", e.module.Start, StateStarted},
+		}
+		for _, phase := range phases {
+			if err := safeCall(phase.f); err != nil {
+				logger.Printf("Module '%s' failed to %s: %s\n", name, phase.name, err)
+				if e.state == StateInitialized {
+					if err := safeCall(e.module.Shutdown); err != nil {
+						logger.Printf("Module '%s' failed to shut down: %s\n", name, err)
+					}
+				}
+				e.state = StateFailed
+				e.err = err
+				lm.rollback(order[:i])
+				return &LifecycleError{Module: name, Phase: phase.name, Err: err, Statuses: lm.statuses()}
+			}
+			e.state = phase.state
+		}
+		logger.Printf("Started module '%s'\n", name)
+	}
+	return nil
+}
+
+// Shut down the given modules in reverse order.
+func (lm *LifecycleManager) rollback(names []string) {
+	for i := len(names) - 1; i >= 0; i-- {
+		e := lm.entries[names[i]]
+		if e.state != StateInitialized && e.state != StateStarted {
+			continue
+		}
+		if err := safeCall(e.module.Shutdown); err != nil {
+			logger.Printf("Module '%s' failed to shut down: %s\n", names[i], err)
+			e.state = StateFailed
+			e.err = err
+			continue
+		}
+		e.state = StateStopped
+		logger.Printf("Rolled back module '%s'\n", names[i])
+	}
+}
+
+// Shutdown shuts down the running modules in reverse start order. Every module
+// gets shut down even if some fail; the first error is returned.
+func (lm *LifecycleManager) Shutdown() error {
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
+	var first error
+	for i := len(lm.order) - 1; i >= 0; i-- {
+		name := lm.order[i]
+		e := lm.entries[name]
+		if e.state != StateInitialized && e.state != StateStarted {
+			continue
+		}
+		if err := safeCall(e.module.Shutdown); err != nil {
+			logger.Printf("Module '%s' failed to shut down: %s\n", name, err)
+			e.state = StateFailed
+			e.err = err
+			if first == nil {
+				first = fmt.Errorf("Module '%s' failed to shut down: %s", name, err)
+			}
+			continue
+		}
+		e.state = StateStopped
+	}
+	return first
+}
+
+// The state of a single module.
+func (lm *LifecycleManager) Status(name string) (*ModuleStatus, error) {
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
+	e, ok := lm.entries[name]
+	if !ok {
+		return nil, fmt.Errorf("No module named: %s", name)
+	}
+	return e.status(name), nil
+}
+
+// The state of every module, in start order (or by name if the modules can not be sorted).
+func (lm *LifecycleManager) Statuses() []*ModuleStatus {
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
+	return lm.statuses()
+}
+
+func (lm *LifecycleManager) statuses() []*ModuleStatus {
+	order, err := lm.sort()
+	if err != nil {
+		order = lm.names()
+	}
+	sts := make([]*ModuleStatus, len(order))
+	for i, name := range order {
+		sts[i] = lm.entries[name].status(name)
+	}
+	return sts
+}
+
+func (e *moduleEntry) status(name string) *ModuleStatus {
+	st := &ModuleStatus{Name: name, State: e.state}
+	if e.err != nil {
+		st.Error = e.err.Error()
+	}
+	return st
+}
+
+func (lm *LifecycleManager) GetModules() map[string]Module {
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
+	mods := make(map[string]Module)
+	for name, e := range lm.entries {
+		mods[name] = e.module
+	}
+	return mods
+}
+
+func (lm *LifecycleManager) GetModuleNames() []string {
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
+	return lm.names()
+}
+
+func (lm *LifecycleManager) ResolveDependencies(deps []*dapps.ModuleDependency) error {
+	return ResolveDependencies(lm.GetModules(), deps)
+}
+
+func (lm *LifecycleManager) names() []string {
+	names := make([]string, 0, len(lm.entries))
+	for name := range lm.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// A panicking module should not take the decerver down with it.
+func safeCall(f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return f()
+}
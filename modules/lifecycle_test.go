@@ -0,0 +1,184 @@
+package modules
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/eris-ltd/decerver-interfaces/core"
+	"github.com/eris-ltd/decerver-interfaces/events"
+)
+
+// Records the calls made to all test modules.
+type callLog []string
+
+type testModule struct {
+	name string
+	deps []string
+	log  *callLog
+	// Phase to fail in.
+	fail string
+}
+
+func (tm *testModule) record(phase string) error {
+	*tm.log = append(*tm.log, tm.name+"."+phase)
+	if phase == tm.fail {
+		return fmt.Errorf("%s failed", phase)
+	}
+	return nil
+}
+
+func (tm *testModule) Register(fileIO core.FileIO, rm core.RuntimeManager, eReg events.EventRegistry) error {
+	return tm.record("Register")
+}
+func (tm *testModule) Init() error     { return tm.record("Init") }
+func (tm *testModule) Start() error    { return tm.record("Start") }
+func (tm *testModule) Restart() error  { return tm.record("Restart") }
+func (tm *testModule) Shutdown() error { return tm.record("Shutdown") }
+func (tm *testModule) Name() string    { return tm.name }
+func (tm *testModule) Subscribe(name, event, target string) chan events.Event {
+	return nil
+}
+func (tm *testModule) UnSubscribe(name string) {}
+func (tm *testModule) SetProperty(name string, data interface{}) error {
+	return fmt.Errorf("No property named: %s", name)
+}
+func (tm *testModule) Property(name string) interface{} { return nil }
+func (tm *testModule) Dependencies() []string           { return tm.deps }
+
+func newTestModules(log *callLog) (*LifecycleManager, map[string]*testModule) {
+	lm := NewLifecycleManager()
+	mods := map[string]*testModule{
+		"monk":   {name: "monk", log: log},
+		"ipfs":   {name: "ipfs", log: log},
+		"monkjs": {name: "monkjs", log: log, deps: []string{"monk"}},
+		"dapp":   {name: "dapp", log: log},
+	}
+	lm.Add(mods["dapp"], "ipfs", "monkjs")
+	lm.Add(mods["monkjs"])
+	lm.Add(mods["ipfs"])
+	lm.Add(mods["monk"])
+	return lm, mods
+}
+
+func TestLifecycleOrder(t *testing.T) {
+	log := &callLog{}
+	lm, _ := newTestModules(log)
+	order, err := lm.Order()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{"ipfs", "monk", "monkjs", "dapp"}
+	if !reflect.DeepEqual(order, exp) {
+		t.Fatalf("Expected: %v, Got: %v", exp, order)
+	}
+	if err := lm.Start(nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, st := range lm.Statuses() {
+		if st.State != StateStarted {
+			t.Errorf("Expected %s to be started, Got: %s", st.Name, st.State)
+		}
+	}
+	*log = nil
+	if err := lm.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	expLog := callLog{"dapp.Shutdown", "monkjs.Shutdown", "monk.Shutdown", "ipfs.Shutdown"}
+	if !reflect.DeepEqual(*log, expLog) {
+		t.Fatalf("Expected: %v, Got: %v", expLog, *log)
+	}
+}
+
+func TestLifecycleRollback(t *testing.T) {
+	log := &callLog{}
+	lm, mods := newTestModules(log)
+	mods["monkjs"].fail = "Start"
+	err := lm.Start(nil, nil, nil)
+	lerr, ok := err.(*LifecycleError)
+	if !ok {
+		t.Fatalf("Expected a LifecycleError, Got: %v", err)
+	}
+	if lerr.Module != "monkjs" || lerr.Phase != "Start" {
+		t.Fatalf("Unexpected error: %v", lerr)
+	}
+	expLog := callLog{
+		"ipfs.Register", "ipfs.Init", "ipfs.Start",
+		"monk.Register", "monk.Init", "monk.Start",
+		"monkjs.Register", "monkjs.Init", "monkjs.Start", "monkjs.Shutdown",
+		"monk.Shutdown", "ipfs.Shutdown",
+	}
+	if !reflect.DeepEqual(*log, expLog) {
+		t.Fatalf("Expected: %v, Got: %v", expLog, *log)
+	}
+	states := make(map[string]ModuleState)
+	for _, st := range lerr.Statuses {
+		states[st.Name] = st.State
+	}
+	expStates := map[string]ModuleState{
+		"ipfs":   StateStopped,
+		"monk":   StateStopped,
+		"monkjs": StateFailed,
+		"dapp":   StatePending,
+	}
+	if !reflect.DeepEqual(states, expStates) {
+		t.Fatalf("Expected: %v, Got: %v", expStates, states)
+	}
+	st, _ := lm.Status("monkjs")
+	if st.Error != "Start failed" {
+		t.Fatalf("Expected the error in the status, Got: %s", st.Error)
+	}
+	b, _ := json.Marshal(st)
+	if string(b) != `{"name":"monkjs","state":"failed","error":"Start failed"}` {
+		t.Fatalf("Unexpected json: %s", b)
+	}
+
+	// Fix it and try again.
+	mods["monkjs"].fail = ""
+	if err := lm.Start(nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLifecyclePanic(t *testing.T) {
+	lm := NewLifecycleManager()
+	lm.Add(&panicModule{testModule{name: "bad", log: &callLog{}}})
+	if err := lm.Start(nil, nil, nil); err == nil {
+		t.Fatal("Expected an error from a panicking module")
+	}
+}
+
+type panicModule struct {
+	testModule
+}
+
+func (pm *panicModule) Init() error {
+	panic("oops")
+}
+
+func TestLifecycleBadDependencies(t *testing.T) {
+	log := &callLog{}
+	lm := NewLifecycleManager()
+	lm.Add(&testModule{name: "a", log: log}, "b")
+	lm.Add(&testModule{name: "b", log: log}, "c")
+	lm.Add(&testModule{name: "c", log: log}, "a")
+	if _, err := lm.Order(); err == nil {
+		t.Fatal("Expected a cycle to be detected")
+	}
+	if err := lm.Start(nil, nil, nil); err == nil {
+		t.Fatal("Expected Start to fail")
+	}
+	if len(*log) != 0 {
+		t.Fatalf("No module should have been touched, Got: %v", *log)
+	}
+
+	lm = NewLifecycleManager()
+	lm.Add(&testModule{name: "a", log: log}, "missing")
+	if _, err := lm.Order(); err == nil {
+		t.Fatal("Expected a missing dependency to be detected")
+	}
+	if err := lm.Add(&testModule{name: "a", log: log}); err == nil {
+		t.Fatal("Expected adding a module twice to fail")
+	}
+}
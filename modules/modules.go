@@ -2,6 +2,7 @@ package modules
 
 import (
 	"github.com/eris-ltd/decerver-interfaces/core"
+	"github.com/eris-ltd/decerver-interfaces/dapps"
 	"github.com/eris-ltd/decerver-interfaces/events"
 )
 
@@ -35,13 +36,18 @@ type (
 		Subscribe(name, event, target string) chan events.Event
 		UnSubscribe(name string)
 
-		SetProperty(name string, data interface{})
+		// Properties are typed; see PropertySchema and Properties. SetProperty
+		// returns an error if the value can not be used.
+		SetProperty(name string, data interface{}) error
 		Property(name string) interface{}
 	}
 
 	ModuleRegistry interface {
 		GetModules() map[string]Module
 		GetModuleNames() []string
+		// Check the module dependencies of a dapp against the installed modules.
+		// Returns a *DependencyError (see ResolveDependencies).
+		ResolveDependencies(deps []*dapps.ModuleDependency) error
 	}
 )
 
@@ -74,6 +80,24 @@ type Blockchain interface {
 	IsAutocommit() JsObject
 }
 
+// Blockchains that keep the state of past blocks implement this. It is what
+// state diffs between blocks are computed from. The chains in glue do not implement
+// it yet, so they have no state diffs.
+type StateHistory interface {
+	// The world state (a WorldState) as it was after the block with the given hash.
+	WorldStateAt(blockHash string) JsObject
+}
+
+// Blockchains that can page through their state without loading all of it
+// implement this. Pages are a WorldStatePage, StatePage and StoragePage, in the
+// order of WorldState, State and Storage. Cursors are opaque; an empty cursor is
+// the first page.
+type StatePager interface {
+	WorldStatePage(cursor string, limit int) JsObject
+	StatePage(cursor string, limit int) JsObject
+	StoragePage(target, cursor string, limit int) JsObject
+}
+
 type KeyManager interface {
 	ActiveAddress() JsObject
 	Address(n int) JsObject
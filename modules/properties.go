@@ -0,0 +1,280 @@
+package modules
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+)
+
+type PropertyType int
+
+const (
+	PropString PropertyType = iota
+	PropInt
+	PropFloat
+	PropBool
+)
+
+func (t PropertyType) String() string {
+	switch t {
+	case PropString:
+		return "string"
+	case PropInt:
+		return "int"
+	case PropFloat:
+		return "float"
+	case PropBool:
+		return "bool"
+	}
+	return "unknown"
+}
+
+func (t PropertyType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// Describes a module property.
+type PropertySpec struct {
+	Name        string       `json:"name"`
+	Type        PropertyType `json:"type"`
+	Default     interface{}  `json:"default"`
+	Description string       `json:"description"`
+	// True if a new value only takes effect after the module is restarted.
+	Restart bool `json:"restart"`
+	// Optional check of the (coerced) value.
+	Validate func(value interface{}) error `json:"-"`
+}
+
+// Modules with properties implement this, so that dapps (and the decerver) can
+// find out what can be set.
+type PropertySchema interface {
+	PropertySpecs() []*PropertySpec
+}
+
+// Properties holds the values of a set of typed properties. Values are coerced to
+// the type of the property, so a module always gets an int for an int property,
+// even though javascript only has float64 numbers. Goroutine safe.
+type Properties struct {
+	mutex  *sync.Mutex
+	specs  []*PropertySpec
+	byName map[string]*PropertySpec
+	values map[string]interface{}
+}
+
+// Create a set of properties, with their default values. Panics if a default
+// does not have the type of its property.
+func NewProperties(specs ...*PropertySpec) *Properties {
+	p := &Properties{}
+	p.mutex = &sync.Mutex{}
+	p.specs = make([]*PropertySpec, 0, len(specs))
+	p.byName = make(map[string]*PropertySpec)
+	p.values = make(map[string]interface{})
+	for _, s := range specs {
+		if _, ok := p.byName[s.Name]; ok {
+			panic("Property defined twice: " + s.Name)
+		}
+		val, err := Coerce(s.Type, s.Default)
+		if err != nil {
+			panic(fmt.Sprintf("Bad default for property %s: %s", s.Name, err))
+		}
+		// Specs are often package level vars, shared by many modules.
+		spec := *s
+		spec.Default = val
+		p.specs = append(p.specs, &spec)
+		p.byName[spec.Name] = &spec
+		p.values[spec.Name] = val
+	}
+	return p
+}
+
+func (p *Properties) Specs() []*PropertySpec {
+	return p.specs
+}
+
+func (p *Properties) Spec(name string) *PropertySpec {
+	return p.byName[name]
+}
+
+// Set a property. The value is coerced to the type of the property and validated.
+func (p *Properties) Set(name string, value interface{}) error {
+	spec, ok := p.byName[name]
+	if !ok {
+		return fmt.Errorf("No property named: %s", name)
+	}
+	val, err := Coerce(spec.Type, value)
+	if err != nil {
+		return fmt.Errorf("Invalid value for property %s: %s", name, err)
+	}
+	if spec.Validate != nil {
+		if err := spec.Validate(val); err != nil {
+			return fmt.Errorf("Invalid value for property %s: %s", name, err)
+		}
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.values[name] = val
+	return nil
+}
+
+// Get the value of a property, or nil if there is no such property.
+func (p *Properties) Get(name string) interface{} {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.values[name]
+}
+
+// Typed getters. They return the zero value if there is no such property.
+
+func (p *Properties) String(name string) string {
+	s, _ := p.Get(name).(string)
+	return s
+}
+
+func (p *Properties) Int(name string) int {
+	i, _ := p.Get(name).(int)
+	return i
+}
+
+func (p *Properties) Float(name string) float64 {
+	f, _ := p.Get(name).(float64)
+	return f
+}
+
+func (p *Properties) Bool(name string) bool {
+	b, _ := p.Get(name).(bool)
+	return b
+}
+
+// Set every property back to its default.
+func (p *Properties) Reset() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, spec := range p.specs {
+		p.values[spec.Name] = spec.Default
+	}
+}
+
+// All values, by name.
+func (p *Properties) Values() map[string]interface{} {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	ret := make(map[string]interface{})
+	for k, v := range p.values {
+		ret[k] = v
+	}
+	return ret
+}
+
+// Coerce a value to a property type. Numbers can be any go number type, as long
+// as nothing is lost (an int property does not take 1.5). Strings are parsed for
+// numbers and bools. A nil value gives the zero value of the type.
+func Coerce(t PropertyType, value interface{}) (interface{}, error) {
+	if value == nil {
+		switch t {
+		case PropString:
+			return "", nil
+		case PropInt:
+			return 0, nil
+		case PropFloat:
+			return float64(0), nil
+		case PropBool:
+			return false, nil
+		}
+	}
+	switch t {
+	case PropString:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+	case PropInt:
+		if s, ok := value.(string); ok {
+			i, err := strconv.Atoi(s)
+			if err != nil {
+				return nil, fmt.Errorf("'%s' is not an integer", s)
+			}
+			return i, nil
+		}
+		if f, ok := toFloat(value); ok {
+			if f != math.Trunc(f) || f >= math.MaxInt64 || f < math.MinInt64 {
+				return nil, fmt.Errorf("%v is not an integer", value)
+			}
+			return int(f), nil
+		}
+		if i, ok := toInt(value); ok {
+			return i, nil
+		}
+	case PropFloat:
+		if s, ok := value.(string); ok {
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return nil, fmt.Errorf("'%s' is not a number", s)
+			}
+			return f, nil
+		}
+		if f, ok := toFloat(value); ok {
+			return f, nil
+		}
+		if i, ok := toInt(value); ok {
+			return float64(i), nil
+		}
+	case PropBool:
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+		if s, ok := value.(string); ok {
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				return nil, fmt.Errorf("'%s' is not a bool", s)
+			}
+			return b, nil
+		}
+	default:
+		return nil, fmt.Errorf("Unknown property type: %d", t)
+	}
+	return nil, fmt.Errorf("Expected %s, got %T", t, value)
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+func toInt(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case int:
+		return v, true
+	case int8:
+		return int(v), true
+	case int16:
+		return int(v), true
+	case int32:
+		return int(v), true
+	case int64:
+		return int(v), true
+	case uint:
+		return int(v), true
+	case uint8:
+		return int(v), true
+	case uint16:
+		return int(v), true
+	case uint32:
+		return int(v), true
+	case uint64:
+		return int(v), true
+	}
+	return 0, false
+}
+
+// A Validate function for ports.
+func ValidatePort(value interface{}) error {
+	if port, ok := value.(int); !ok || port < 0 || port > 65535 {
+		return fmt.Errorf("%v is not a valid port", value)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package modules
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func testProperties() *Properties {
+	return NewProperties(
+		&PropertySpec{Name: "ChainId", Type: PropString, Restart: true},
+		&PropertySpec{Name: "RemotePort", Type: PropInt, Default: 30303, Validate: ValidatePort},
+		&PropertySpec{Name: "Ratio", Type: PropFloat, Default: 1},
+		&PropertySpec{Name: "Mining", Type: PropBool},
+	)
+}
+
+func TestPropertyDefaults(t *testing.T) {
+	p := testProperties()
+	if p.Get("RemotePort") != 30303 || p.Int("RemotePort") != 30303 {
+		t.Fatalf("Expected default 30303, Got: %v", p.Get("RemotePort"))
+	}
+	if p.Get("Ratio") != float64(1) {
+		t.Fatalf("Expected default to be coerced to float64, Got: %T", p.Get("Ratio"))
+	}
+	if p.Get("ChainId") != "" || p.Get("Mining") != false {
+		t.Fatal("Expected zero values as defaults")
+	}
+	if p.Get("Nothing") != nil {
+		t.Fatal("Expected nil for an unknown property")
+	}
+}
+
+func TestPropertyCoercion(t *testing.T) {
+	p := testProperties()
+	good := []struct {
+		name  string
+		value interface{}
+		exp   interface{}
+	}{
+		// What otto gives us.
+		{"RemotePort", float64(30304), 30304},
+		{"RemotePort", int64(30305), 30305},
+		{"RemotePort", "30306", 30306},
+		{"Ratio", 2, float64(2)},
+		{"Ratio", "0.5", 0.5},
+		{"Mining", "true", true},
+		{"Mining", false, false},
+		{"ChainId", "abc", "abc"},
+	}
+	for _, c := range good {
+		if err := p.Set(c.name, c.value); err != nil {
+			t.Errorf("Set %s to %v: %s", c.name, c.value, err)
+			continue
+		}
+		if p.Get(c.name) != c.exp {
+			t.Errorf("Expected %s to be %v (%T), Got: %v (%T)", c.name, c.exp, c.exp, p.Get(c.name), p.Get(c.name))
+		}
+	}
+
+	bad := []struct {
+		name  string
+		value interface{}
+	}{
+		{"RemotePort", 1.5},
+		{"RemotePort", "port"},
+		{"RemotePort", 70000},
+		{"RemotePort", true},
+		{"ChainId", 5},
+		{"Mining", 1},
+		{"Nothing", "x"},
+	}
+	for _, c := range bad {
+		if err := p.Set(c.name, c.value); err == nil {
+			t.Errorf("Expected setting %s to %v to fail", c.name, c.value)
+		}
+	}
+	// Failed sets leave the value alone.
+	if p.Int("RemotePort") != 30306 {
+		t.Fatalf("Expected 30306, Got: %v", p.Get("RemotePort"))
+	}
+	p.Reset()
+	if p.Int("RemotePort") != 30303 {
+		t.Fatalf("Expected the default after reset, Got: %v", p.Get("RemotePort"))
+	}
+}
+
+func TestPropertySpecJson(t *testing.T) {
+	p := testProperties()
+	b, err := json.Marshal(p.Spec("RemotePort"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := `{"name":"RemotePort","type":"int","default":30303,"description":"","restart":false}`
+	if string(b) != exp {
+		t.Fatalf("Expected: %s, Got: %s", exp, b)
+	}
+}
+
+func TestBadDefault(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Expected a panic")
+		}
+	}()
+	NewProperties(&PropertySpec{Name: "Port", Type: PropInt, Default: "abc"})
+}
@@ -0,0 +1,316 @@
+package modules
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A semantic version (major.minor.patch, with an optional pre-release).
+// Build metadata is ignored.
+type Version struct {
+	Major      int
+	Minor      int
+	Patch      int
+	PreRelease string
+}
+
+// Parse a version like "1.2.3", "v1.2.3" or "1.2.3-beta.1". All three
+// numbers are required.
+func ParseVersion(s string) (*Version, error) {
+	v, parts, err := parsePartial(s)
+	if err != nil {
+		return nil, err
+	}
+	if parts != 3 {
+		return nil, fmt.Errorf("Invalid version '%s': need major.minor.patch", s)
+	}
+	return v, nil
+}
+
+func (v *Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.PreRelease != "" {
+		s += "-" + v.PreRelease
+	}
+	return s
+}
+
+// Compare returns -1, 0 or 1 if v is lower than, equal to or higher than other.
+// Pre-releases are lower than the release they belong to.
+func (v *Version) Compare(other *Version) int {
+	if c := compareInt(v.Major, other.Major); c != 0 {
+		return c
+	}
+	if c := compareInt(v.Minor, other.Minor); c != 0 {
+		return c
+	}
+	if c := compareInt(v.Patch, other.Patch); c != 0 {
+		return c
+	}
+	return comparePreRelease(v.PreRelease, other.PreRelease)
+}
+
+func compareInt(a, b int) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+func comparePreRelease(a, b string) int {
+	if a == b {
+		return 0
+	}
+	if a == "" {
+		return 1
+	}
+	if b == "" {
+		return -1
+	}
+	as := strings.Split(a, ".")
+	bs := strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aErr := strconv.Atoi(as[i])
+		bn, bErr := strconv.Atoi(bs[i])
+		var c int
+		switch {
+		case aErr == nil && bErr == nil:
+			c = compareInt(an, bn)
+		case aErr == nil:
+			// Numeric identifiers are lower than alphanumeric ones.
+			c = -1
+		case bErr == nil:
+			c = 1
+		default:
+			c = strings.Compare(as[i], bs[i])
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return compareInt(len(as), len(bs))
+}
+
+// Parses a version where minor and patch may be left out, or be a wildcard
+// ("x", "X" or "*"). Returns the number of parts that were given.
+func parsePartial(s string) (*Version, int, error) {
+	str := strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.Index(str, "+"); i >= 0 {
+		str = str[:i]
+	}
+	v := &Version{}
+	if i := strings.Index(str, "-"); i >= 0 {
+		v.PreRelease = str[i+1:]
+		str = str[:i]
+		if v.PreRelease == "" {
+			return nil, 0, fmt.Errorf("Invalid version '%s': empty pre-release", s)
+		}
+	}
+	fields := strings.Split(str, ".")
+	if len(fields) > 3 {
+		return nil, 0, fmt.Errorf("Invalid version '%s'", s)
+	}
+	nums := []*int{&v.Major, &v.Minor, &v.Patch}
+	parts := 0
+	for i, f := range fields {
+		if f == "x" || f == "X" || f == "*" {
+			break
+		}
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 0 {
+			return nil, 0, fmt.Errorf("Invalid version '%s'", s)
+		}
+		*nums[i] = n
+		parts++
+	}
+	if v.PreRelease != "" && parts != 3 {
+		return nil, 0, fmt.Errorf("Invalid version '%s': a pre-release needs major.minor.patch", s)
+	}
+	return v, parts, nil
+}
+
+type comparator struct {
+	// One of "<", "<=", ">", ">=", "=".
+	op string
+	v  *Version
+}
+
+func (c comparator) matches(v *Version) bool {
+	n := v.Compare(c.v)
+	switch c.op {
+	case "<":
+		return n < 0
+	case "<=":
+		return n <= 0
+	case ">":
+		return n > 0
+	case ">=":
+		return n >= 0
+	}
+	return n == 0
+}
+
+// A set of versions, in the syntax npm uses in package.json:
+//
+//	"1.2.3", "=1.2.3"    exactly 1.2.3
+//	"1.2", "1.2.x"       any 1.2 version
+//	">=1.2 <2"           comparators separated by spaces must all match
+//	">= 1.2 < 2"         there may be spaces after an operator
+//	"^0.8", "^1.2.3"     compatible versions: ^0.8 is >=0.8.0 <0.9.0, ^1.2.3 is >=1.2.3 <2.0.0
+//	"~1.2.3"             patch updates: >=1.2.3 <1.3.0
+//	"1.x || >=3"         either side may match
+//	"", "*"              any version
+//
+// As in npm, a pre-release is only in a range if a comparator next to the ones it
+// matches has a pre-release of the same major.minor.patch: ">=1.2.3-beta <2" has
+// 1.2.3-rc, but neither that nor "<2" has 2.0.0-beta.
+type VersionRange struct {
+	raw string
+	// Alternatives (||), each a list of comparators that must all match.
+	sets [][]comparator
+}
+
+func ParseVersionRange(s string) (*VersionRange, error) {
+	vr := &VersionRange{raw: strings.TrimSpace(s)}
+	for _, alt := range strings.Split(s, "||") {
+		set := make([]comparator, 0)
+		fields := strings.Fields(alt)
+		for i := 0; i < len(fields); i++ {
+			field := fields[i]
+			if isOperator(field) && i+1 < len(fields) {
+				i++
+				field += fields[i]
+			}
+			cs, err := parseComparator(field)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid version range '%s': %s", s, err)
+			}
+			set = append(set, cs...)
+		}
+		vr.sets = append(vr.sets, set)
+	}
+	return vr, nil
+}
+
+// Contains reports if v is in the range.
+func (vr *VersionRange) Contains(v *Version) bool {
+	for _, set := range vr.sets {
+		ok := true
+		for _, c := range set {
+			if !c.matches(v) {
+				ok = false
+				break
+			}
+		}
+		if ok && (v.PreRelease == "" || len(set) == 0 || allowsPreRelease(set, v)) {
+			return true
+		}
+	}
+	return false
+}
+
+// If a comparator in the set names a pre-release of the same major.minor.patch as v.
+func allowsPreRelease(set []comparator, v *Version) bool {
+	for _, c := range set {
+		if c.v.PreRelease != "" && c.v.Major == v.Major && c.v.Minor == v.Minor && c.v.Patch == v.Patch {
+			return true
+		}
+	}
+	return false
+}
+
+// Any reports if the range accepts every version, like "*" does.
+func (vr *VersionRange) Any() bool {
+	for _, set := range vr.sets {
+		if len(set) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (vr *VersionRange) String() string {
+	if vr.raw == "" {
+		return "*"
+	}
+	return vr.raw
+}
+
+var operators = []string{">=", "<=", ">", "<", "=", "^", "~"}
+
+func isOperator(s string) bool {
+	for _, o := range operators {
+		if s == o {
+			return true
+		}
+	}
+	return false
+}
+
+// Turns one comparator with a partial version into plain comparators.
+func parseComparator(s string) ([]comparator, error) {
+	op := ""
+	for _, o := range operators {
+		if strings.HasPrefix(s, o) {
+			op = o
+			break
+		}
+	}
+	v, parts, err := parsePartial(s[len(op):])
+	if err != nil {
+		return nil, err
+	}
+	if parts == 0 {
+		if op == "<" || op == ">" {
+			return nil, fmt.Errorf("'%s' matches no version", s)
+		}
+		return nil, nil
+	}
+	// The first version after the ones the partial version stands for, e.g. 1.3.0 for 1.2.
+	next := func(v *Version, parts int) *Version {
+		switch parts {
+		case 1:
+			return &Version{Major: v.Major + 1}
+		case 2:
+			return &Version{Major: v.Major, Minor: v.Minor + 1}
+		}
+		return &Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch + 1}
+	}
+	switch op {
+	case "", "=":
+		if parts == 3 {
+			return []comparator{{"=", v}}, nil
+		}
+		return []comparator{{">=", v}, {"<", next(v, parts)}}, nil
+	case ">=", "<":
+		return []comparator{{op, v}}, nil
+	case ">":
+		if parts == 3 {
+			return []comparator{{op, v}}, nil
+		}
+		return []comparator{{">=", next(v, parts)}}, nil
+	case "<=":
+		if parts == 3 {
+			return []comparator{{op, v}}, nil
+		}
+		return []comparator{{"<", next(v, parts)}}, nil
+	case "~":
+		if parts == 3 {
+			parts = 2
+		}
+		return []comparator{{">=", v}, {"<", next(v, parts)}}, nil
+	}
+	// Caret: the first non-zero part must stay the same.
+	upper := 1
+	if v.Major == 0 && parts >= 2 {
+		upper = 2
+		if v.Minor == 0 && parts == 3 {
+			upper = 3
+		}
+	}
+	return []comparator{{">=", v}, {"<", next(v, upper)}}, nil
+}
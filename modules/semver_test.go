@@ -0,0 +1,78 @@
+package modules
+
+import (
+	"testing"
+)
+
+func TestVersionCompare(t *testing.T) {
+	ordered := []string{"0.8.0", "0.8.1", "1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0", "1.2.0", "v1.10.0"}
+	for i := 1; i < len(ordered); i++ {
+		a, err := ParseVersion(ordered[i-1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ParseVersion(ordered[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.Compare(b) != -1 || b.Compare(a) != 1 || a.Compare(a) != 0 {
+			t.Errorf("Expected %s < %s", a, b)
+		}
+	}
+	for _, bad := range []string{"", "1.2", "1.2.3.4", "a.b.c", "1.2.3-", "-1.2.3"} {
+		if _, err := ParseVersion(bad); err == nil {
+			t.Errorf("Expected an error for '%s'", bad)
+		}
+	}
+}
+
+func TestVersionRange(t *testing.T) {
+	tests := []struct {
+		rng string
+		in  []string
+		out []string
+	}{
+		{"^0.8", []string{"0.8.0", "0.8.9"}, []string{"0.7.9", "0.9.0", "1.0.0"}},
+		{"^1.2.3", []string{"1.2.3", "1.9.0"}, []string{"1.2.2", "2.0.0"}},
+		{"^0.0.3", []string{"0.0.3"}, []string{"0.0.4", "0.1.0"}},
+		{">=1.2 <2", []string{"1.2.0", "1.99.1"}, []string{"1.1.9", "2.0.0"}},
+		{">= 1.2 < 2", []string{"1.2.0", "1.99.1"}, []string{"1.1.9", "2.0.0"}},
+		{"^ 0.8 || = 2.0.0", []string{"0.8.1", "2.0.0"}, []string{"0.9.0", "2.0.1"}},
+		{"~1.2.3", []string{"1.2.3", "1.2.10"}, []string{"1.3.0", "1.2.2"}},
+		{"1.2", []string{"1.2.0", "1.2.7"}, []string{"1.3.0", "1.1.0"}},
+		{"1.x || >=3", []string{"1.0.0", "3.1.0"}, []string{"2.0.0", "0.9.0"}},
+		{"=1.2.3", []string{"1.2.3"}, []string{"1.2.4"}},
+		{">1.2", []string{"1.3.0"}, []string{"1.2.9"}},
+		{"<=1.2", []string{"1.2.9"}, []string{"1.3.0"}},
+		// Pre-releases only match a comparator with a pre-release of their own.
+		{"<2", []string{"1.9.9"}, []string{"2.0.0-beta", "1.0.0-rc.1"}},
+		{"^1.2.3", nil, []string{"2.0.0-beta", "1.2.4-alpha"}},
+		{"^1.2.3-beta", []string{"1.2.3-beta", "1.2.3-rc.1", "1.2.3", "1.5.0"}, []string{"1.2.3-alpha", "1.2.4-beta", "2.0.0-beta"}},
+		{">=1.2.3-beta <2", []string{"1.2.3-rc"}, []string{"2.0.0-beta"}},
+		{"*", []string{"0.0.1", "9.9.9"}, nil},
+		{"", []string{"0.0.1"}, nil},
+	}
+	for _, test := range tests {
+		vr, err := ParseVersionRange(test.rng)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range test.in {
+			v, _ := ParseVersion(s)
+			if !vr.Contains(v) {
+				t.Errorf("Expected '%s' to contain %s", test.rng, s)
+			}
+		}
+		for _, s := range test.out {
+			v, _ := ParseVersion(s)
+			if vr.Contains(v) {
+				t.Errorf("Expected '%s' not to contain %s", test.rng, s)
+			}
+		}
+	}
+	for _, bad := range []string{"^a", ">=1.2.x.4", "<*", ">="} {
+		if _, err := ParseVersionRange(bad); err == nil {
+			t.Errorf("Expected an error for '%s'", bad)
+		}
+	}
+}
@@ -14,4 +14,6 @@ type WsSession interface {
 // Webserver
 type Server interface {
 	RegisterDapp(dappId string)
+	// Remove the routes of a dapp.
+	UnregisterDapp(dappId string)
 }
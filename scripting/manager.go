@@ -0,0 +1,144 @@
+package scripting
+
+import (
+	"sync"
+	"time"
+
+	"github.com/eris-ltd/decerver-interfaces/core"
+	"github.com/eris-ltd/decerver-interfaces/dapps"
+)
+
+var logger = core.NewLogger("Scripting")
+
+// RuntimeManager is an implementation of core.RuntimeManager that uses otto
+// runtimes. Api objects and scripts are bound into every runtime, both the ones
+// that are created later and the ones that already exist.
+type RuntimeManager struct {
+	mutex    *sync.Mutex
+	runtimes map[string]*JsRuntime
+	// Api objects in the order they were registered.
+	apiNames   []string
+	apiObjects map[string]interface{}
+	apiScripts []string
+	// Default time limit for runtimes that are created.
+	timeout time.Duration
+}
+
+// The script timeout in cfg is the default for every runtime. cfg may be nil.
+func NewRuntimeManager(cfg *core.DCConfig) *RuntimeManager {
+	rm := &RuntimeManager{}
+	rm.mutex = &sync.Mutex{}
+	if cfg != nil {
+		rm.timeout = cfg.ScriptTimeoutDuration()
+	}
+	rm.runtimes = make(map[string]*JsRuntime)
+	rm.apiNames = make([]string, 0)
+	rm.apiObjects = make(map[string]interface{})
+	rm.apiScripts = make([]string, 0)
+	return rm
+}
+
+// Get the runtime with the given name, or nil.
+func (rm *RuntimeManager) GetRuntime(name string) core.Runtime {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+	rt, ok := rm.runtimes[name]
+	if !ok {
+		return nil
+	}
+	return rt
+}
+
+// Create a new runtime with all api objects and scripts in it. If there
+// already is a runtime with that name it is shut down and replaced.
+func (rm *RuntimeManager) CreateRuntime(name string) core.Runtime {
+	return rm.CreateRuntimeWithQuotas(name, nil)
+}
+
+// Create a runtime for a dapp with the quotas from its package file (see JsRuntime.SetQuotas).
+// The api objects it gets are subject to the call quotas. A nil 'quotas' means no limits.
+func (rm *RuntimeManager) CreateRuntimeWithQuotas(name string, quotas *dapps.Quotas) core.Runtime {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+	if old, ok := rm.runtimes[name]; ok {
+		logger.Printf("Replacing runtime '%s'\n", name)
+		old.Shutdown()
+	}
+	rt := NewJsRuntime(name)
+	rt.SetTimeout(rm.timeout)
+	rt.SetQuotas(quotas)
+	for _, objName := range rm.apiNames {
+		if err := rt.BindScriptObject(objName, rm.apiObjects[objName]); err != nil {
+			logger.Printf("Could not bind api object '%s' in runtime '%s': %s\n", objName, name, err)
+		}
+	}
+	for _, script := range rm.apiScripts {
+		if err := rt.AddScript(script); err != nil {
+			logger.Printf("Could not load api script in runtime '%s': %s\n", name, err)
+		}
+	}
+	rm.runtimes[name] = rt
+	return rt
+}
+
+// Shut down and remove a runtime.
+func (rm *RuntimeManager) RemoveRuntime(name string) {
+	rm.mutex.Lock()
+	rt, ok := rm.runtimes[name]
+	delete(rm.runtimes, name)
+	rm.mutex.Unlock()
+	if ok {
+		rt.Shutdown()
+	}
+}
+
+// Register an object that is bound (under the given name) into every runtime.
+// Registering a name twice replaces the object.
+func (rm *RuntimeManager) RegisterApiObject(name string, obj interface{}) {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+	if _, ok := rm.apiObjects[name]; !ok {
+		rm.apiNames = append(rm.apiNames, name)
+	}
+	rm.apiObjects[name] = obj
+	for rtName, rt := range rm.runtimes {
+		if err := rt.BindScriptObject(name, obj); err != nil {
+			logger.Printf("Could not bind api object '%s' in runtime '%s': %s\n", name, rtName, err)
+		}
+	}
+}
+
+// Register a script that is run in every runtime, after the api objects
+// have been bound.
+func (rm *RuntimeManager) RegisterApiScript(script string) {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+	rm.apiScripts = append(rm.apiScripts, script)
+	for rtName, rt := range rm.runtimes {
+		if err := rt.AddScript(script); err != nil {
+			logger.Printf("Could not load api script in runtime '%s': %s\n", rtName, err)
+		}
+	}
+}
+
+// The names of all runtimes.
+func (rm *RuntimeManager) RuntimeNames() []string {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+	names := make([]string, 0, len(rm.runtimes))
+	for name := range rm.runtimes {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Shut down and remove all runtimes.
+func (rm *RuntimeManager) Shutdown() {
+	rm.mutex.Lock()
+	runtimes := rm.runtimes
+	rm.runtimes = make(map[string]*JsRuntime)
+	rm.mutex.Unlock()
+	for _, rt := range runtimes {
+		rt.Shutdown()
+	}
+}
@@ -0,0 +1,329 @@
+package scripting
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/eris-ltd/decerver-interfaces/dapps"
+	"github.com/eris-ltd/decerver-interfaces/modules"
+	"github.com/robertkrimen/otto"
+)
+
+// Quota names, as in package.json.
+const (
+	QUOTA_MEMORY           = "max_memory"
+	QUOTA_SUBSCRIPTIONS    = "max_subscriptions"
+	QUOTA_CALLS_PER_SECOND = "calls_per_second"
+)
+
+// How often the memory quota is checked while a call runs.
+const MEMORY_CHECK_INTERVAL = 50 * time.Millisecond
+
+// Returned (or passed to javascript as a JsObject) when a runtime goes over a quota.
+type QuotaError struct {
+	Runtime string
+	Quota   string
+	// The method, for call quotas.
+	Method string
+	Limit  float64
+}
+
+func (qe *QuotaError) Error() string {
+	if qe.Method != "" {
+		return fmt.Sprintf("Runtime '%s' is over its %s quota for %s (%v)", qe.Runtime, qe.Quota, qe.Method, qe.Limit)
+	}
+	return fmt.Sprintf("Runtime '%s' is over its %s quota (%v)", qe.Runtime, qe.Quota, qe.Limit)
+}
+
+// The error as a javascript return value. It has the usual 'Data' and 'Error'
+// fields, and a 'Quota' object with the details.
+func (qe *QuotaError) JsObject() modules.JsObject {
+	ret := modules.JsReturnValErr(qe)
+	ret["Quota"] = modules.JsObject{
+		"Name":   qe.Quota,
+		"Method": qe.Method,
+		"Limit":  qe.Limit,
+	}
+	return ret
+}
+
+// Quota bookkeeping for a single runtime.
+type quotaState struct {
+	runtime string
+	quotas  dapps.Quotas
+	mutex   *sync.Mutex
+	calls   *rateLimiter
+	methods map[string]*rateLimiter
+	subs    map[string]bool
+}
+
+func newQuotaState(runtime string, q *dapps.Quotas) *quotaState {
+	qs := &quotaState{}
+	qs.runtime = runtime
+	qs.quotas = *q
+	qs.mutex = &sync.Mutex{}
+	if q.CallsPerSecond > 0 {
+		qs.calls = newRateLimiter(q.CallsPerSecond)
+	}
+	qs.methods = make(map[string]*rateLimiter)
+	for method, rate := range q.MethodCallsPerSecond {
+		if rate > 0 {
+			qs.methods[method] = newRateLimiter(rate)
+		}
+	}
+	qs.subs = make(map[string]bool)
+	return qs
+}
+
+// Account for a call to a method ("object.Method").
+func (qs *quotaState) call(method string) *QuotaError {
+	qs.mutex.Lock()
+	defer qs.mutex.Unlock()
+	now := time.Now()
+	if rl, ok := qs.methods[method]; ok && !rl.allow(now) {
+		return &QuotaError{qs.runtime, QUOTA_CALLS_PER_SECOND, method, rl.rate}
+	}
+	if qs.calls != nil && !qs.calls.allow(now) {
+		return &QuotaError{qs.runtime, QUOTA_CALLS_PER_SECOND, "", qs.calls.rate}
+	}
+	return nil
+}
+
+func (qs *quotaState) subscribe(id string) *QuotaError {
+	qs.mutex.Lock()
+	defer qs.mutex.Unlock()
+	max := qs.quotas.MaxSubscriptions
+	if max > 0 && !qs.subs[id] && len(qs.subs) >= max {
+		return &QuotaError{qs.runtime, QUOTA_SUBSCRIPTIONS, "", float64(max)}
+	}
+	qs.subs[id] = true
+	return nil
+}
+
+func (qs *quotaState) unsubscribe(id string) {
+	qs.mutex.Lock()
+	defer qs.mutex.Unlock()
+	delete(qs.subs, id)
+}
+
+func (qs *quotaState) subscriptions() int {
+	qs.mutex.Lock()
+	defer qs.mutex.Unlock()
+	return len(qs.subs)
+}
+
+// A token bucket. Up to one second worth of calls can be made in a burst.
+type rateLimiter struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64) *rateLimiter {
+	return &rateLimiter{rate: rate, tokens: burst(rate), last: time.Now()}
+}
+
+func burst(rate float64) float64 {
+	if rate < 1 {
+		return 1
+	}
+	return rate
+}
+
+func (rl *rateLimiter) allow(now time.Time) bool {
+	rl.tokens += now.Sub(rl.last).Seconds() * rl.rate
+	if max := burst(rl.rate); rl.tokens > max {
+		rl.tokens = max
+	}
+	rl.last = now
+	if rl.tokens < 1 {
+		return false
+	}
+	rl.tokens--
+	return true
+}
+
+// Wrap an api object. The wrapper has the object as its prototype, so its fields
+// and methods are all still there; only some methods are replaced. If the runtime
+// has quotas, every method goes through the call quotas, and a call that is over
+// quota returns a QuotaError JsObject instead of calling the method. Otherwise only
+// Subscribe and UnSubscribe (see modules.Module) are replaced. Those are counted as
+// subscriptions (if there is a quota), and tracked so they can be cancelled on
+// shutdown. Must be called with the mutex held.
+func (rt *JsRuntime) limitObject(name string, val interface{}) (otto.Value, error) {
+	orig, err := rt.vm.ToValue(val)
+	if err != nil {
+		return otto.UndefinedValue(), err
+	}
+	create, err := rt.vm.Get("Object")
+	if err != nil {
+		return otto.UndefinedValue(), err
+	}
+	wrapper, err := create.Object().Call("create", orig)
+	if err != nil {
+		return otto.UndefinedValue(), err
+	}
+	obj := wrapper.Object()
+	t := reflect.TypeOf(val)
+	for i := 0; i < t.NumMethod(); i++ {
+		method := t.Method(i).Name
+		if rt.quotas == nil && method != "Subscribe" && method != "UnSubscribe" {
+			continue
+		}
+		if err := obj.Set(method, rt.limitMethod(name, method, val, orig)); err != nil {
+			return otto.UndefinedValue(), err
+		}
+	}
+	return wrapper, nil
+}
+
+func (rt *JsRuntime) limitMethod(objName, method string, val interface{}, orig otto.Value) func(otto.FunctionCall) otto.Value {
+	fullName := objName + "." + method
+	unsub, _ := val.(unsubscriber)
+	return func(call otto.FunctionCall) otto.Value {
+		qs := rt.quotas
+		if qs != nil {
+			if qerr := qs.call(fullName); qerr != nil {
+				return rt.quotaValue(qerr)
+			}
+		}
+		args := toInterfaces(call.ArgumentList)
+		id := ""
+		if method == "Subscribe" || method == "UnSubscribe" {
+			// Modules are shared by the runtimes, so the names are made unique.
+			id = call.Argument(0).String()
+			if len(args) > 0 {
+				args[0] = rt.subscriberName(id)
+			}
+		}
+		if method == "Subscribe" && qs != nil {
+			if qerr := qs.subscribe(id); qerr != nil {
+				return rt.quotaValue(qerr)
+			}
+		}
+		ret, err := orig.Object().Call(method, args...)
+		if err != nil {
+			if method == "Subscribe" && qs != nil {
+				qs.unsubscribe(id)
+			}
+			panic(rt.vm.MakeCustomError("Error", err.Error()))
+		}
+		switch method {
+		case "Subscribe":
+			if unsub != nil {
+				rt.trackSubscription(id, unsub)
+			}
+		case "UnSubscribe":
+			if qs != nil {
+				qs.unsubscribe(id)
+			}
+			rt.untrackSubscription(id)
+		}
+		return ret
+	}
+}
+
+func (rt *JsRuntime) quotaValue(qerr *QuotaError) otto.Value {
+	v, err := rt.toValue(qerr.JsObject())
+	if err != nil {
+		panic(rt.vm.MakeCustomError("Error", qerr.Error()))
+	}
+	return v
+}
+
+func toInterfaces(vals []otto.Value) []interface{} {
+	ret := make([]interface{}, len(vals))
+	for i, v := range vals {
+		ret[i] = v
+	}
+	return ret
+}
+
+// Estimate the size of the script state: everything reachable from the global
+// object, apart from the bound api objects. Otto does not keep track of memory,
+// so this is only an approximation; strings count with their length, and every
+// other value or property with a fixed overhead. Otto has no object identity, so
+// an object that is reachable in several ways counts every time, and the walk
+// does not go deeper than MAX_WALK_DEPTH (which also ends cycles). Stops as soon
+// as 'max' is passed, or MAX_WALK_VALUES values have been seen.
+// Must be called with the mutex held.
+func (rt *JsRuntime) stateSize(max int64) (int64, error) {
+	global, err := rt.vm.Object("this")
+	if err != nil {
+		return 0, err
+	}
+	return rt.globalSize(global, max)
+}
+
+func (rt *JsRuntime) globalSize(global *otto.Object, max int64) (int64, error) {
+	w := &sizeWalker{max: max}
+	for _, key := range global.Keys() {
+		if rt.bound[key] {
+			continue
+		}
+		v, err := global.Get(key)
+		if err != nil {
+			return 0, err
+		}
+		w.size += int64(len(key))
+		w.walk(v, 0)
+		if w.done() {
+			break
+		}
+	}
+	return w.size, nil
+}
+
+const (
+	VALUE_SIZE    = 8
+	PROPERTY_SIZE = 16
+	OBJECT_SIZE   = 32
+	FUNCTION_SIZE = 64
+
+	MAX_WALK_DEPTH  = 32
+	MAX_WALK_VALUES = 100000
+)
+
+type sizeWalker struct {
+	size   int64
+	max    int64
+	values int
+}
+
+func (w *sizeWalker) done() bool {
+	return w.size > w.max || w.values >= MAX_WALK_VALUES
+}
+
+func (w *sizeWalker) walk(v otto.Value, depth int) {
+	if w.done() {
+		return
+	}
+	w.values++
+	switch {
+	case v.IsString():
+		w.size += VALUE_SIZE + int64(len(v.String()))
+	case v.IsFunction():
+		w.size += FUNCTION_SIZE
+	case v.IsObject():
+		w.size += OBJECT_SIZE
+		if depth >= MAX_WALK_DEPTH {
+			return
+		}
+		obj := v.Object()
+		for _, key := range obj.Keys() {
+			child, err := obj.Get(key)
+			if err != nil {
+				continue
+			}
+			w.size += PROPERTY_SIZE + int64(len(key))
+			w.walk(child, depth+1)
+			if w.done() {
+				return
+			}
+		}
+	default:
+		w.size += VALUE_SIZE
+	}
+}
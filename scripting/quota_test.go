@@ -0,0 +1,236 @@
+package scripting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/eris-ltd/decerver-interfaces/dapps"
+	"github.com/eris-ltd/decerver-interfaces/modules"
+)
+
+// A stand-in for a module like MonkJs or IpfsModule.
+type testModule struct {
+	Network string
+	txs     int
+	subs    map[string]bool
+}
+
+func (tm *testModule) Tx(addr, amt string) modules.JsObject {
+	tm.txs++
+	return modules.JsReturnValNoErr(addr)
+}
+
+func (tm *testModule) Commit() modules.JsObject {
+	return modules.JsReturnValNoErr(nil)
+}
+
+// Channels can not be passed to javascript, so a js facing subscribe returns a JsObject.
+func (tm *testModule) Subscribe(name, event, target string) modules.JsObject {
+	tm.subs[name] = true
+	return modules.JsReturnValNoErr(name)
+}
+
+func (tm *testModule) UnSubscribe(name string) {
+	delete(tm.subs, name)
+}
+
+func quotaRuntime(t *testing.T, q *dapps.Quotas) (*JsRuntime, *testModule) {
+	rm := NewRuntimeManager(nil)
+	tm := &testModule{subs: make(map[string]bool)}
+	rm.RegisterApiObject("monk", tm)
+	rt := rm.CreateRuntimeWithQuotas("dapp", q).(*JsRuntime)
+	err := rt.AddScript(`
+function tx(){ return monk.Tx("addr", "10"); }
+function commit(){ return monk.Commit(); }
+function sub(id){ monk.Subscribe(id, "newBlock", ""); return true; }
+function subErr(id){ return monk.Subscribe(id, "newBlock", ""); }
+function unsub(id){ monk.UnSubscribe(id); }
+var state = [];
+function grow(n){ for(var i = 0; i < n; i++){ state.push("0123456789"); } }
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rt, tm
+}
+
+func quotaName(t *testing.T, ret interface{}) string {
+	obj, ok := ret.(modules.JsObject)
+	if !ok {
+		t.Fatalf("Expected a JsObject, Got: %v (%T)", ret, ret)
+	}
+	if obj["Error"] == "" {
+		return ""
+	}
+	q, ok := obj["Quota"].(modules.JsObject)
+	if !ok {
+		t.Fatalf("Expected quota details in error, Got: %v", obj)
+	}
+	return q["Name"].(string)
+}
+
+func TestMethodQuota(t *testing.T) {
+	rt, tm := quotaRuntime(t, &dapps.Quotas{
+		MethodCallsPerSecond: map[string]float64{"monk.Tx": 2},
+	})
+	for i := 0; i < 2; i++ {
+		ret, err := rt.CallFunc("tx")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name := quotaName(t, ret); name != "" {
+			t.Fatalf("Call %d should have been allowed", i)
+		}
+	}
+	ret, err := rt.CallFunc("tx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := quotaName(t, ret); name != QUOTA_CALLS_PER_SECOND {
+		t.Fatalf("Expected %s error, Got: %v", QUOTA_CALLS_PER_SECOND, ret)
+	}
+	if tm.txs != 2 {
+		t.Fatalf("Expected 2 transactions, Got: %d", tm.txs)
+	}
+	// Other methods are not limited.
+	ret, _ = rt.CallFunc("commit")
+	if name := quotaName(t, ret); name != "" {
+		t.Fatal("Commit should not be limited")
+	}
+	// Tokens come back.
+	time.Sleep(600 * time.Millisecond)
+	ret, _ = rt.CallFunc("tx")
+	if name := quotaName(t, ret); name != "" {
+		t.Fatal("Expected the quota to be refilled")
+	}
+}
+
+func TestTotalCallQuota(t *testing.T) {
+	rt, _ := quotaRuntime(t, &dapps.Quotas{CallsPerSecond: 3})
+	limited := 0
+	for i := 0; i < 6; i++ {
+		ret, err := rt.CallFunc("commit")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if quotaName(t, ret) != "" {
+			limited++
+		}
+	}
+	if limited != 3 {
+		t.Fatalf("Expected 3 limited calls, Got: %d", limited)
+	}
+}
+
+func TestSubscriptionQuota(t *testing.T) {
+	rt, tm := quotaRuntime(t, &dapps.Quotas{MaxSubscriptions: 2})
+	for _, id := range []string{"a", "b", "a"} {
+		if ret, err := rt.CallFunc("sub", id); err != nil || ret != true {
+			t.Fatalf("Subscribe %s failed: %v, %v", id, ret, err)
+		}
+	}
+	ret, err := rt.CallFunc("subErr", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := quotaName(t, ret); name != QUOTA_SUBSCRIPTIONS {
+		t.Fatalf("Expected %s error, Got: %v", QUOTA_SUBSCRIPTIONS, ret)
+	}
+	if tm.subs["dapp/c"] {
+		t.Fatal("Module got a subscription that is over quota")
+	}
+	if err := rt.AddSubscription("d"); err == nil {
+		t.Fatal("Expected AddSubscription to be over quota")
+	}
+	if _, err := rt.CallFunc("unsub", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rt.AddSubscription("d"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemoryQuotaDuringCall(t *testing.T) {
+	rt, _ := quotaRuntime(t, &dapps.Quotas{MaxMemory: 10000})
+	rt.SetTimeout(10 * time.Second)
+	if err := rt.AddScript(`function hog(){ while(true){ try { state.push("0123456789"); } catch(e) {} } }`); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	_, err := rt.CallFunc("hog")
+	qerr, ok := err.(*QuotaError)
+	if !ok || qerr.Quota != QUOTA_MEMORY {
+		t.Fatalf("Expected %s error, Got: %v", QUOTA_MEMORY, err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("Expected the call to be stopped before the timeout")
+	}
+	if _, err := rt.CallFunc("commit"); err == nil {
+		t.Fatal("Expected the runtime to be stopped")
+	}
+}
+
+func TestMemoryQuota(t *testing.T) {
+	rt, _ := quotaRuntime(t, &dapps.Quotas{MaxMemory: 10000})
+	if _, err := rt.CallFunc("grow", 10); err != nil {
+		t.Fatal(err)
+	}
+	if rt.MemoryUsage() == 0 {
+		t.Fatal("Expected memory use to be measured")
+	}
+	// A cycle does not make the walk go on forever.
+	if err := rt.AddScript("var a = {}; a.self = a; state.push(a);"); err != nil {
+		t.Fatal(err)
+	}
+	_, err := rt.CallFunc("grow", 1000)
+	qerr, ok := err.(*QuotaError)
+	if !ok || qerr.Quota != QUOTA_MEMORY {
+		t.Fatalf("Expected %s error, Got: %v", QUOTA_MEMORY, err)
+	}
+	// Nothing runs after that.
+	if _, err := rt.CallFunc("commit"); err == nil {
+		t.Fatal("Expected the runtime to be stopped")
+	}
+}
+
+func TestNoQuotas(t *testing.T) {
+	rt, tm := quotaRuntime(t, nil)
+	for i := 0; i < 100; i++ {
+		rt.CallFunc("tx")
+	}
+	if tm.txs != 100 {
+		t.Fatalf("Expected 100 transactions, Got: %d", tm.txs)
+	}
+}
+
+// Fields are still there, with or without quotas.
+func TestWrappedFields(t *testing.T) {
+	for _, q := range []*dapps.Quotas{nil, &dapps.Quotas{CallsPerSecond: 100}} {
+		rt, tm := quotaRuntime(t, q)
+		tm.Network = "testnet"
+		if err := rt.AddScript(`function network(){ return monk.Network + ":" + monk.Commit().Error; }`); err != nil {
+			t.Fatal(err)
+		}
+		ret, err := rt.CallFunc("network")
+		if err != nil || ret != "testnet:" {
+			t.Fatalf("Quotas %v: expected the field and the method, Got: %v, %v", q, ret, err)
+		}
+	}
+}
+
+// Runtimes that use the same subscriber id do not share the subscription.
+func TestSharedModuleSubscriptions(t *testing.T) {
+	rm := NewRuntimeManager(nil)
+	tm := &testModule{subs: make(map[string]bool)}
+	rm.RegisterApiObject("monk", tm)
+	for _, name := range []string{"one", "two"} {
+		rt := rm.CreateRuntime(name)
+		if err := rt.AddScript(`monk.Subscribe("blocks", "newBlock", "");`); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rm.RemoveRuntime("one")
+	if len(tm.subs) != 1 || !tm.subs["two/blocks"] {
+		t.Fatalf("Expected the subscription of 'two' to be left, Got: %v", tm.subs)
+	}
+}
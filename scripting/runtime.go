@@ -0,0 +1,632 @@
+package scripting
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eris-ltd/decerver-interfaces/core"
+	"github.com/eris-ltd/decerver-interfaces/dapps"
+	"github.com/eris-ltd/decerver-interfaces/modules"
+	"github.com/robertkrimen/otto"
+)
+
+// JsRuntime is an implementation of core.Runtime on top of an otto vm. Otto
+// is not safe for concurrent use, so every call into the vm is serialized.
+//
+// Scripts can be interrupted: calls (and script loading) are stopped when their
+// context is done, when the runtime timeout passes, or when the runtime is shut down.
+type JsRuntime struct {
+	name  string
+	vm    *otto.Otto
+	mutex *sync.Mutex
+	// In nanoseconds, accessed atomically so it can be set while a script runs.
+	timeout int64
+	// Closed on shutdown.
+	closed    chan struct{}
+	closeOnce *sync.Once
+	// Names of bound objects.
+	bound  map[string]bool
+	quotas *quotaState
+	// State size after the last call, if there is a memory quota.
+	memory int64
+	// Subscriptions made through bound objects, by the subscriber id the
+	// script used (see subscriberName). They are cancelled on shutdown. Has its own mutex, since the runtime mutex is
+	// held while a script runs.
+	subsMutex *sync.Mutex
+	subs      map[string]unsubscriber
+}
+
+// Bound objects with this method (modules) have their subscriptions tracked.
+type unsubscriber interface {
+	UnSubscribe(name string)
+}
+
+// Sent through the otto interrupt channel to stop a script.
+type halt struct{}
+
+func NewJsRuntime(name string) *JsRuntime {
+	rt := &JsRuntime{}
+	rt.name = name
+	rt.vm = otto.New()
+	rt.mutex = &sync.Mutex{}
+	rt.closed = make(chan struct{})
+	rt.closeOnce = &sync.Once{}
+	rt.bound = make(map[string]bool)
+	rt.subsMutex = &sync.Mutex{}
+	rt.subs = make(map[string]unsubscriber)
+	return rt
+}
+
+func (rt *JsRuntime) Name() string {
+	return rt.name
+}
+
+// After shutdown every call returns an error. A script that is running is interrupted,
+// and the subscriptions the scripts made through bound objects are cancelled.
+func (rt *JsRuntime) Shutdown() {
+	rt.closeOnce.Do(func() {
+		rt.subsMutex.Lock()
+		close(rt.closed)
+		subs := rt.subs
+		rt.subs = make(map[string]unsubscriber)
+		rt.subsMutex.Unlock()
+		for id, obj := range subs {
+			obj.UnSubscribe(rt.subscriberName(id))
+		}
+	})
+}
+
+// The ids of the subscriptions made through bound objects that are still active.
+func (rt *JsRuntime) Subscriptions() []string {
+	rt.subsMutex.Lock()
+	defer rt.subsMutex.Unlock()
+	ids := make([]string, 0, len(rt.subs))
+	for id := range rt.subs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// The name a subscription made by a script has in the module. Every runtime
+// shares the modules, so the name of the runtime is put in front of the id.
+func (rt *JsRuntime) subscriberName(id string) string {
+	return rt.name + "/" + id
+}
+
+func (rt *JsRuntime) trackSubscription(id string, obj unsubscriber) {
+	rt.subsMutex.Lock()
+	select {
+	case <-rt.closed:
+		// Shut down while the script was subscribing.
+		rt.subsMutex.Unlock()
+		obj.UnSubscribe(rt.subscriberName(id))
+		return
+	default:
+	}
+	rt.subs[id] = obj
+	rt.subsMutex.Unlock()
+}
+
+func (rt *JsRuntime) untrackSubscription(id string) {
+	rt.subsMutex.Lock()
+	defer rt.subsMutex.Unlock()
+	delete(rt.subs, id)
+}
+
+func (rt *JsRuntime) SetTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&rt.timeout, int64(timeout))
+}
+
+func (rt *JsRuntime) Timeout() time.Duration {
+	return time.Duration(atomic.LoadInt64(&rt.timeout))
+}
+
+// Set resource quotas (nil for none). Objects that are bound afterwards are subject
+// to the call quotas, so this should be done before binding the api objects. The memory
+// quota is checked after every call, and every MEMORY_CHECK_INTERVAL while one runs. A
+// runtime that goes over it is stopped, and does not run anything else; every call
+// returns a QuotaError.
+func (rt *JsRuntime) SetQuotas(q *dapps.Quotas) {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	if q == nil {
+		rt.quotas = nil
+	} else {
+		rt.quotas = newQuotaState(rt.name, q)
+	}
+	rt.memory = 0
+}
+
+// Count a subscription made on behalf of this runtime against its quota. Calls to
+// Subscribe on bound objects are counted automatically.
+func (rt *JsRuntime) AddSubscription(id string) error {
+	rt.mutex.Lock()
+	qs := rt.quotas
+	rt.mutex.Unlock()
+	if qs == nil {
+		return nil
+	}
+	if qerr := qs.subscribe(id); qerr != nil {
+		return qerr
+	}
+	return nil
+}
+
+func (rt *JsRuntime) RemoveSubscription(id string) {
+	rt.mutex.Lock()
+	qs := rt.quotas
+	rt.mutex.Unlock()
+	if qs != nil {
+		qs.unsubscribe(id)
+	}
+}
+
+// The approximate size of the script state after the last call. Only measured
+// if there is a memory quota.
+func (rt *JsRuntime) MemoryUsage() int64 {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	return rt.memory
+}
+
+// Bind a value to a global name. If the runtime has quotas, the methods of objects
+// go through the call quotas (see SetQuotas). Subscriptions made through objects
+// that can UnSubscribe are tracked, so they can be cancelled on shutdown, and get
+// names that are unique to the runtime. Other objects are bound as they are.
+func (rt *JsRuntime) BindScriptObject(name string, val interface{}) error {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	if err := rt.check(); err != nil {
+		return err
+	}
+	var v otto.Value
+	var err error
+	_, isUnsubscriber := val.(unsubscriber)
+	if (rt.quotas != nil || isUnsubscriber) && val != nil && reflect.TypeOf(val).NumMethod() > 0 {
+		v, err = rt.limitObject(name, val)
+	} else {
+		v, err = rt.toValue(val)
+	}
+	if err != nil {
+		return err
+	}
+	rt.bound[name] = true
+	return rt.vm.Set(name, v)
+}
+
+func (rt *JsRuntime) LoadScriptFile(fileName string) error {
+	bytes, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	if err := rt.AddScript(string(bytes)); err != nil {
+		return fmt.Errorf("Error in script file '%s': %s", fileName, err)
+	}
+	return nil
+}
+
+// Load a number of files, in order. Stops at the first error.
+func (rt *JsRuntime) LoadScriptFiles(fileNames ...string) error {
+	for _, fileName := range fileNames {
+		if err := rt.LoadScriptFile(fileName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run a script. The runtime timeout applies.
+func (rt *JsRuntime) AddScript(script string) error {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	if err := rt.check(); err != nil {
+		return err
+	}
+	_, err := rt.run(context.Background(), func() (otto.Value, error) {
+		return rt.vm.Run(script)
+	})
+	return err
+}
+
+// Call a global function. See CallFuncOnObj for how params and return values
+// are converted.
+func (rt *JsRuntime) CallFunc(funcName string, params ...interface{}) (interface{}, error) {
+	return rt.CallFuncContext(context.Background(), funcName, params...)
+}
+
+// Call a method on a global object. Params are converted to javascript: JsObjects,
+// maps and slices become plain javascript objects and arrays, numbers become numbers.
+// Objects that are returned become JsObjects, arrays become []interface{}, and every
+// number becomes a float64 (javascript has no integers), as with encoding/json.
+func (rt *JsRuntime) CallFuncOnObj(objName, funcName string, params ...interface{}) (interface{}, error) {
+	return rt.CallFuncOnObjContext(context.Background(), objName, funcName, params...)
+}
+
+// CallFunc that is interrupted when ctx is done. The runtime timeout applies as
+// well, unless ctx has a deadline of its own. If the deadline passes the error
+// is core.ErrScriptTimeout.
+func (rt *JsRuntime) CallFuncContext(ctx context.Context, funcName string, params ...interface{}) (interface{}, error) {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	if err := rt.check(); err != nil {
+		return nil, err
+	}
+	fn, err := rt.vm.Get(funcName)
+	if err != nil {
+		return nil, err
+	}
+	if !fn.IsFunction() {
+		return nil, fmt.Errorf("No function '%s' in runtime '%s'", funcName, rt.name)
+	}
+	return rt.call(ctx, fn, otto.NullValue(), params)
+}
+
+// CallFuncOnObj that is interrupted when ctx is done. See CallFuncContext.
+func (rt *JsRuntime) CallFuncOnObjContext(ctx context.Context, objName, funcName string, params ...interface{}) (interface{}, error) {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	if err := rt.check(); err != nil {
+		return nil, err
+	}
+	obj, err := rt.vm.Get(objName)
+	if err != nil {
+		return nil, err
+	}
+	if !obj.IsObject() {
+		return nil, fmt.Errorf("No object '%s' in runtime '%s'", objName, rt.name)
+	}
+	fn, err := obj.Object().Get(funcName)
+	if err != nil {
+		return nil, err
+	}
+	if !fn.IsFunction() {
+		return nil, fmt.Errorf("No function '%s.%s' in runtime '%s'", objName, funcName, rt.name)
+	}
+	return rt.call(ctx, fn, obj, params)
+}
+
+// Must be called with the mutex held.
+func (rt *JsRuntime) call(ctx context.Context, fn, this otto.Value, params []interface{}) (interface{}, error) {
+	args := make([]interface{}, len(params))
+	for i, p := range params {
+		v, err := rt.toValue(p)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	ret, err := rt.run(ctx, func() (otto.Value, error) {
+		return fn.Call(this, args...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fromValue(ret)
+}
+
+// Run 'f' in the vm, and interrupt it when ctx is done, the timeout passes or
+// the runtime is shut down. Must be called with the mutex held.
+func (rt *JsRuntime) run(ctx context.Context, f func() (otto.Value, error)) (ret otto.Value, err error) {
+	if _, ok := ctx.Deadline(); !ok {
+		if timeout := rt.Timeout(); timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return otto.UndefinedValue(), ctxError(err)
+	}
+	if qerr := rt.checkMemory(); qerr != nil {
+		return otto.UndefinedValue(), qerr
+	}
+
+	// The interrupt function runs in the vm, and a script can catch the panic with
+	// try/catch, so keep interrupting until the script gives up. 'halted' and
+	// 'overMemory' are only touched by the vm (this goroutine).
+	halted := false
+	stop := func() {
+		halted = true
+		panic(halt{})
+	}
+	// With a memory quota, the state is also measured while the call runs, every
+	// MEMORY_CHECK_INTERVAL. Going over stops the call like a timeout does.
+	overMemory := false
+	over := make(chan struct{})
+	var measure func()
+	if rt.quotas != nil && rt.quotas.quotas.MaxMemory > 0 {
+		if global, gerr := rt.vm.Object("this"); gerr == nil {
+			max := rt.quotas.quotas.MaxMemory
+			measure = func() {
+				if overMemory {
+					return
+				}
+				if size, err := rt.globalSize(global, max); err == nil && size > max {
+					rt.memory = size
+					overMemory = true
+					close(over)
+					stop()
+				}
+			}
+		}
+	}
+	interrupt := make(chan func(), 1)
+	rt.vm.Interrupt = interrupt
+	done := make(chan struct{})
+	watching := make(chan struct{})
+	go func() {
+		defer close(watching)
+		var tick <-chan time.Time
+		if measure != nil {
+			ticker := time.NewTicker(MEMORY_CHECK_INTERVAL)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				break wait
+			case <-rt.closed:
+				break wait
+			case <-over:
+				break wait
+			case <-done:
+				return
+			case <-tick:
+				// Skipped if the last one has not run yet.
+				select {
+				case interrupt <- measure:
+				default:
+				}
+			}
+		}
+		for {
+			select {
+			case interrupt <- stop:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	defer func() {
+		close(done)
+		<-watching
+		// Drop an interrupt that came too late, so it does not hit the next call.
+		rt.vm.Interrupt = nil
+		caught := recover()
+		if !halted {
+			if caught != nil {
+				panic(caught)
+			}
+			return
+		}
+		ret = otto.UndefinedValue()
+		if overMemory {
+			err = &QuotaError{rt.name, QUOTA_MEMORY, "", float64(rt.quotas.quotas.MaxMemory)}
+		} else if cerr := ctx.Err(); cerr != nil {
+			err = ctxError(cerr)
+		} else {
+			err = fmt.Errorf("Runtime '%s' has been shut down", rt.name)
+		}
+	}()
+
+	ret, err = f()
+	if err != nil {
+		err = jsError(err)
+	}
+	if qerr := rt.measureMemory(); qerr != nil {
+		return otto.UndefinedValue(), qerr
+	}
+	return
+}
+
+// Returns an error if the runtime was over its memory quota after the last call.
+// Must be called with the mutex held.
+func (rt *JsRuntime) checkMemory() *QuotaError {
+	if rt.quotas == nil || rt.quotas.quotas.MaxMemory <= 0 {
+		return nil
+	}
+	max := rt.quotas.quotas.MaxMemory
+	if rt.memory > max {
+		return &QuotaError{rt.name, QUOTA_MEMORY, "", float64(max)}
+	}
+	return nil
+}
+
+// Must be called with the mutex held.
+func (rt *JsRuntime) measureMemory() *QuotaError {
+	if rt.quotas == nil || rt.quotas.quotas.MaxMemory <= 0 {
+		return nil
+	}
+	size, err := rt.stateSize(rt.quotas.quotas.MaxMemory)
+	if err != nil {
+		logger.Printf("Could not measure the memory use of runtime '%s': %s\n", rt.name, err)
+		return nil
+	}
+	rt.memory = size
+	return rt.checkMemory()
+}
+
+func ctxError(err error) error {
+	if err == context.DeadlineExceeded {
+		return core.ErrScriptTimeout
+	}
+	return err
+}
+
+func (rt *JsRuntime) check() error {
+	select {
+	case <-rt.closed:
+		return fmt.Errorf("Runtime '%s' has been shut down", rt.name)
+	default:
+	}
+	return nil
+}
+
+// Convert a go value to a javascript value. Objects and arrays are built in the
+// vm, so that scripts get real javascript objects (JSON.stringify, Object.keys
+// etc. work as expected) rather than wrapped go maps and slices.
+func (rt *JsRuntime) toValue(val interface{}) (otto.Value, error) {
+	switch v := val.(type) {
+	case otto.Value:
+		return v, nil
+	case modules.JsObject:
+		return rt.objectValue(v)
+	case map[string]interface{}:
+		return rt.objectValue(v)
+	case []interface{}:
+		return rt.arrayValue(v)
+	case []string:
+		arr := make([]interface{}, len(v))
+		for i, s := range v {
+			arr[i] = s
+		}
+		return rt.arrayValue(arr)
+	}
+	return rt.vm.ToValue(val)
+}
+
+func (rt *JsRuntime) objectValue(m map[string]interface{}) (otto.Value, error) {
+	obj, err := rt.vm.Object("({})")
+	if err != nil {
+		return otto.UndefinedValue(), err
+	}
+	for k, e := range m {
+		v, err := rt.toValue(e)
+		if err != nil {
+			return otto.UndefinedValue(), err
+		}
+		if err := obj.Set(k, v); err != nil {
+			return otto.UndefinedValue(), err
+		}
+	}
+	return obj.Value(), nil
+}
+
+func (rt *JsRuntime) arrayValue(s []interface{}) (otto.Value, error) {
+	arr, err := rt.vm.Object("[]")
+	if err != nil {
+		return otto.UndefinedValue(), err
+	}
+	elems := make([]interface{}, len(s))
+	for i, e := range s {
+		v, err := rt.toValue(e)
+		if err != nil {
+			return otto.UndefinedValue(), err
+		}
+		elems[i] = v
+	}
+	if len(elems) > 0 {
+		if _, err := arr.Call("push", elems...); err != nil {
+			return otto.UndefinedValue(), err
+		}
+	}
+	return arr.Value(), nil
+}
+
+// Convert a javascript value to go. Undefined and null become nil.
+func fromValue(v otto.Value) (interface{}, error) {
+	if v.IsUndefined() || v.IsNull() {
+		return nil, nil
+	}
+	exp, err := v.Export()
+	if err != nil {
+		return nil, err
+	}
+	return normalize(exp), nil
+}
+
+func normalize(val interface{}) interface{} {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		obj := make(modules.JsObject)
+		for k, e := range v {
+			obj[k] = normalize(e)
+		}
+		return obj
+	// Go values that were passed through javascript are copied, not changed.
+	case modules.JsObject:
+		obj := make(modules.JsObject)
+		for k, e := range v {
+			obj[k] = normalize(e)
+		}
+		return obj
+	case []interface{}:
+		arr := make([]interface{}, len(v))
+		for i, e := range v {
+			arr[i] = normalize(e)
+		}
+		return arr
+	// Arrays with elements of a single type are exported as typed slices.
+	case []map[string]interface{}:
+		arr := make([]interface{}, len(v))
+		for i, e := range v {
+			arr[i] = normalize(e)
+		}
+		return arr
+	case []string:
+		arr := make([]interface{}, len(v))
+		for i, e := range v {
+			arr[i] = e
+		}
+		return arr
+	case []bool:
+		arr := make([]interface{}, len(v))
+		for i, e := range v {
+			arr[i] = e
+		}
+		return arr
+	case []int64:
+		arr := make([]interface{}, len(v))
+		for i, e := range v {
+			arr[i] = float64(e)
+		}
+		return arr
+	case []float64:
+		arr := make([]interface{}, len(v))
+		for i, e := range v {
+			arr[i] = e
+		}
+		return arr
+	case int:
+		return float64(v)
+	case int8:
+		return float64(v)
+	case int16:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint:
+		return float64(v)
+	case uint8:
+		return float64(v)
+	case uint16:
+		return float64(v)
+	case uint32:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	}
+	return val
+}
+
+// Include the javascript stack trace, if there is one.
+func jsError(err error) error {
+	if jerr, ok := err.(*otto.Error); ok {
+		return fmt.Errorf("%s", jerr.String())
+	}
+	return err
+}
@@ -0,0 +1,289 @@
+package scripting
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/eris-ltd/decerver-interfaces/core"
+	"github.com/eris-ltd/decerver-interfaces/modules"
+)
+
+// A stand-in for a module api object like MonkJs.
+type testApi struct {
+	counter int
+}
+
+func (t *testApi) Increment(n int) modules.JsObject {
+	t.counter += n
+	return modules.JsReturnValNoErr(t.counter)
+}
+
+func (t *testApi) Keys(obj map[string]interface{}) []string {
+	keys := make([]string, 0)
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Like the 'esl' script in monkjs, it wraps the api object.
+var testScript = `
+var wrapped = {
+	"inc" : function(n){
+		return api.Increment(n).Data;
+	}
+};
+`
+
+func TestRuntimeManagerBinding(t *testing.T) {
+	rm := NewRuntimeManager(nil)
+	var _ core.RuntimeManager = rm
+	api := &testApi{}
+	rm.RegisterApiObject("api", api)
+	rm.RegisterApiScript(testScript)
+
+	rt := rm.CreateRuntime("dapp")
+	ret, err := rt.CallFuncOnObj("wrapped", "inc", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret != float64(3) {
+		t.Fatalf("Expected: 3, Got: %v (%T)", ret, ret)
+	}
+	if api.counter != 3 {
+		t.Fatalf("Expected counter 3, Got: %d", api.counter)
+	}
+	if rm.GetRuntime("dapp") != rt {
+		t.Fatal("GetRuntime did not return the runtime")
+	}
+	if rm.GetRuntime("nothere") != nil {
+		t.Fatal("Expected nil for a missing runtime")
+	}
+
+	// Objects registered later are bound into existing runtimes.
+	rm.RegisterApiObject("late", &testApi{})
+	if _, err := rt.CallFuncOnObj("late", "Increment", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	rm.RemoveRuntime("dapp")
+	if rm.GetRuntime("dapp") != nil {
+		t.Fatal("Runtime was not removed")
+	}
+	if _, err := rt.CallFuncOnObj("wrapped", "inc", 1); err == nil {
+		t.Fatal("Expected an error from a removed runtime")
+	}
+}
+
+func TestConversion(t *testing.T) {
+	rt := NewJsRuntime("test")
+	err := rt.AddScript(`
+function echo(x){ return x; }
+function describe(obj, arr){
+	return {
+		"json" : JSON.stringify(obj),
+		"isArray" : Array.isArray(arr),
+		"length" : arr.length,
+		"sum" : arr[0] + arr[1]
+	};
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := modules.JsObject{"Data": "x", "Error": ""}
+	ret, err := rt.CallFunc("describe", obj, []interface{}{1, int64(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := modules.JsObject{
+		"json":    `{"Data":"x","Error":""}`,
+		"isArray": true,
+		"length":  float64(2),
+		"sum":     float64(3),
+	}
+	if !reflect.DeepEqual(ret, exp) {
+		t.Fatalf("Expected: %v, Got: %v", exp, ret)
+	}
+
+	nested := map[string]interface{}{
+		"list": []interface{}{"a", map[string]interface{}{"n": 5}},
+		"n":    uint64(7),
+	}
+	ret, err = rt.CallFunc("echo", nested)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expNested := modules.JsObject{
+		"list": []interface{}{"a", modules.JsObject{"n": float64(5)}},
+		"n":    float64(7),
+	}
+	if !reflect.DeepEqual(ret, expNested) {
+		t.Fatalf("Expected: %v, Got: %v", expNested, ret)
+	}
+
+	ret, err = rt.CallFunc("echo", []string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ret, []interface{}{"a", "b"}) {
+		t.Fatalf("Expected: [a b], Got: %v (%T)", ret, ret)
+	}
+
+	ret, err = rt.CallFunc("echo", nil)
+	if err != nil || ret != nil {
+		t.Fatalf("Expected nil, Got: %v, %v", ret, err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	rt := NewJsRuntime("test")
+	rt.AddScript(`var notfn = 5; function fail(){ throw new Error("boom"); }`)
+	if _, err := rt.CallFunc("nothere"); err == nil {
+		t.Error("Expected an error for a missing function")
+	}
+	if _, err := rt.CallFunc("notfn"); err == nil {
+		t.Error("Expected an error when calling a number")
+	}
+	if _, err := rt.CallFuncOnObj("nothere", "f"); err == nil {
+		t.Error("Expected an error for a missing object")
+	}
+	if _, err := rt.CallFunc("fail"); err == nil {
+		t.Error("Expected the javascript error to be returned")
+	}
+	if err := rt.AddScript("var x = ;"); err == nil {
+		t.Error("Expected a syntax error")
+	}
+}
+
+func TestLoadScriptFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scripting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a := filepath.Join(dir, "a.js")
+	b := filepath.Join(dir, "b.js")
+	ioutil.WriteFile(a, []byte("var base = 40;"), 0600)
+	ioutil.WriteFile(b, []byte("function answer(){ return base + 2; }"), 0600)
+
+	rt := NewJsRuntime("test")
+	if err := rt.LoadScriptFiles(a, b); err != nil {
+		t.Fatal(err)
+	}
+	ret, err := rt.CallFunc("answer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret != float64(42) {
+		t.Fatalf("Expected: 42, Got: %v", ret)
+	}
+	if err := rt.LoadScriptFile(filepath.Join(dir, "missing.js")); err == nil {
+		t.Fatal("Expected an error for a missing file")
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	rm := NewRuntimeManager(&core.DCConfig{ScriptTimeout: 50})
+	rt := rm.CreateRuntime("looping")
+	if rt.Timeout() != 50*time.Millisecond {
+		t.Fatalf("Expected timeout 50ms, Got: %v", rt.Timeout())
+	}
+	err := rt.AddScript(`
+var obj = {
+	"spin" : function(){ while(true){} }
+};
+function spin(){
+	for(;;){ try { obj.spin(); } catch(e) {} }
+}
+function ok(){ return 1; }
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := rt.CallFunc("spin"); err != core.ErrScriptTimeout {
+		t.Fatalf("Expected ErrScriptTimeout, Got: %v", err)
+	}
+	if _, err := rt.CallFuncOnObj("obj", "spin"); err != core.ErrScriptTimeout {
+		t.Fatalf("Expected ErrScriptTimeout, Got: %v", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Fatal("Timeout took too long")
+	}
+	// The runtime is still usable.
+	if ret, err := rt.CallFunc("ok"); err != nil || ret != float64(1) {
+		t.Fatalf("Expected 1, Got: %v, %v", ret, err)
+	}
+
+	// A context deadline replaces the default.
+	rt.SetTimeout(time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := rt.CallFuncContext(ctx, "spin"); err != core.ErrScriptTimeout {
+		t.Fatalf("Expected ErrScriptTimeout, Got: %v", err)
+	}
+
+	// Cancelling is not a timeout.
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := rt.CallFuncOnObjContext(ctx, "obj", "spin"); err != context.Canceled {
+		t.Fatalf("Expected context.Canceled, Got: %v", err)
+	}
+
+	// Top level scripts are limited too.
+	rt.SetTimeout(20 * time.Millisecond)
+	if err := rt.AddScript("while(true){}"); err != core.ErrScriptTimeout {
+		t.Fatalf("Expected ErrScriptTimeout, Got: %v", err)
+	}
+}
+
+func TestShutdownInterrupts(t *testing.T) {
+	rt := NewJsRuntime("test")
+	rt.AddScript("function spin(){ while(true){} }")
+	errc := make(chan error)
+	go func() {
+		_, err := rt.CallFunc("spin")
+		errc <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	rt.Shutdown()
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Fatal("Expected an error")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Shutdown did not interrupt the script")
+	}
+}
+
+func TestShutdownCancelsSubscriptions(t *testing.T) {
+	rm := NewRuntimeManager(nil)
+	tm := &testModule{subs: make(map[string]bool)}
+	rm.RegisterApiObject("monk", tm)
+	rt := rm.CreateRuntime("dapp").(*JsRuntime)
+	err := rt.AddScript(`
+monk.Subscribe("a", "newBlock", "");
+monk.Subscribe("b", "newBlock", "");
+monk.UnSubscribe("a");
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subs := rt.Subscriptions(); len(subs) != 1 || subs[0] != "b" {
+		t.Fatalf("Expected subscription 'b', got: %v", subs)
+	}
+	rm.RemoveRuntime("dapp")
+	if len(tm.subs) != 0 {
+		t.Errorf("Expected no subscriptions after shutdown, got: %v", tm.subs)
+	}
+	if len(rt.Subscriptions()) != 0 {
+		t.Error("Expected the runtime to forget its subscriptions")
+	}
+}